@@ -6,24 +6,23 @@ import (
 	"github.com/mattfenwick/kube-prototypes/pkg/kube"
 	"github.com/mattfenwick/kube-prototypes/pkg/kube/netpol/examples"
 	"github.com/mattfenwick/kube-prototypes/pkg/netpol/crd"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/inventory"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/matcher"
 	"github.com/mattfenwick/kube-prototypes/pkg/netpol/utils"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"os/exec"
 	"time"
 )
 
-func convertNewToKubePols(policies ...*crd.Policy) []*networkingv1.NetworkPolicy {
-	var kubePols []*networkingv1.NetworkPolicy
-	for _, pol := range policies {
-		netpols := crd.Reduce(pol)
-		for _, netpol := range netpols {
-			kubePols = append(kubePols, netpol)
-		}
-	}
-	return kubePols
+func convertNewToKubePols(inv *inventory.Inventory, policies ...*crd.Policy) []*networkingv1.NetworkPolicy {
+	reduction, err := crd.ReduceAll(policies, inv)
+	utils.DoOrDie(err)
+	return reduction.NetworkPolicies
 }
 
 func main() {
@@ -54,7 +53,7 @@ func main() {
 
 	// 2. translate new -> kube
 	log.Infof("converting policies from new format to kube:")
-	newToKube()
+	newToKube(k8s)
 
 	// 3. install some daemonsets
 	namespaceList := []string{"d1", "d2"} //, "d3"}
@@ -72,6 +71,19 @@ func main() {
 		}
 	}
 
+	kubeNamespaces, err := k8s.GetAllNamespaces()
+	utils.DoOrDie(err)
+	kubePods, err := k8s.GetPodsInNamespaces(namespaceList)
+	utils.DoOrDie(err)
+	kubeNodes, err := k8s.GetAllNodes()
+	utils.DoOrDie(err)
+	inv := inventory.FromKube(kubeNamespaces, kubePods)
+	inv.AddNodesFromKube(kubeNodes)
+	universe := &crd.Universe{
+		Inventory: inv,
+		Ports:     []*matcher.PortProtocol{{Protocol: v1.ProtocolTCP, Port: intstr.FromInt(7890)}},
+	}
+
 	// 4. run some probes
 	initialResults, err := k8s.ProbePodToPod(namespaceList, 2)
 	utils.DoOrDie(err)
@@ -80,7 +92,7 @@ func main() {
 
 	// 5. install a few netpols
 	polGroups := [][]*networkingv1.NetworkPolicy{
-		convertNewToKubePols( // TODO these policies don't work right, kube corner cases are hard to work with
+		convertNewToKubePols(inv, // TODO these policies don't work right, kube corner cases are hard to work with
 			//   to deny, they should: select stuff in the target, and select *nothing* in
 			//   the peers
 			crd.DenyEgressFromNamespace("d1"),
@@ -110,6 +122,14 @@ func main() {
 		for _, cleanNs := range []string{"default", "d1", "d2"} {
 			utils.DoOrDie(k8s.CleanNetworkPolicies(cleanNs))
 		}
+		roundTrip, err := crd.VerifyRoundTrip(pols, universe)
+		utils.DoOrDie(err)
+		for _, disagreement := range roundTrip.Built {
+			log.Warnf("crd policies disagree with v1 policies: %s", disagreement)
+		}
+		for _, disagreement := range roundTrip.Reduced {
+			log.Warnf("round-tripped v1 policies disagree with crd policies: %s", disagreement)
+		}
 		for _, pol := range pols {
 			nYaml, err := yaml.Marshal(pol)
 			utils.DoOrDie(err)
@@ -144,10 +164,20 @@ func kubeToNew() {
 	fmt.Printf("%s\n\n", yamlBytes)
 }
 
-func newToKube() {
+func newToKube(k8s *kube.Kubernetes) {
 	// this -> kube
+	kubeNamespaces, err := k8s.GetAllNamespaces()
+	utils.DoOrDie(err)
 	np := crd.DenyAll
-	kubeNetPols := crd.Reduce(np)
+	reduction, err := crd.Reduce(np, inventory.FromKube(kubeNamespaces, nil))
+	utils.DoOrDie(err)
+	for _, ns := range reduction.SortedNamespaces() {
+		log.Infof("generated policies in namespace %s: %+v", ns, reduction.Namespaces[ns])
+	}
+	for _, issue := range reduction.Issues {
+		log.Warnf("reduction issue: %s", issue)
+	}
+	kubeNetPols := reduction.NetworkPolicies
 	bytes, err := json.MarshalIndent(kubeNetPols, "", "  ")
 	utils.DoOrDie(err)
 	fmt.Printf("%s\n\n", bytes)
@@ -8,59 +8,436 @@ import (
 	"github.com/mattfenwick/kube-prototypes/pkg/kube/netpol/examples"
 	"github.com/mattfenwick/kube-prototypes/pkg/netpol"
 	"github.com/mattfenwick/kube-prototypes/pkg/netpol/explainer"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/inventory"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/linter"
 	"github.com/mattfenwick/kube-prototypes/pkg/netpol/matcher"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/simulator"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/snapshot"
 	"github.com/mattfenwick/kube-prototypes/pkg/netpol/utils"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/validation"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
 	v1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
-	"os"
+	"net"
+	"sigs.k8s.io/yaml"
 )
 
+type Flags struct {
+	Verbosity string
+}
+
+func setupCommand() *cobra.Command {
+	flags := &Flags{}
+	command := &cobra.Command{
+		Use:   "netpol-explainer",
+		Short: "explain and simulate network policies",
+		Long:  "explain and simulate network policies",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return utils.SetUpLogger(flags.Verbosity)
+		},
+	}
+
+	command.PersistentFlags().StringVarP(&flags.Verbosity, "verbosity", "v", "info", "log level; one of [info, debug, trace, warn, error, fatal, panic]")
+
+	command.AddCommand(SetupExplainCommand())
+	command.AddCommand(SetupSimulateCommand())
+	command.AddCommand(SetupDiffCommand())
+	command.AddCommand(SetupLintCommand())
+	command.AddCommand(SetupSnapshotCommand())
+
+	return command
+}
+
 func main() {
-	explainerType := os.Args[1]
-	ns := v1.NamespaceAll
-	if len(os.Args) > 2 {
-		ns = os.Args[2]
+	command := setupCommand()
+	err := errors.Wrapf(command.Execute(), "run root command")
+	utils.DoOrDie(err)
+
+	if false {
+		mungeNetworkPolicies()
 	}
-	// sourceType := os.Args[3]
+}
 
-	// 1. source of policies
-	var netpols *networkingv1.NetworkPolicyList
-	if true {
-		kubeClient, err := kube.NewKubernetes()
-		utils.DoOrDie(err)
-		netpols, err = kubeClient.ClientSet.NetworkingV1().NetworkPolicies(ns).List(context.TODO(), metav1.ListOptions{})
-		utils.DoOrDie(err)
-	} else {
-		panic("TODO read some files?")
+type ExplainArgs struct {
+	Type       string
+	Namespace  string
+	PolicyPath string
+	Examples   bool
+}
+
+func SetupExplainCommand() *cobra.Command {
+	args := &ExplainArgs{}
+
+	command := &cobra.Command{
+		Use:   "explain",
+		Short: "explain network policies",
+		Long:  "explain network policies, either from a cluster or from files",
+		Args:  cobra.ExactArgs(0),
+		Run: func(cmd *cobra.Command, as []string) {
+			runExplain(args)
+		},
 	}
 
+	command.Flags().StringVar(&args.Type, "type", "matcher", "type of explanation; one of [explainer, matcher]")
+	command.Flags().StringVarP(&args.Namespace, "namespace", "n", v1.NamespaceAll, "namespace to read policies from; if reading policies from files, the namespace of policies which don't specify one")
+	command.Flags().StringVar(&args.PolicyPath, "policy-path", "", "file or directory to read policies from; if empty, policies are read from the cluster")
+	command.Flags().BoolVar(&args.Examples, "examples", false, "also explain the built-in example policies")
+
+	return command
+}
+
+func runExplain(args *ExplainArgs) {
+	// 1. source of policies
+	policies, err := readPolicies(args.PolicyPath, args.Namespace)
+	utils.DoOrDie(err)
+
 	// 2. consume policies
-	if explainerType == "explainer" {
-		for _, policy := range netpols.Items {
-			explanation := explainer.ExplainPolicy(&policy)
+	if args.Type == "explainer" {
+		for _, policy := range policies {
+			explanation := explainer.ExplainPolicy(policy)
 			printJSON(explanation)
 		}
 	} else {
-		policies := make([]*networkingv1.NetworkPolicy, len(netpols.Items))
-		for i := 0; i < len(netpols.Items); i++ {
-			policies[i] = &netpols.Items[i]
-		}
 		explainedPolicies := matcher.BuildNetworkPolicies(policies)
 		printJSON(explainedPolicies)
 		fmt.Printf("%s\n\n", matcher.Explain(explainedPolicies))
 	}
 
-	if true {
+	if args.Examples {
 		explainedPolicies := matcher.BuildNetworkPolicies(examples.AllExamples)
 		printJSON(explainedPolicies)
 		fmt.Printf("%s\n\n", matcher.Explain(explainedPolicies))
 	}
+}
 
-	if false {
-		mungeNetworkPolicies()
+// readPolicies reads policies from files if a path is given, otherwise from the cluster.
+// Policies from files are validated, since the API server hasn't checked them.
+func readPolicies(policyPath string, namespace string) ([]*networkingv1.NetworkPolicy, error) {
+	if policyPath != "" {
+		return validation.ReadNetworkPolicyFiles(policyPath, namespace)
+	}
+	kubeClient, err := kube.NewKubernetes()
+	if err != nil {
+		return nil, err
+	}
+	netpols, err := kubeClient.ClientSet.NetworkingV1().NetworkPolicies(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list network policies in namespace '%s'", namespace)
+	}
+	policies := make([]*networkingv1.NetworkPolicy, len(netpols.Items))
+	for i := 0; i < len(netpols.Items); i++ {
+		policies[i] = &netpols.Items[i]
+	}
+	return policies, nil
+}
+
+type SimulateArgs struct {
+	Namespace     string
+	PolicyPath    string
+	InventoryPath string
+	SnapshotPath  string
+	Ports         []string
+	Explain       bool
+	CNIProfile    *matcher.CNIProfile
+}
+
+func SetupSimulateCommand() *cobra.Command {
+	args := &SimulateArgs{}
+
+	command := &cobra.Command{
+		Use:   "simulate",
+		Short: "simulate network policies",
+		Long:  "compute expected pod -> pod reachability from network policy and inventory files, without a cluster",
+		Args:  cobra.ExactArgs(0),
+		Run: func(cmd *cobra.Command, as []string) {
+			runSimulate(args)
+		},
+	}
+
+	command.Flags().StringVarP(&args.Namespace, "namespace", "n", v1.NamespaceDefault, "namespace of policies which don't specify one")
+	command.Flags().StringVar(&args.PolicyPath, "policy-path", "", "file or directory to read policies from; required without a snapshot")
+	command.Flags().StringVar(&args.InventoryPath, "inventory", "", "file describing namespaces and pods; required without a snapshot")
+	command.Flags().StringVar(&args.SnapshotPath, "snapshot", "", "snapshot file to read both policies and inventory from, instead of policy and inventory files")
+
+	command.Flags().StringSliceVar(&args.Ports, "port", []string{}, "ports to simulate, of the form 80, 53/UDP or http/TCP; if empty, every container port in the inventory is simulated")
+	command.Flags().BoolVar(&args.Explain, "explain", false, "explain the policies before simulating them")
+	args.CNIProfile = simulator.AddCNIProfileFlags(command)
+
+	return command
+}
+
+func runSimulate(args *SimulateArgs) {
+	var policies []*networkingv1.NetworkPolicy
+	var inv *inventory.Inventory
+	if args.SnapshotPath != "" {
+		snap, err := snapshot.ReadFile(args.SnapshotPath)
+		utils.DoOrDie(err)
+		policies, inv = snap.NetworkPolicies, snap.Inventory
+	} else {
+		if args.PolicyPath == "" || args.InventoryPath == "" {
+			utils.DoOrDie(errors.Errorf("--policy-path and --inventory are required without --snapshot"))
+		}
+		var err error
+		policies, err = readPolicies(args.PolicyPath, args.Namespace)
+		utils.DoOrDie(err)
+		inv, err = inventory.ReadInventoryFile(args.InventoryPath)
+		utils.DoOrDie(err)
+	}
+
+	var ports []*matcher.PortProtocol
+	for _, portString := range args.Ports {
+		port, err := simulator.ParsePortProtocol(portString)
+		utils.DoOrDie(err)
+		ports = append(ports, port)
+	}
+	if len(ports) == 0 {
+		ports = simulator.Ports(inv)
+	}
+
+	policy := matcher.BuildNetworkPolicies(policies)
+	policy.CNIProfile = args.CNIProfile
+	if args.Explain {
+		fmt.Printf("%s\n\n", matcher.Explain(policy))
+	}
+
+	for _, simulation := range simulator.SimulatePorts(policy, inv, ports) {
+		fmt.Printf("%s:\n", simulation.Description())
+		simulation.Table.Table().Render()
+		if missing := simulation.MissingPodsDescription(); missing != "" {
+			fmt.Println(missing)
+		}
+		fmt.Println()
+	}
+
+	if blocked := simulator.CheckLivenessProbes(policy, inv); len(blocked) > 0 {
+		fmt.Printf("blocked liveness probes (%d):\n", len(blocked))
+		for _, probe := range blocked {
+			fmt.Printf("  %s\n", probe)
+		}
+	}
+}
+
+type DiffArgs struct {
+	Namespace     string
+	BeforePath    string
+	BeforeRef     string
+	AfterPath     string
+	InventoryPath string
+	Ports         []string
+	CNIProfile    *matcher.CNIProfile
+}
+
+func SetupDiffCommand() *cobra.Command {
+	args := &DiffArgs{}
+
+	command := &cobra.Command{
+		Use:   "diff",
+		Short: "show the reachability impact of changing network policies",
+		Long:  "compute which pod -> pod traffic a change to network policies newly allows or denies, and which policies are responsible",
+		Args:  cobra.ExactArgs(0),
+		Run: func(cmd *cobra.Command, as []string) {
+			runDiff(args)
+		},
+	}
+
+	command.Flags().StringVarP(&args.Namespace, "namespace", "n", v1.NamespaceAll, "namespace to read policies from; if reading policies from files, the namespace of policies which don't specify one")
+	command.Flags().StringVar(&args.BeforePath, "before-path", "", "file or directory to read the policies before the change from; if empty and there's no git ref, policies are read from the cluster")
+	command.Flags().StringVar(&args.BeforeRef, "before-ref", "", "git ref to read the policies before the change from, at the before path -- or if empty, the after path")
+	command.Flags().StringVar(&args.AfterPath, "after-path", "", "file or directory to read the policies after the change from; if empty, policies are read from the cluster")
+	command.Flags().StringVar(&args.InventoryPath, "inventory", "", "file describing namespaces and pods; if empty, they're read from the cluster")
+	command.Flags().StringSliceVar(&args.Ports, "port", []string{}, "ports to evaluate, of the form 80, 53/UDP or http/TCP; if empty, every container port in the inventory is evaluated")
+	args.CNIProfile = simulator.AddCNIProfileFlags(command)
+
+	return command
+}
+
+func runDiff(args *DiffArgs) {
+	var before []*networkingv1.NetworkPolicy
+	var err error
+	if args.BeforeRef != "" {
+		path := args.BeforePath
+		if path == "" {
+			path = args.AfterPath
+		}
+		before, err = validation.ReadNetworkPolicyFilesFromGitRef(args.BeforeRef, path, args.Namespace)
+		utils.DoOrDie(err)
+	} else {
+		before, err = readPolicies(args.BeforePath, args.Namespace)
+		utils.DoOrDie(err)
+	}
+	after, err := readPolicies(args.AfterPath, args.Namespace)
+	utils.DoOrDie(err)
+
+	inv, err := readInventory(args.InventoryPath)
+	utils.DoOrDie(err)
+
+	var ports []*matcher.PortProtocol
+	for _, portString := range args.Ports {
+		port, err := simulator.ParsePortProtocol(portString)
+		utils.DoOrDie(err)
+		ports = append(ports, port)
+	}
+	if len(ports) == 0 {
+		ports = simulator.Ports(inv)
+	}
+
+	beforePolicy, afterPolicy := matcher.BuildNetworkPolicies(before), matcher.BuildNetworkPolicies(after)
+	beforePolicy.CNIProfile, afterPolicy.CNIProfile = args.CNIProfile, args.CNIProfile
+
+	diff := simulator.Diff(beforePolicy, afterPolicy, inv, ports)
+	fmt.Printf("newly allowed (%d):\n", len(diff.Allowed))
+	for _, change := range diff.Allowed {
+		fmt.Printf("  %s\n", change)
+	}
+	fmt.Printf("newly denied (%d):\n", len(diff.Denied))
+	for _, change := range diff.Denied {
+		fmt.Printf("  %s\n", change)
+	}
+}
+
+type LintArgs struct {
+	Namespace     string
+	PolicyPath    string
+	InventoryPath string
+	SnapshotPath  string
+	PodCIDRs      []string
+	Output        string
+}
+
+func SetupLintCommand() *cobra.Command {
+	args := &LintArgs{}
+
+	command := &cobra.Command{
+		Use:   "lint",
+		Short: "find common mistakes in network policies",
+		Long:  "find common mistakes in network policies, such as peers allowing either a namespace or a pod instead of both, rules ignored because of policy types, or rules which other rules make redundant",
+		Args:  cobra.ExactArgs(0),
+		Run: func(cmd *cobra.Command, as []string) {
+			runLint(args)
+		},
+	}
+
+	command.Flags().StringVarP(&args.Namespace, "namespace", "n", v1.NamespaceAll, "namespace to read policies from; if reading policies from files, the namespace of policies which don't specify one")
+	command.Flags().StringVar(&args.PolicyPath, "policy-path", "", "file or directory to read policies from; if empty, policies are read from the cluster")
+	command.Flags().StringVar(&args.InventoryPath, "inventory", "", "file describing namespaces and pods; if empty, they're read from the cluster when policies are, and otherwise the checks needing them are skipped")
+	command.Flags().StringVar(&args.SnapshotPath, "snapshot", "", "snapshot file to read both policies and inventory from, instead of the policy path and inventory")
+	command.Flags().StringSliceVar(&args.PodCIDRs, "pod-cidr", []string{}, "CIDRs that pod IPs are allocated from")
+	command.Flags().StringVarP(&args.Output, "output", "o", "text", "output format; one of [text, json, sarif]")
+
+	return command
+}
+
+func runLint(args *LintArgs) {
+	config := &linter.Config{PodCIDRs: args.PodCIDRs}
+	for _, cidr := range args.PodCIDRs {
+		_, _, err := net.ParseCIDR(cidr)
+		utils.DoOrDie(errors.Wrapf(err, "invalid pod CIDR %s", cidr))
+	}
+
+	var policies []*networkingv1.NetworkPolicy
+	if args.SnapshotPath != "" {
+		snap, err := snapshot.ReadFile(args.SnapshotPath)
+		utils.DoOrDie(err)
+		policies, config.Inventory = snap.NetworkPolicies, snap.Inventory
+	} else {
+		var err error
+		policies, err = readPolicies(args.PolicyPath, args.Namespace)
+		utils.DoOrDie(err)
+		if args.InventoryPath != "" || args.PolicyPath == "" {
+			config.Inventory, err = readInventory(args.InventoryPath)
+			utils.DoOrDie(err)
+		}
+	}
+
+	findings := linter.Lint(policies, config)
+	switch args.Output {
+	case "text":
+		for _, finding := range findings {
+			fmt.Println(finding)
+		}
+	case "json":
+		if findings == nil {
+			findings = []*linter.Finding{}
+		}
+		printJSON(findings)
+	case "sarif":
+		printJSON(linter.ToSARIF(findings))
+	default:
+		utils.DoOrDie(errors.Errorf("invalid output format %s", args.Output))
+	}
+}
+
+type SnapshotArgs struct {
+	Namespaces []string
+	Output     string
+}
+
+func SetupSnapshotCommand() *cobra.Command {
+	args := &SnapshotArgs{}
+
+	command := &cobra.Command{
+		Use:   "snapshot",
+		Short: "capture a snapshot of a cluster",
+		Long:  "capture the namespaces, nodes, pods, services, workloads and network policies of a cluster, for analyzing them offline with --snapshot",
+		Args:  cobra.ExactArgs(0),
+		Run: func(cmd *cobra.Command, as []string) {
+			runSnapshot(args)
+		},
+	}
+
+	command.Flags().StringSliceVar(&args.Namespaces, "nss", []string{}, "namespaces to capture; if empty, every namespace is captured")
+	command.Flags().StringVarP(&args.Output, "output", "o", "", "file to write the snapshot to; if empty, it's printed")
+
+	return command
+}
+
+func runSnapshot(args *SnapshotArgs) {
+	k8s, err := kube.NewKubernetes()
+	utils.DoOrDie(err)
+
+	snap, err := snapshot.Capture(k8s, args.Namespaces)
+	utils.DoOrDie(err)
+
+	if args.Output != "" {
+		utils.DoOrDie(snap.WriteFile(args.Output))
+		return
+	}
+	bytes, err := yaml.Marshal(snap)
+	utils.DoOrDie(err)
+	fmt.Print(string(bytes))
+}
+
+// readInventory reads an inventory file if a path is given, otherwise the namespaces and pods of the cluster
+func readInventory(inventoryPath string) (*inventory.Inventory, error) {
+	if inventoryPath != "" {
+		return inventory.ReadInventoryFile(inventoryPath)
+	}
+	kubeClient, err := kube.NewKubernetes()
+	if err != nil {
+		return nil, err
+	}
+	namespaces, err := kubeClient.GetAllNamespaces()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, ns := range namespaces {
+		names = append(names, ns.Name)
+	}
+	pods, err := kubeClient.GetPodsInNamespaces(names)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := kubeClient.GetAllNodes()
+	if err != nil {
+		return nil, err
 	}
+	inv := inventory.FromKube(namespaces, pods)
+	inv.AddNodesFromKube(nodes)
+	return inv, nil
 }
 
 // TODO connect
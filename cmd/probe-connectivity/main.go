@@ -1,37 +1,27 @@
 package main
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/mattfenwick/kube-prototypes/pkg/kube"
 	"github.com/mattfenwick/kube-prototypes/pkg/kube/netpol/examples"
 	"github.com/mattfenwick/kube-prototypes/pkg/netpol/crd"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/inventory"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/matcher"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/simulator"
 	"github.com/mattfenwick/kube-prototypes/pkg/netpol/utils"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/validation"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v2"
 	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	"os"
 	"strconv"
-
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
 )
 
-func SetUpLogger(logLevelStr string) error {
-	logLevel, err := log.ParseLevel(logLevelStr)
-	if err != nil {
-		return errors.Wrapf(err, "unable to parse the specified log level: '%s'", logLevel)
-	}
-	log.SetLevel(logLevel)
-	//log.SetFormatter(&log.TextFormatter{
-	//	FullTimestamp: true,
-	//})
-	log.Infof("log level set to '%s'", log.GetLevel())
-	return nil
-}
-
 type Flags struct {
 	Verbosity string
 }
@@ -43,7 +33,7 @@ func setupCommand() *cobra.Command {
 		Short: "kube hacking",
 		Long:  "kube hacking",
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-			return SetUpLogger(flags.Verbosity)
+			return utils.SetUpLogger(flags.Verbosity)
 		},
 	}
 
@@ -52,6 +42,7 @@ func setupCommand() *cobra.Command {
 	command.AddCommand(SetupProbeCommand())
 	command.AddCommand(SetupCreateNetpolCommand())
 	command.AddCommand(SetupDemoCommand())
+	command.AddCommand(SetupConformanceCommand())
 
 	return command
 }
@@ -93,16 +84,58 @@ func SetupDemoCommand() *cobra.Command {
 	}
 }
 
+type CreateNetpolArgs struct {
+	InventoryPath string
+}
+
 func SetupCreateNetpolCommand() *cobra.Command {
+	args := &CreateNetpolArgs{}
+
 	command := &cobra.Command{
 		Use:   "create",
 		Short: "create some netpols",
 		Long:  "create some netpols",
 		Args:  cobra.ExactArgs(0),
-		Run: func(cmd *cobra.Command, args []string) {
+		Run: func(cmd *cobra.Command, as []string) {
+			// namespaces to fan cluster-scoped policies out to
+			var inv *inventory.Inventory
+			var err error
+			if args.InventoryPath != "" {
+				inv, err = inventory.ReadInventoryFile(args.InventoryPath)
+				utils.DoOrDie(err)
+			} else {
+				k8s, err := kube.NewKubernetes()
+				utils.DoOrDie(err)
+				kubeNamespaces, err := k8s.GetAllNamespaces()
+				utils.DoOrDie(err)
+				inv = inventory.FromKube(kubeNamespaces, nil)
+				var namespaces []string
+				for _, ns := range kubeNamespaces {
+					namespaces = append(namespaces, ns.Name)
+				}
+				services, err := k8s.GetServicesInNamespaces(namespaces)
+				utils.DoOrDie(err)
+				inv.AddServicesFromKube(services)
+				deployments, err := k8s.GetDeploymentsInNamespaces(namespaces)
+				utils.DoOrDie(err)
+				statefulSets, err := k8s.GetStatefulSetsInNamespaces(namespaces)
+				utils.DoOrDie(err)
+				daemonSets, err := k8s.GetDaemonSetsInNamespaces(namespaces)
+				utils.DoOrDie(err)
+				inv.AddWorkloadsFromKube(deployments, statefulSets, daemonSets)
+			}
+
 			// this -> kube
 			np := crd.DenyAll
-			kubeNetPols := crd.Reduce(np)
+			reduction, err := crd.Reduce(np, inv)
+			utils.DoOrDie(err)
+			for _, ns := range reduction.SortedNamespaces() {
+				fmt.Printf("namespace %s: %s\n", ns, strings.Join(reduction.Namespaces[ns], ", "))
+			}
+			for _, issue := range reduction.Issues {
+				fmt.Println(issue)
+			}
+			kubeNetPols := reduction.NetworkPolicies
 			bytes, err := json.MarshalIndent(kubeNetPols, "", "  ")
 			utils.DoOrDie(err)
 			fmt.Printf("%s\n\n", bytes)
@@ -127,6 +160,8 @@ func SetupCreateNetpolCommand() *cobra.Command {
 		},
 	}
 
+	command.Flags().StringVar(&args.InventoryPath, "inventory", "", "path to an inventory file of namespaces to fan cluster-scoped policies out to, and of services and workloads to resolve; if empty, read them from the cluster")
+
 	return command
 }
 
@@ -161,6 +196,75 @@ func SetupProbeCommand() *cobra.Command {
 	return command
 }
 
+type ConformanceArgs struct {
+	Namespaces       []string
+	PolicyPath       string
+	DefaultNamespace string
+	Ports            []string
+	CNIProfile       *matcher.CNIProfile
+	TimeoutSeconds   int
+}
+
+func SetupConformanceCommand() *cobra.Command {
+	args := &ConformanceArgs{}
+
+	command := &cobra.Command{
+		Use:   "conformance",
+		Short: "compare simulated with probed connectivity",
+		Long:  "simulate pod -> pod connectivity from network policies, probe it, and report where they disagree",
+		Args:  cobra.ExactArgs(0),
+		Run: func(cmd *cobra.Command, as []string) {
+			runConformance(args)
+		},
+	}
+
+	command.Flags().StringSliceVar(&args.Namespaces, "nss", []string{}, "namespaces to probe")
+	command.MarkFlagRequired("nss")
+
+	command.Flags().StringVar(&args.PolicyPath, "policy-path", "", "file or directory to read network policies from; if empty, read them from the cluster")
+	command.Flags().StringVarP(&args.DefaultNamespace, "namespace", "n", "default", "namespace for policies read from files which don't specify one")
+	command.Flags().StringSliceVar(&args.Ports, "port", []string{}, "ports to check, such as '80', '53/UDP' or 'http/TCP'; if empty, use all container ports")
+	args.CNIProfile = simulator.AddCNIProfileFlags(command)
+
+	command.Flags().IntVar(&args.TimeoutSeconds, "timeout", 2, "timeout in seconds")
+
+	return command
+}
+
+func runConformance(args *ConformanceArgs) {
+	k8s, err := kube.NewKubernetes()
+	utils.DoOrDie(err)
+
+	var netpols []*networkingv1.NetworkPolicy
+	if args.PolicyPath != "" {
+		netpols, err = validation.ReadNetworkPolicyFiles(args.PolicyPath, args.DefaultNamespace)
+		utils.DoOrDie(err)
+	} else {
+		netpols, err = k8s.GetNetworkPoliciesInNamespaces(args.Namespaces)
+		utils.DoOrDie(err)
+	}
+	policy := matcher.BuildNetworkPolicies(netpols)
+	policy.CNIProfile = args.CNIProfile
+
+	var ports []*matcher.PortProtocol
+	for _, p := range args.Ports {
+		port, err := simulator.ParsePortProtocol(p)
+		utils.DoOrDie(err)
+		ports = append(ports, port)
+	}
+
+	results, err := simulator.RunConformance(k8s, policy, args.Namespaces, ports, args.TimeoutSeconds)
+	utils.DoOrDie(err)
+
+	disagreements := 0
+	for _, result := range results {
+		result.PrintSummary()
+		fmt.Println()
+		disagreements += len(result.Disagreements)
+	}
+	fmt.Printf("%d disagreements found across %d ports\n", disagreements, len(results))
+}
+
 func main() {
 	command := setupCommand()
 	err := errors.Wrapf(command.Execute(), "run root command")
@@ -195,72 +299,75 @@ func serviceKey(svc v1.Service) string {
 	return fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)
 }
 
-func getServices(k8s *kube.Kubernetes, namespaces []string) ([]v1.Service, error) {
-	var services []v1.Service
-	for _, ns := range namespaces {
-		serviceList, err := k8s.ClientSet.CoreV1().Services(ns).List(context.TODO(), metav1.ListOptions{})
-		if err != nil {
-			return nil, errors.Wrapf(err, "unable to get pods in namespace %s", ns)
-		}
-		services = append(services, serviceList.Items...)
-	}
-	return services, nil
-}
-
+// probePodToPod probes each IP family separately, since policies may treat
+// IPv4 and IPv6 traffic between dual-stack pods differently
 func probePodToPod(namespaces []string, k8s *kube.Kubernetes, timeoutSeconds int) {
 	pods, err := k8s.GetPodsInNamespaces(namespaces)
 	utils.DoOrDie(err)
 
-	var jobs []*kube.ProbeJob
-	for _, fromPod := range pods {
-		if fromPod.Status.Phase != v1.PodRunning {
-			log.Infof("skipping from pod %s/%s, phase is %s", fromPod.Namespace, fromPod.Name, fromPod.Status.Phase)
-			continue
-		}
-		for _, fromCont := range fromPod.Spec.Containers {
-			for _, toPod := range pods {
-				if toPod.Status.Phase != v1.PodRunning {
-					log.Infof("skipping to pod %s/%s, phase is %s", fromPod.Namespace, fromPod.Name, toPod.Status.Phase)
-					continue
-				}
-				for _, toCont := range toPod.Spec.Containers {
-					if len(toCont.Ports) == 0 {
-						log.Warnf("no ports found for %s/%s/%s", toPod.Namespace, toPod.Name, toCont.Name)
+	var allIPs []string
+	for i := range pods {
+		allIPs = append(allIPs, kube.PodIPs(&pods[i])...)
+	}
+
+	for _, family := range kube.IPFamilies(allIPs) {
+		var jobs []*kube.ProbeJob
+		for _, fromPod := range pods {
+			if fromPod.Status.Phase != v1.PodRunning {
+				log.Infof("skipping from pod %s/%s, phase is %s", fromPod.Namespace, fromPod.Name, fromPod.Status.Phase)
+				continue
+			}
+			for _, fromCont := range fromPod.Spec.Containers {
+				for _, toPod := range pods {
+					if toPod.Status.Phase != v1.PodRunning {
+						log.Infof("skipping to pod %s/%s, phase is %s", fromPod.Namespace, fromPod.Name, toPod.Status.Phase)
+						continue
+					}
+					toAddress := kube.IPOfFamily(kube.PodIPs(&toPod), family)
+					if toAddress == "" {
+						log.Infof("skipping to pod %s/%s, no %s ip", toPod.Namespace, toPod.Name, family)
+						continue
 					}
-					for _, toPort := range toCont.Ports {
-						toPort := int(toPort.ContainerPort)
-						fromKey := fmt.Sprintf("%s/%s/%s", fromPod.Namespace, fromPod.Name[:3], fromCont.Name)
-						toKey := fmt.Sprintf("%s/%s:%d", toPod.Namespace, toPod.Name[:3], toPort)
-						//toKey := fmt.Sprintf("%s/%s/%s:%d", toPod.Namespace, toPod.Name[:3], toCont.Name, toPort)
-						log.Infof("creating job %s -> %s", fromKey, toKey)
-						jobs = append(jobs, &kube.ProbeJob{
-							FromNamespace:  fromPod.Namespace,
-							FromPod:        fromPod.Name,
-							FromContainer:  fromCont.Name,
-							ToAddress:      toPod.Status.PodIP,
-							ToPort:         toPort,
-							TimeoutSeconds: timeoutSeconds,
-							CommandType:    kube.ProbeCommandTypeCurl,
-							// TODO
-							FromKey: fromKey,
-							ToKey:   toKey,
-						})
+					for _, toCont := range toPod.Spec.Containers {
+						if len(toCont.Ports) == 0 {
+							log.Warnf("no ports found for %s/%s/%s", toPod.Namespace, toPod.Name, toCont.Name)
+						}
+						for _, toPort := range toCont.Ports {
+							toPort := int(toPort.ContainerPort)
+							fromKey := fmt.Sprintf("%s/%s/%s", fromPod.Namespace, fromPod.Name[:3], fromCont.Name)
+							toKey := fmt.Sprintf("%s/%s:%d", toPod.Namespace, toPod.Name[:3], toPort)
+							//toKey := fmt.Sprintf("%s/%s/%s:%d", toPod.Namespace, toPod.Name[:3], toCont.Name, toPort)
+							log.Infof("creating job %s -> %s", fromKey, toKey)
+							jobs = append(jobs, &kube.ProbeJob{
+								FromNamespace:  fromPod.Namespace,
+								FromPod:        fromPod.Name,
+								FromContainer:  fromCont.Name,
+								ToAddress:      toAddress,
+								ToPort:         toPort,
+								TimeoutSeconds: timeoutSeconds,
+								CommandType:    kube.ProbeCommandTypeCurl,
+								// TODO
+								FromKey: fromKey,
+								ToKey:   toKey,
+							})
+						}
 					}
 				}
 			}
 		}
-	}
 
-	table := k8s.ProbeConnectivity(jobs)
+		table := k8s.ProbeConnectivity(jobs)
 
-	table.Table().Render()
+		fmt.Printf("%s:\n", family)
+		table.Table().Render()
+	}
 }
 
 func probeContainerToService(namespaces []string, k8s *kube.Kubernetes, timeoutSeconds int) {
 	pods, err := k8s.GetPodsInNamespaces(namespaces)
 	utils.DoOrDie(err)
 
-	services, err := getServices(k8s, namespaces)
+	services, err := k8s.GetServicesInNamespaces(namespaces)
 	utils.DoOrDie(err)
 
 	var jobs []*kube.ProbeJob
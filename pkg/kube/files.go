@@ -0,0 +1,139 @@
+package kube
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+// ReadNetworkPoliciesFromPath reads NetworkPolicies from a single file, or from
+// every yaml/json file in a directory tree.  Files may contain multiple
+// documents, and NetworkPolicyLists as well as NetworkPolicies.
+func ReadNetworkPoliciesFromPath(path string) ([]*networkingv1.NetworkPolicy, error) {
+	var netpols []*networkingv1.NetworkPolicy
+	err := filepath.Walk(path, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.Wrapf(err, "unable to walk path %s", filePath)
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if filePath != path && !isPolicyFile(filePath) {
+			log.Debugf("skipping file %s", filePath)
+			return nil
+		}
+		fileBytes, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return errors.Wrapf(err, "unable to read file %s", filePath)
+		}
+		policies, err := ParseNetworkPolicies(fileBytes)
+		if err != nil {
+			return errors.WithMessagef(err, "unable to parse file %s", filePath)
+		}
+		netpols = append(netpols, policies...)
+		return nil
+	})
+	return netpols, err
+}
+
+// ReadNetworkPoliciesFromGitRef is like ReadNetworkPoliciesFromPath, but reads the
+// file or directory as of a git ref of the repository in the current directory
+func ReadNetworkPoliciesFromGitRef(ref string, path string) ([]*networkingv1.NetworkPolicy, error) {
+	// -z: paths are NUL-terminated and not quoted, so that they may contain spaces and other oddities
+	listing, err := exec.Command("git", "ls-tree", "-r", "-z", "--name-only", ref, "--", path).Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list files of %s at git ref %s", path, ref)
+	}
+	var filePaths []string
+	for _, filePath := range strings.Split(string(listing), "\x00") {
+		if filePath != "" {
+			filePaths = append(filePaths, filePath)
+		}
+	}
+	if len(filePaths) == 0 {
+		return nil, errors.Errorf("path %s not found at git ref %s", path, ref)
+	}
+	var netpols []*networkingv1.NetworkPolicy
+	for _, filePath := range filePaths {
+		if len(filePaths) > 1 && !isPolicyFile(filePath) {
+			log.Debugf("skipping file %s", filePath)
+			continue
+		}
+		// paths listed by ls-tree are relative to the current directory, which git show needs a ./ for
+		fileBytes, err := exec.Command("git", "show", fmt.Sprintf("%s:./%s", ref, filePath)).Output()
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to read file %s at git ref %s", filePath, ref)
+		}
+		policies, err := ParseNetworkPolicies(fileBytes)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "unable to parse file %s at git ref %s", filePath, ref)
+		}
+		netpols = append(netpols, policies...)
+	}
+	return netpols, nil
+}
+
+func isPolicyFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	default:
+		return false
+	}
+}
+
+// ParseNetworkPolicies parses a stream of yaml or json documents
+func ParseNetworkPolicies(contents []byte) ([]*networkingv1.NetworkPolicy, error) {
+	var netpols []*networkingv1.NetworkPolicy
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(contents)))
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return netpols, nil
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "unable to read yaml document")
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+
+		typeMeta := &metav1.TypeMeta{}
+		err = yaml.Unmarshal(doc, typeMeta)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to unmarshal type of document")
+		}
+		switch typeMeta.Kind {
+		case "NetworkPolicy":
+			netpol := &networkingv1.NetworkPolicy{}
+			err = yaml.UnmarshalStrict(doc, netpol)
+			if err != nil {
+				return nil, errors.Wrapf(err, "unable to unmarshal NetworkPolicy")
+			}
+			netpols = append(netpols, netpol)
+		case "NetworkPolicyList":
+			netpolList := &networkingv1.NetworkPolicyList{}
+			err = yaml.UnmarshalStrict(doc, netpolList)
+			if err != nil {
+				return nil, errors.Wrapf(err, "unable to unmarshal NetworkPolicyList")
+			}
+			for i := range netpolList.Items {
+				netpols = append(netpols, &netpolList.Items[i])
+			}
+		default:
+			log.Warnf("skipping document of kind '%s'", typeMeta.Kind)
+		}
+	}
+}
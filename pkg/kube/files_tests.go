@@ -0,0 +1,134 @@
+package kube
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const webDenyAll = `apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: web-deny-all
+  namespace: default
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+`
+
+const policyList = `{
+  "apiVersion": "networking.k8s.io/v1",
+  "kind": "NetworkPolicyList",
+  "items": [
+    {"metadata": {"name": "deny-all-a"}, "spec": {"podSelector": {}}},
+    {"metadata": {"name": "deny-all-b"}, "spec": {"podSelector": {}}}
+  ]
+}
+`
+
+func RunFilesTests() {
+	Describe("ParseNetworkPolicies", func() {
+		It("parses multiple documents, skipping empty documents and other kinds", func() {
+			contents := webDenyAll + "---\n---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n---\n" + policyList
+			netpols, err := ParseNetworkPolicies([]byte(contents))
+			Expect(err).To(Succeed())
+
+			var names []string
+			for _, netpol := range netpols {
+				names = append(names, netpol.Name)
+			}
+			Expect(names).To(Equal([]string{"web-deny-all", "deny-all-a", "deny-all-b"}))
+			Expect(netpols[0].Namespace).To(Equal("default"))
+			Expect(netpols[0].Spec.PodSelector.MatchLabels).To(Equal(map[string]string{"app": "web"}))
+		})
+
+		It("rejects unknown fields", func() {
+			_, err := ParseNetworkPolicies([]byte(webDenyAll + "  podSelectr: {}\n"))
+			Expect(err).ToNot(Succeed())
+		})
+	})
+
+	Describe("ReadNetworkPoliciesFromPath", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "policies")
+			Expect(err).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("reads policy files from a directory tree, skipping other files", func() {
+			Expect(os.Mkdir(filepath.Join(dir, "nested"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "web.yaml"), []byte(webDenyAll), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "nested", "list.JSON"), []byte(policyList), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("# not a policy"), 0644)).To(Succeed())
+
+			netpols, err := ReadNetworkPoliciesFromPath(dir)
+			Expect(err).To(Succeed())
+			Expect(netpols).To(HaveLen(3))
+		})
+
+		It("reads a single file, whatever its extension", func() {
+			path := filepath.Join(dir, "policy.txt")
+			Expect(ioutil.WriteFile(path, []byte(webDenyAll), 0644)).To(Succeed())
+
+			netpols, err := ReadNetworkPoliciesFromPath(path)
+			Expect(err).To(Succeed())
+			Expect(netpols).To(HaveLen(1))
+		})
+
+		It("fails on a missing path", func() {
+			_, err := ReadNetworkPoliciesFromPath(filepath.Join(dir, "missing"))
+			Expect(err).ToNot(Succeed())
+		})
+	})
+
+	Describe("ReadNetworkPoliciesFromGitRef", func() {
+		var dir, cwd string
+
+		git := func(args ...string) {
+			command := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+			command.Dir = dir
+			output, err := command.CombinedOutput()
+			Expect(err).To(Succeed(), string(output))
+		}
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "policies-repo")
+			Expect(err).To(Succeed())
+			cwd, err = os.Getwd()
+			Expect(err).To(Succeed())
+			git("init", "-q")
+		})
+
+		AfterEach(func() {
+			Expect(os.Chdir(cwd)).To(Succeed())
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("reads policies from paths with spaces and unusual characters", func() {
+			Expect(os.MkdirAll(filepath.Join(dir, "my policies", "caf\u00e9"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "my policies", "web deny all.yaml"), []byte(webDenyAll), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "my policies", "caf\u00e9", "list.json"), []byte(policyList), 0644)).To(Succeed())
+			git("add", "-A")
+			git("commit", "-q", "-m", "add policies")
+			// changes after the commit aren't read
+			Expect(os.Remove(filepath.Join(dir, "my policies", "web deny all.yaml"))).To(Succeed())
+
+			Expect(os.Chdir(dir)).To(Succeed())
+			netpols, err := ReadNetworkPoliciesFromGitRef("HEAD", "my policies")
+			Expect(err).To(Succeed())
+			Expect(netpols).To(HaveLen(3))
+		})
+	})
+}
@@ -0,0 +1,55 @@
+package kube
+
+import (
+	"net"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// IPFamilyOf returns the family of an IP, or "" if it isn't an IP.  IPv4-mapped
+// IPv6 addresses, such as ::ffff:10.0.0.1, are IPv6.
+func IPFamilyOf(ip string) v1.IPFamily {
+	if net.ParseIP(ip) == nil {
+		return ""
+	}
+	if strings.Contains(ip, ":") {
+		return v1.IPv6Protocol
+	}
+	return v1.IPv4Protocol
+}
+
+// PodIPs returns every IP of a pod: both of a dual-stack pod's, or the single IP
+// of a pod whose status doesn't list them all
+func PodIPs(pod *v1.Pod) []string {
+	var ips []string
+	for _, podIP := range pod.Status.PodIPs {
+		ips = append(ips, podIP.IP)
+	}
+	if len(ips) == 0 && pod.Status.PodIP != "" {
+		ips = append(ips, pod.Status.PodIP)
+	}
+	return ips
+}
+
+// IPOfFamily finds the first IP of a family, or "" if there isn't one
+func IPOfFamily(ips []string, family v1.IPFamily) string {
+	for _, ip := range ips {
+		if IPFamilyOf(ip) == family {
+			return ip
+		}
+	}
+	return ""
+}
+
+// IPFamilies finds the families of IPs, in order of first appearance
+func IPFamilies(ips []string) []v1.IPFamily {
+	var families []v1.IPFamily
+	for _, ip := range ips {
+		family := IPFamilyOf(ip)
+		if family != "" && IPOfFamily(ips, family) == ip {
+			families = append(families, family)
+		}
+	}
+	return families
+}
@@ -9,6 +9,7 @@ import (
 	v1 "k8s.io/api/core/v1"
 	v1net "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	"k8s.io/client-go/rest"
@@ -183,3 +184,120 @@ func (k *Kubernetes) GetPodsInNamespaces(namespaces []string) ([]v1.Pod, error)
 	}
 	return pods, nil
 }
+
+// LivenessProbePorts returns the ports of a pod's HTTP and TCP liveness probes
+func LivenessProbePorts(pod *v1.Pod) []intstr.IntOrString {
+	var ports []intstr.IntOrString
+	for _, cont := range pod.Spec.Containers {
+		probe := cont.LivenessProbe
+		switch {
+		case probe == nil:
+		case probe.HTTPGet != nil:
+			ports = append(ports, probe.HTTPGet.Port)
+		case probe.TCPSocket != nil:
+			ports = append(ports, probe.TCPSocket.Port)
+		}
+	}
+	return ports
+}
+
+func (k *Kubernetes) GetNamespaces(names []string) ([]v1.Namespace, error) {
+	var namespaces []v1.Namespace
+	for _, name := range names {
+		ns, err := k.ClientSet.CoreV1().Namespaces().Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to get namespace %s", name)
+		}
+		namespaces = append(namespaces, *ns)
+	}
+	return namespaces, nil
+}
+
+func (k *Kubernetes) GetNetworkPoliciesInNamespaces(namespaces []string) ([]*v1net.NetworkPolicy, error) {
+	var netpols []*v1net.NetworkPolicy
+	for _, ns := range namespaces {
+		netpolList, err := k.ClientSet.NetworkingV1().NetworkPolicies(ns).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to list network policies in namespace %s", ns)
+		}
+		for i := range netpolList.Items {
+			netpols = append(netpols, &netpolList.Items[i])
+		}
+	}
+	return netpols, nil
+}
+
+func (k *Kubernetes) GetAllNamespaces() ([]v1.Namespace, error) {
+	nsList, err := k.ClientSet.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list namespaces")
+	}
+	return nsList.Items, nil
+}
+
+func (k *Kubernetes) GetAllNodes() ([]v1.Node, error) {
+	nodeList, err := k.ClientSet.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list nodes")
+	}
+	return nodeList.Items, nil
+}
+
+// NodeIPs returns the internal IPs of a node
+func NodeIPs(node *v1.Node) []string {
+	var ips []string
+	for _, address := range node.Status.Addresses {
+		if address.Type == v1.NodeInternalIP {
+			ips = append(ips, address.Address)
+		}
+	}
+	return ips
+}
+
+func (k *Kubernetes) GetServicesInNamespaces(namespaces []string) ([]v1.Service, error) {
+	var services []v1.Service
+	for _, ns := range namespaces {
+		serviceList, err := k.ClientSet.CoreV1().Services(ns).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to list services in namespace %s", ns)
+		}
+		services = append(services, serviceList.Items...)
+	}
+	return services, nil
+}
+
+func (k *Kubernetes) GetDeploymentsInNamespaces(namespaces []string) ([]appsv1.Deployment, error) {
+	var deployments []appsv1.Deployment
+	for _, ns := range namespaces {
+		deploymentList, err := k.ClientSet.AppsV1().Deployments(ns).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to list deployments in namespace %s", ns)
+		}
+		deployments = append(deployments, deploymentList.Items...)
+	}
+	return deployments, nil
+}
+
+func (k *Kubernetes) GetStatefulSetsInNamespaces(namespaces []string) ([]appsv1.StatefulSet, error) {
+	var statefulSets []appsv1.StatefulSet
+	for _, ns := range namespaces {
+		statefulSetList, err := k.ClientSet.AppsV1().StatefulSets(ns).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to list statefulsets in namespace %s", ns)
+		}
+		statefulSets = append(statefulSets, statefulSetList.Items...)
+	}
+	return statefulSets, nil
+}
+
+func (k *Kubernetes) GetDaemonSetsInNamespaces(namespaces []string) ([]appsv1.DaemonSet, error) {
+	var daemonSets []appsv1.DaemonSet
+	for _, ns := range namespaces {
+		daemonSetList, err := k.ClientSet.AppsV1().DaemonSets(ns).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to list daemonsets in namespace %s", ns)
+		}
+		daemonSets = append(daemonSets, daemonSetList.Items...)
+	}
+	return daemonSets, nil
+}
@@ -5,6 +5,7 @@ import (
 	v1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"net"
+	"strings"
 )
 
 // IsNameMatch follows the kube pattern of "empty string means matches All"
@@ -95,16 +96,20 @@ func IsIPInCIDR(ip string, cidr string) bool {
 	return cidrNet.Contains(trafficIP)
 }
 
-// IsIPBlockMatchForIP is completely untested.  TODO!
+// IsIPBlockMatchForIP checks whether an IP is in the CIDR of an IPBlock, but not
+// in any of its excepts.  As upstream, an IPBlock only matches IPs of its own
+// family -- even 0.0.0.0/0 doesn't match IPv6 -- and an IP which can't be
+// parsed doesn't match at all.
 func IsIPBlockMatchForIP(ip string, ipBlock *v1.IPBlock) bool {
 	_, cidrNet, err := net.ParseCIDR(ipBlock.CIDR)
 	if err != nil {
 		panic(err)
 	}
-	trafficIP := net.ParseIP(ip)
-	if trafficIP == nil {
-		panic(errors.Errorf("unable to parse ip %s", ip))
+	family := IPFamilyOf(ip)
+	if family == "" || family != IPFamilyOf(ipBlock.CIDR[:strings.Index(ipBlock.CIDR, "/")]) {
+		return false
 	}
+	trafficIP := net.ParseIP(ip)
 	if !cidrNet.Contains(trafficIP) {
 		return false
 	}
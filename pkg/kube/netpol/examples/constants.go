@@ -15,4 +15,5 @@ var (
 	Port988     = intstr.FromInt(988)
 	Port9001Ref = intstr.FromInt(9001)
 	PortHello   = intstr.FromString("hello")
+	EndPort9010 = int32(9010)
 )
@@ -146,6 +146,45 @@ var AllowAllIngressAllowAllEgress = &networkingv1.NetworkPolicy{
 	},
 }
 
+// policy types left out: the API server defaults them to Ingress, plus Egress if there are egress rules
+
+var AllowNoIngress_NoPolicyTypes = &networkingv1.NetworkPolicy{
+	ObjectMeta: metav1.ObjectMeta{
+		Name:      "allow-no-ingress-no-policy-types",
+		Namespace: Namespace,
+	},
+	Spec: networkingv1.NetworkPolicySpec{
+		PodSelector: metav1.LabelSelector{},
+	},
+}
+
+var AllowAllIngress_NoPolicyTypes = &networkingv1.NetworkPolicy{
+	ObjectMeta: metav1.ObjectMeta{
+		Name:      "allow-all-ingress-no-policy-types",
+		Namespace: Namespace,
+	},
+	Spec: networkingv1.NetworkPolicySpec{
+		PodSelector: metav1.LabelSelector{},
+		Ingress: []networkingv1.NetworkPolicyIngressRule{
+			{},
+		},
+	},
+}
+
+// AllowAllEgress_NoPolicyTypes also isolates ingress, since Ingress is always defaulted
+var AllowAllEgress_NoPolicyTypes = &networkingv1.NetworkPolicy{
+	ObjectMeta: metav1.ObjectMeta{
+		Name:      "allow-all-egress-no-policy-types",
+		Namespace: Namespace,
+	},
+	Spec: networkingv1.NetworkPolicySpec{
+		PodSelector: metav1.LabelSelector{},
+		Egress: []networkingv1.NetworkPolicyEgressRule{
+			{},
+		},
+	},
+}
+
 // allow based on matching pod selector and namespace selector
 
 var AllowAllPodsInPolicyNamespacePeer = networkingv1.NetworkPolicyPeer{
@@ -218,3 +257,9 @@ var AllowNamedPortOnProtocol = networkingv1.NetworkPolicyPort{
 	Protocol: &UDP,
 	Port:     &PortHello,
 }
+
+var AllowPortRangeOnProtocol = networkingv1.NetworkPolicyPort{
+	Protocol: &TCP,
+	Port:     &Port9001Ref,
+	EndPort:  &EndPort9010,
+}
@@ -10,6 +10,8 @@ import (
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/tools/remotecommand"
+	"net"
+	"strconv"
 	"strings"
 )
 
@@ -59,7 +61,7 @@ func (pj *ProbeJob) GetToKey() string {
 	if pj.ToKey != "" {
 		return pj.ToKey
 	}
-	return fmt.Sprintf("%s:%d", pj.ToAddress, pj.ToPort)
+	return pj.ToHostPort()
 }
 
 func (pj *ProbeJob) KubeExecCommand() []string {
@@ -73,8 +75,13 @@ func (pj *ProbeJob) KubeExecCommand() []string {
 		pj.Command().Command()...)
 }
 
+// ToHostPort brackets IPv6 addresses, as in [fd00::1]:80
+func (pj *ProbeJob) ToHostPort() string {
+	return net.JoinHostPort(pj.ToAddress, strconv.Itoa(pj.ToPort))
+}
+
 func (pj *ProbeJob) ToURL() string {
-	return fmt.Sprintf("http://%s:%d", pj.ToAddress, pj.ToPort)
+	return fmt.Sprintf("http://%s", pj.ToHostPort())
 }
 
 func (k *Kubernetes) Probe(job *ProbeJob) (*ProbeResult, error) {
@@ -0,0 +1,14 @@
+package kube
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestModel(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunFilesTests()
+	RunSpecs(t, "kube suite")
+}
@@ -1,6 +1,10 @@
 package crd
 
 import (
+	"fmt"
+	"strings"
+
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol"
 	v1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -19,44 +23,69 @@ func BuildPolicies(netpols []*networkingv1.NetworkPolicy) *Policies {
 	return &Policies{Policies: policies}
 }
 
-func BuildTarget(netpol *networkingv1.NetworkPolicy) []*Policy {
+// BuildTarget converts a v1 NetworkPolicy into crd policies.  For each policy
+// type, the target gets a deny isolating it, and an allow for each peer and
+// port of each rule: allows win over denies of the same priority, which keeps
+// the v1 semantics of isolation plus additive rules.
+//
+// v1 traffic has to be allowed by both egress and ingress, so each policy is
+// scoped to its policy type: an ingress allow can't override an egress deny.
+func BuildTarget(policy *networkingv1.NetworkPolicy) []*Policy {
 	var policies []*Policy
-	for _, pType := range netpol.Spec.PolicyTypes {
+	for _, pType := range netpol.PolicyTypes(policy) {
+		var edges []*TrafficEdge
+		var directive Directive
+		var isolation *TrafficEdge
 		switch pType {
 		case networkingv1.PolicyTypeIngress:
-			edges, directive := BuildTrafficPeersFromIngress(netpol)
-			for _, edge := range edges {
-				policies = append(policies, &Policy{
-					ObjectMeta: metav1.ObjectMeta{},
-					Spec: PolicySpec{
-						Compatibility:  []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
-						TrafficMatcher: edge,
-						Directive:      directive,
-						Priority:       0,
-					},
-				})
-			}
+			edges, directive = BuildTrafficPeersFromIngress(policy)
+			isolation = &TrafficEdge{Type: TrafficMatchTypeAll, Dest: buildTargetPeer(policy.Spec.PodSelector, policy.Namespace)}
 		case networkingv1.PolicyTypeEgress:
-			edges, directive := BuildTrafficPeersFromEgress(netpol)
-			for _, edge := range edges {
-				policies = append(policies, &Policy{
-					ObjectMeta: metav1.ObjectMeta{},
-					Spec: PolicySpec{
-						Compatibility:  []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
-						TrafficMatcher: edge,
-						Directive:      directive,
-						Priority:       0,
-					},
-				})
-			}
+			edges, directive = BuildTrafficPeersFromEgress(policy)
+			isolation = &TrafficEdge{Type: TrafficMatchTypeAll, Source: buildTargetPeer(policy.Spec.PodSelector, policy.Namespace)}
+		default:
+			continue
+		}
+		name := fmt.Sprintf("%s-%s-%s", policy.Namespace, policy.Name, strings.ToLower(string(pType)))
+		policies = append(policies, buildPolicy(name, pType, isolation, DirectiveDeny))
+		if directive != DirectiveAllow {
+			continue
+		}
+		for i, edge := range edges {
+			policies = append(policies, buildPolicy(fmt.Sprintf("%s-%d", name, i), pType, edge, DirectiveAllow))
 		}
 	}
 	return policies
 }
 
+func buildPolicy(name string, pType networkingv1.PolicyType, edge *TrafficEdge, directive Directive) *Policy {
+	return &Policy{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: PolicySpec{
+			Compatibility:  []networkingv1.PolicyType{pType},
+			Scope:          pType,
+			TrafficMatcher: edge,
+			Directive:      directive,
+			Priority:       0,
+		},
+	}
+}
+
+func buildTargetPeer(podSelector metav1.LabelSelector, policyNamespace string) *PeerMatcher {
+	return &PeerMatcher{
+		RelativeLocation: &PeerLocationInternal,
+		Internal: &InternalPeerMatcher{
+			Namespace: &StringMatcher{Value: policyNamespace},
+			PodLabels: &podSelector,
+		},
+	}
+}
+
+// BuildTrafficPeersFromIngress builds the edges allowed by ingress rules.  No rules
+// means nothing is allowed: the target is isolated, which is returned as a deny.
 func BuildTrafficPeersFromIngress(netpol *networkingv1.NetworkPolicy) ([]*TrafficEdge, Directive) {
 	if len(netpol.Spec.Ingress) == 0 {
-		return []*TrafficEdge{EverythingMatcher}, DirectiveDeny
+		return []*TrafficEdge{{Type: TrafficMatchTypeAll, Dest: buildTargetPeer(netpol.Spec.PodSelector, netpol.Namespace)}}, DirectiveDeny
 	}
 
 	var edges []*TrafficEdge
@@ -66,12 +95,11 @@ func BuildTrafficPeersFromIngress(netpol *networkingv1.NetworkPolicy) ([]*Traffi
 	return edges, DirectiveAllow
 }
 
+// BuildTrafficPeersFromEgress builds the edges allowed by egress rules.  No rules
+// means nothing is allowed: the target is isolated, which is returned as a deny.
 func BuildTrafficPeersFromEgress(netpol *networkingv1.NetworkPolicy) ([]*TrafficEdge, Directive) {
 	if len(netpol.Spec.Egress) == 0 {
-		// This is a hard-to-grok case:
-		//   in network policy land, it should select NO Peers, with the effect of blocking all communication to target
-		//   in matcher land, we translate that by matching ALL with a deny
-		return []*TrafficEdge{EverythingMatcher}, DirectiveDeny
+		return []*TrafficEdge{{Type: TrafficMatchTypeAll, Source: buildTargetPeer(netpol.Spec.PodSelector, netpol.Namespace)}}, DirectiveDeny
 	}
 
 	var edges []*TrafficEdge
@@ -81,56 +109,43 @@ func BuildTrafficPeersFromEgress(netpol *networkingv1.NetworkPolicy) ([]*Traffic
 	return edges, DirectiveAllow
 }
 
+// BuildSourceDestAndPorts builds an edge for each peer and port of a rule.  Ports are
+// kept together with their protocols, so that a rule for 53/UDP and 80/TCP
+// doesn't also allow 80/UDP.
 func BuildSourceDestAndPorts(isIngress bool, targetPodSelector metav1.LabelSelector, policyNamespace string, npPorts []networkingv1.NetworkPolicyPort, peers []networkingv1.NetworkPolicyPeer) []*TrafficEdge {
-	var ports []*PortMatcher
-	var protocols []*ProtocolMatcher
-	sds := BuildSourceDestsFromSlice(policyNamespace, peers)
-	if len(npPorts) > 0 {
-		ports, protocols = BuildPortsFromSlice(npPorts)
-	}
-
-	// handle a couple of corner cases
-	if len(ports) == 0 {
-		ports = []*PortMatcher{nil}
-	}
-	if len(protocols) == 0 {
-		protocols = []*ProtocolMatcher{nil}
-	}
-
+	target := buildTargetPeer(targetPodSelector, policyNamespace)
 	var edges []*TrafficEdge
-	for _, peer := range sds {
-		for _, port := range ports {
-			for _, protocol := range protocols {
-				target := &PeerMatcher{
-					RelativeLocation: &PeerLocationInternal,
-					Internal: &InternalPeerMatcher{
-						Namespace: &StringMatcher{Value: policyNamespace},
-						PodLabels: &targetPodSelector,
-					},
-				}
-				var source, dest *PeerMatcher
-				if isIngress {
-					source = peer
-					dest = target
-				} else {
-					// egress
-					source = target
-					dest = peer
-				}
-				edges = append(edges, &TrafficEdge{
-					Type:     TrafficMatchTypeAll,
-					Source:   source,
-					Dest:     dest,
-					Port:     port,
-					Protocol: protocol,
-				})
-			}
+	for _, peer := range BuildSourceDestsFromSlice(policyNamespace, peers) {
+		var source, dest *PeerMatcher
+		if isIngress {
+			source = peer
+			dest = target
+		} else {
+			// egress
+			source = target
+			dest = peer
+		}
+		if len(npPorts) == 0 {
+			edges = append(edges, &TrafficEdge{Type: TrafficMatchTypeAll, Source: source, Dest: dest})
+			continue
+		}
+		for _, npPort := range npPorts {
+			protocol, port := BuildPort(npPort)
+			edges = append(edges, &TrafficEdge{
+				Type:     TrafficMatchTypeAll,
+				Source:   source,
+				Dest:     dest,
+				Port:     port,
+				Protocol: protocol,
+			})
 		}
 	}
-
 	return edges
 }
 
+// BuildPortsFromSlice builds the port and protocol matchers of some NetworkPolicyPorts.
+// Ports and protocols are returned separately, so combining them matches every
+// port with every protocol; BuildSourceDestAndPorts keeps them paired instead.
 func BuildPortsFromSlice(npPorts []networkingv1.NetworkPolicyPort) ([]*PortMatcher, []*ProtocolMatcher) {
 	if len(npPorts) == 0 {
 		panic("can't handle 0 NetworkPolicyPorts")
@@ -233,6 +248,13 @@ func BuildPort(p networkingv1.NetworkPolicyPort) (*ProtocolMatcher, *PortMatcher
 	switch p.Port.Type {
 	case intstr.Int:
 		portMatcher = NumberedPortMatcher(int(p.Port.IntVal))
+		if p.EndPort != nil {
+			// crd port ranges exclude their upper bound
+			portMatcher = &PortMatcher{Range: &struct {
+				Low  int
+				High int
+			}{Low: int(p.Port.IntVal), High: int(*p.EndPort) + 1}}
+		}
 	case intstr.String:
 		portMatcher = NamedPortMatcher(p.Port.StrVal)
 	default:
@@ -0,0 +1,349 @@
+package crd
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/inventory"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Compile works out which pods of an inventory may reach which others --
+// after priorities and denies are applied -- and builds a set of allow-only,
+// ingress-only v1 NetworkPolicies with the same result for that inventory.
+//
+// Unlike Reduce, this looks at the whole policy set at once, which is what
+// makes priorities and denies expressible.  Some details:
+//   - pods are grouped into classes by namespace and labels, since v1 selectors
+//     can't tell pods in a class apart.  If policies treat pods in a class
+//     differently -- for example by pod name or IP -- an error is returned.
+//   - the ports considered are the numbered container ports of each destination;
+//     crd port matchers compare names and numbers literally, so named port
+//     matchers won't match
+//   - only traffic between pods of the inventory is compiled, and it's enforced
+//     on ingress.  No egress policies are emitted, so traffic to and from external
+//     IPs is always allowed; an issue is returned for each policy which may match
+//     such traffic.
+//   - Service and Workload peers are resolved from the inventory first
+//   - namespaces are selected by the kubernetes.io/metadata.name label
+//
+// The policy set is kept small: pods which everything may reach don't get a
+// policy, every other class gets exactly one, sources allowed on the same ports
+// share a rule, and a namespace whose classes are all allowed is selected as a
+// whole rather than class by class.
+func Compile(policies []*Policy, inv *inventory.Inventory) ([]*networkingv1.NetworkPolicy, []*ReductionIssue, error) {
+	policies, err := ResolvePeers(policies, inv)
+	if err != nil {
+		return nil, nil, err
+	}
+	ps := &Policies{Policies: policies}
+	classes := buildPodClasses(inv)
+
+	var issues []*ReductionIssue
+	for _, policy := range policies {
+		if mayMatchExternal(policy.Spec.TrafficMatcher) {
+			issue := newIssue("spec.trafficMatcher", ReductionStatusUnsupported,
+				"traffic to and from external IPs isn't compiled: compiled policies only restrict ingress between pods")
+			issue.Policy = policy.Name
+			issues = append(issues, issue)
+		}
+	}
+
+	var netpols []*networkingv1.NetworkPolicy
+	for i, dest := range classes {
+		destPorts := dest.ports()
+		allowedPorts := map[*podClass][]compiledPort{}
+		isAllAllowed := true
+		for _, source := range classes {
+			for _, port := range destPorts {
+				isAllowed, err := classVerdict(ps, inv, source, dest, port)
+				if err != nil {
+					return nil, nil, err
+				}
+				if isAllowed {
+					allowedPorts[source] = append(allowedPorts[source], port)
+				} else {
+					isAllAllowed = false
+				}
+			}
+		}
+		if isAllAllowed {
+			continue
+		}
+		netpols = append(netpols, &networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("compiled-%d", i),
+				Namespace: dest.Namespace,
+			},
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: *classSelector(classes, dest),
+				Ingress:     compileIngressRules(classes, allowedPorts, destPorts),
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			},
+		})
+	}
+	return netpols, issues, nil
+}
+
+// mayMatchExternal is whether an edge may match traffic with an external peer on either side
+func mayMatchExternal(edge *TrafficEdge) bool {
+	if edge.Type == TrafficMatchTypeAny {
+		return true
+	}
+	return !requiresInternal(edge.Source) || !requiresInternal(edge.Dest)
+}
+
+func requiresInternal(pm *PeerMatcher) bool {
+	if pm == nil {
+		return false
+	}
+	return pm.Internal != nil || (pm.RelativeLocation != nil && *pm.RelativeLocation == PeerLocationInternal)
+}
+
+type compiledPort struct {
+	Protocol v1.Protocol
+	Port     int
+}
+
+// podClass is a group of pods which v1 selectors can't tell apart
+type podClass struct {
+	Namespace string
+	Labels    map[string]string
+	Pods      []*inventory.Pod
+}
+
+func (pc *podClass) String() string {
+	return fmt.Sprintf("%s/{%s}", pc.Namespace, labels.Set(pc.Labels).String())
+}
+
+// ports finds every distinct numbered port and protocol exposed by the class's pods, sorted
+func (pc *podClass) ports() []compiledPort {
+	found := map[compiledPort]bool{}
+	var ports []compiledPort
+	for _, pod := range pc.Pods {
+		for _, port := range podPorts(pod) {
+			if !found[port] {
+				found[port] = true
+				ports = append(ports, port)
+			}
+		}
+	}
+	sort.Slice(ports, func(i, j int) bool {
+		if ports[i].Protocol != ports[j].Protocol {
+			return ports[i].Protocol < ports[j].Protocol
+		}
+		return ports[i].Port < ports[j].Port
+	})
+	return ports
+}
+
+func podPorts(pod *inventory.Pod) []compiledPort {
+	var ports []compiledPort
+	for _, cp := range pod.ContainerPorts {
+		protocol := cp.Protocol
+		if protocol == "" {
+			protocol = v1.ProtocolTCP
+		}
+		ports = append(ports, compiledPort{Protocol: protocol, Port: int(cp.ContainerPort)})
+	}
+	return ports
+}
+
+func buildPodClasses(inv *inventory.Inventory) []*podClass {
+	var classes []*podClass
+	byKey := map[string]*podClass{}
+	for _, pod := range inv.Pods {
+		class := &podClass{Namespace: pod.Namespace, Labels: pod.Labels}
+		key := class.String()
+		if existing, ok := byKey[key]; ok {
+			class = existing
+		} else {
+			byKey[key] = class
+			classes = append(classes, class)
+		}
+		class.Pods = append(class.Pods, pod)
+	}
+	return classes
+}
+
+// classVerdict evaluates traffic between every pair of pods of two classes, to destination
+// pods exposing the port, and checks that policies treat them all the same
+func classVerdict(ps *Policies, inv *inventory.Inventory, source *podClass, dest *podClass, port compiledPort) (bool, error) {
+	var verdict *bool
+	for _, from := range source.Pods {
+		for _, to := range dest.Pods {
+			if !isPortExposed(to, port) {
+				continue
+			}
+			isAllowed, _ := ps.Allows(&Traffic{
+				Source:      InventoryPeer(inv, from),
+				Destination: InventoryPeer(inv, to),
+				Protocol:    port.Protocol,
+				Port:        intstr.FromInt(port.Port),
+			})
+			if verdict == nil {
+				verdict = &isAllowed
+			} else if *verdict != isAllowed {
+				return false, errors.Errorf("unable to compile: policies treat pods of %s -> %s on %d/%s differently", source, dest, port.Port, port.Protocol)
+			}
+		}
+	}
+	return verdict != nil && *verdict, nil
+}
+
+func isPortExposed(pod *inventory.Pod, port compiledPort) bool {
+	for _, p := range podPorts(pod) {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// InventoryPeer converts an inventory pod into a crd peer.  A hostNetwork pod
+// becomes a host peer, since it uses the network of its node.
+func InventoryPeer(inv *inventory.Inventory, pod *inventory.Pod) *Peer {
+	if pod.HostNetwork {
+		return &Peer{
+			Host: &HostPeer{
+				NodeLabels: inv.NodeLabels(pod.Node),
+				Node:       pod.Node,
+			},
+			IP: pod.IP,
+		}
+	}
+	return &Peer{
+		Internal: &InternalPeer{
+			PodLabels:       pod.Labels,
+			Pod:             pod.Name,
+			NamespaceLabels: inv.NamespaceLabels(pod.Namespace),
+			Namespace:       pod.Namespace,
+			NodeLabels:      inv.NodeLabels(pod.Node),
+			Node:            pod.Node,
+		},
+		IP: pod.IP,
+	}
+}
+
+// compileIngressRules groups sources by their allowed ports, producing one rule per group.
+// Ports are left out of a rule if every port of the destination is allowed.
+func compileIngressRules(classes []*podClass, allowedPorts map[*podClass][]compiledPort, destPorts []compiledPort) []networkingv1.NetworkPolicyIngressRule {
+	var keys []string
+	groups := map[string][]*podClass{}
+	groupPorts := map[string][]compiledPort{}
+	for _, source := range classes {
+		ports := allowedPorts[source]
+		if len(ports) == 0 {
+			continue
+		}
+		key := fmt.Sprintf("%v", ports)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+			groupPorts[key] = ports
+		}
+		groups[key] = append(groups[key], source)
+	}
+
+	var rules []networkingv1.NetworkPolicyIngressRule
+	for _, key := range keys {
+		rule := networkingv1.NetworkPolicyIngressRule{From: compilePeers(classes, groups[key])}
+		// allowed ports are a subset of the destination's ports, so same length means all
+		if len(groupPorts[key]) != len(destPorts) {
+			for _, port := range groupPorts[key] {
+				protocol := port.Protocol
+				portRef := intstr.FromInt(port.Port)
+				rule.Ports = append(rule.Ports, networkingv1.NetworkPolicyPort{
+					Protocol: &protocol,
+					Port:     &portRef,
+				})
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// compilePeers selects exactly the pods of the selected classes, using one peer
+// per namespace whose classes are all selected, and a single peer if every class is
+func compilePeers(classes []*podClass, selected []*podClass) []networkingv1.NetworkPolicyPeer {
+	isSelected := map[*podClass]bool{}
+	for _, class := range selected {
+		isSelected[class] = true
+	}
+	if len(selected) == len(classes) {
+		return []networkingv1.NetworkPolicyPeer{{NamespaceSelector: &metav1.LabelSelector{}}}
+	}
+
+	var namespaces []string
+	isNamespaceComplete := map[string]bool{}
+	for _, class := range classes {
+		if _, ok := isNamespaceComplete[class.Namespace]; !ok {
+			namespaces = append(namespaces, class.Namespace)
+			isNamespaceComplete[class.Namespace] = true
+		}
+		if !isSelected[class] {
+			isNamespaceComplete[class.Namespace] = false
+		}
+	}
+
+	var peers []networkingv1.NetworkPolicyPeer
+	for _, ns := range namespaces {
+		if isNamespaceComplete[ns] {
+			peers = append(peers, networkingv1.NetworkPolicyPeer{NamespaceSelector: namespaceNameSelector(ns)})
+			continue
+		}
+		for _, class := range selected {
+			if class.Namespace == ns {
+				peers = append(peers, networkingv1.NetworkPolicyPeer{
+					NamespaceSelector: namespaceNameSelector(ns),
+					PodSelector:       classSelector(classes, class),
+				})
+			}
+		}
+	}
+	return peers
+}
+
+func namespaceNameSelector(ns string) *metav1.LabelSelector {
+	return &metav1.LabelSelector{MatchLabels: map[string]string{v1.LabelMetadataName: ns}}
+}
+
+// classSelector selects exactly the pods of a class within its namespace: the
+// class's labels must match, and any other keys used in the namespace must be absent
+func classSelector(classes []*podClass, class *podClass) *metav1.LabelSelector {
+	selector := &metav1.LabelSelector{}
+	if len(class.Labels) > 0 {
+		selector.MatchLabels = map[string]string{}
+		for key, value := range class.Labels {
+			selector.MatchLabels[key] = value
+		}
+	}
+	absent := map[string]bool{}
+	for _, other := range classes {
+		if other.Namespace != class.Namespace {
+			continue
+		}
+		for key := range other.Labels {
+			if _, ok := class.Labels[key]; !ok {
+				absent[key] = true
+			}
+		}
+	}
+	var keys []string
+	for key := range absent {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		selector.MatchExpressions = append(selector.MatchExpressions, metav1.LabelSelectorRequirement{
+			Key:      key,
+			Operator: metav1.LabelSelectorOpDoesNotExist,
+		})
+	}
+	return selector
+}
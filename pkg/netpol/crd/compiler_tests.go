@@ -0,0 +1,206 @@
+package crd
+
+import (
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/inventory"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/matcher"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func compilerInventory() *inventory.Inventory {
+	tcp := func(port int32) []v1.ContainerPort {
+		return []v1.ContainerPort{{ContainerPort: port, Protocol: v1.ProtocolTCP}}
+	}
+	return &inventory.Inventory{
+		Namespaces: []*inventory.Namespace{{Name: "blackduck"}, {Name: "other"}},
+		Pods: []*inventory.Pod{
+			{Namespace: "blackduck", Name: "web-1", Labels: map[string]string{"app": "web"}, IP: "10.0.0.1", ContainerPorts: tcp(80)},
+			{Namespace: "blackduck", Name: "web-2", Labels: map[string]string{"app": "web"}, IP: "10.0.0.2", ContainerPorts: tcp(80)},
+			{Namespace: "blackduck", Name: "db", Labels: map[string]string{"app": "db"}, IP: "10.0.0.3", ContainerPorts: tcp(5432)},
+			{Namespace: "other", Name: "dns", Labels: map[string]string{"app": "dns"}, IP: "10.0.1.1", ContainerPorts: append(tcp(53), tcp(80)...)},
+			{Namespace: "other", Name: "web", Labels: map[string]string{"app": "web", "tier": "front"}, IP: "10.0.1.2", ContainerPorts: tcp(80)},
+		},
+	}
+}
+
+// expectCompiledEquivalent checks that the compiled v1 policies give the same
+// verdict as the crd policies, for every pair of pods and every exposed port
+func expectCompiledEquivalent(policies []*Policy, inv *inventory.Inventory, netpols []*networkingv1.NetworkPolicy) {
+	ps := &Policies{Policies: policies}
+	compiled := matcher.BuildNetworkPolicies(netpols)
+	for _, from := range inv.Pods {
+		for _, to := range inv.Pods {
+			for _, port := range podPorts(to) {
+				expected, _ := ps.Allows(&Traffic{
+					Source:      InventoryPeer(inv, from),
+					Destination: InventoryPeer(inv, to),
+					Protocol:    port.Protocol,
+					Port:        intstr.FromInt(port.Port),
+				})
+				actual := compiled.IsTrafficAllowed(&matcher.Traffic{
+					Source:       matcher.InventoryPeer(inv, from),
+					Destination:  matcher.InventoryPeer(inv, to),
+					PortProtocol: &matcher.PortProtocol{Protocol: port.Protocol, Port: intstr.FromInt(port.Port)},
+				})
+				Expect(actual.IsAllowed()).To(Equal(expected), "%s -> %s on %d/%s", from.Key(), to.Key(), port.Port, port.Protocol)
+			}
+		}
+	}
+}
+
+func RunCompilerTests() {
+	Describe("Compile", func() {
+		It("compiles blackduck policies, whose allows take priority over a deny", func() {
+			bd := &Blackduck{Namespace: "blackduck", KBAddress: "1.2.3.4"}
+			policies := []*Policy{bd.DenyAll(), bd.AllowDNSOnTCP(), bd.AllowEgressToKB(), bd.AllowBDNamespaceCommunication()}
+			inv := compilerInventory()
+
+			netpols, issues, err := Compile(policies, inv)
+			Expect(err).To(Succeed())
+			expectCompiledEquivalent(policies, inv, netpols)
+
+			// every policy but the namespace-internal one may match external traffic
+			var issuePolicies []string
+			for _, issue := range issues {
+				Expect(issue.Status).To(Equal(ReductionStatusUnsupported))
+				issuePolicies = append(issuePolicies, issue.Policy)
+			}
+			Expect(issuePolicies).To(Equal([]string{"deny-all-blackduck-traffic", "allow-dns-from-blackduck", "allow-egress-to-kb"}))
+
+			// blackduck web and db; other dns and web
+			Expect(netpols).To(HaveLen(4))
+			// blackduck pods are only reachable from blackduck
+			Expect(netpols[0].Spec.Ingress).To(Equal([]networkingv1.NetworkPolicyIngressRule{
+				{From: []networkingv1.NetworkPolicyPeer{{NamespaceSelector: namespaceNameSelector("blackduck")}}},
+			}))
+			// other/dns is reachable from blackduck only on 53
+			tcp := v1.ProtocolTCP
+			port53 := intstr.FromInt(53)
+			Expect(netpols[2].Spec.Ingress).To(Equal([]networkingv1.NetworkPolicyIngressRule{
+				{
+					From:  []networkingv1.NetworkPolicyPeer{{NamespaceSelector: namespaceNameSelector("blackduck")}},
+					Ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &port53}},
+				},
+				{From: []networkingv1.NetworkPolicyPeer{{NamespaceSelector: namespaceNameSelector("other")}}},
+			}))
+		})
+
+		It("a higher priority deny beats a lower priority allow", func() {
+			policies := []*Policy{
+				namespacePolicy("allow-other", "other", 0, DirectiveAllow),
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "deny-other-to-db"},
+					Spec: PolicySpec{
+						Priority: 10,
+						TrafficMatcher: &TrafficEdge{
+							Type: TrafficMatchTypeAll,
+							Source: &PeerMatcher{
+								Internal: &InternalPeerMatcher{Namespace: &StringMatcher{Value: "other"}},
+							},
+							Dest: &PeerMatcher{
+								Internal: &InternalPeerMatcher{PodLabels: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}},
+							},
+						},
+						Directive: DirectiveDeny,
+					},
+				},
+			}
+			inv := compilerInventory()
+
+			netpols, _, err := Compile(policies, inv)
+			Expect(err).To(Succeed())
+			expectCompiledEquivalent(policies, inv, netpols)
+			Expect(netpols).To(HaveLen(1))
+			Expect(netpols[0].Spec.PodSelector).To(Equal(metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}))
+		})
+
+		It("selects pod classes exactly", func() {
+			policies := []*Policy{{
+				ObjectMeta: metav1.ObjectMeta{Name: "deny-to-other-web"},
+				Spec: PolicySpec{
+					TrafficMatcher: &TrafficEdge{
+						Type: TrafficMatchTypeAll,
+						Dest: &PeerMatcher{
+							Internal: &InternalPeerMatcher{PodLabels: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "front"}}},
+						},
+						Source: &PeerMatcher{
+							Internal: &InternalPeerMatcher{PodLabels: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}},
+						},
+					},
+					Directive: DirectiveDeny,
+				},
+			}}
+			inv := compilerInventory()
+
+			netpols, _, err := Compile(policies, inv)
+			Expect(err).To(Succeed())
+			expectCompiledEquivalent(policies, inv, netpols)
+		})
+
+		It("emits no policies if everything may reach everything", func() {
+			policies := []*Policy{namespacePolicy("allow-other", "other", 0, DirectiveAllow)}
+			netpols, _, err := Compile(policies, compilerInventory())
+			Expect(err).To(Succeed())
+			Expect(netpols).To(BeEmpty())
+		})
+
+		It("shares rules between sources allowed on the same ports", func() {
+			policies := []*Policy{{
+				ObjectMeta: metav1.ObjectMeta{Name: "deny-blackduck-web-to-db"},
+				Spec: PolicySpec{
+					TrafficMatcher: &TrafficEdge{
+						Type: TrafficMatchTypeAll,
+						Source: &PeerMatcher{
+							Internal: &InternalPeerMatcher{
+								Namespace: &StringMatcher{Value: "blackduck"},
+								PodLabels: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+							},
+						},
+						Dest: &PeerMatcher{
+							Internal: &InternalPeerMatcher{PodLabels: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}},
+						},
+					},
+					Directive: DirectiveDeny,
+				},
+			}}
+			inv := compilerInventory()
+
+			netpols, issues, err := Compile(policies, inv)
+			Expect(err).To(Succeed())
+			Expect(issues).To(BeEmpty())
+			expectCompiledEquivalent(policies, inv, netpols)
+
+			// a single rule: blackduck/db by class, and all of other as a whole
+			Expect(netpols).To(HaveLen(1))
+			Expect(netpols[0].Spec.Ingress).To(HaveLen(1))
+			Expect(netpols[0].Spec.Ingress[0].From).To(HaveLen(2))
+			Expect(netpols[0].Spec.Ingress[0].From[1]).To(Equal(networkingv1.NetworkPolicyPeer{NamespaceSelector: namespaceNameSelector("other")}))
+
+			// generated selectors don't share the inventory's labels
+			netpols[0].Spec.PodSelector.MatchLabels["app"] = "changed"
+			Expect(inv.Pods[2].Labels).To(Equal(map[string]string{"app": "db"}))
+		})
+
+		It("refuses policies which treat pods with the same labels differently", func() {
+			policies := []*Policy{{
+				ObjectMeta: metav1.ObjectMeta{Name: "deny-to-web-1"},
+				Spec: PolicySpec{
+					TrafficMatcher: &TrafficEdge{
+						Type: TrafficMatchTypeAll,
+						Dest: &PeerMatcher{
+							Internal: &InternalPeerMatcher{Pod: &StringMatcher{Value: "web-1"}},
+						},
+					},
+					Directive: DirectiveDeny,
+				},
+			}}
+
+			_, _, err := Compile(policies, compilerInventory())
+			Expect(err).To(HaveOccurred())
+		})
+	})
+}
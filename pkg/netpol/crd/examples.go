@@ -429,3 +429,29 @@ func AllowIngressToNamespace(nsLabels map[string]string) *Policy {
 		},
 	}
 }
+
+func AllowIngressFromServiceToService(fromNs string, fromName string, toNs string, toName string) *Policy {
+	return &Policy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: fmt.Sprintf("allow-ingress-from-svc-%s-%s-to-svc-%s-%s", fromNs, fromName, toNs, toName),
+		},
+		Spec: PolicySpec{
+			Compatibility: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Priority:      10,
+			TrafficMatcher: &TrafficEdge{
+				Type: TrafficMatchTypeAll,
+				Source: &PeerMatcher{
+					Internal: &InternalPeerMatcher{
+						Service: &ServiceMatcher{Namespace: fromNs, Name: fromName},
+					},
+				},
+				Dest: &PeerMatcher{
+					Internal: &InternalPeerMatcher{
+						Service: &ServiceMatcher{Namespace: toNs, Name: toName},
+					},
+				},
+			},
+			Directive: DirectiveAllow,
+		},
+	}
+}
@@ -1,24 +1,65 @@
 package crd
 
+import (
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
 type Policies struct {
 	Policies []*Policy
 }
 
-// Allows searches through policies for matches, from which it takes
-// directives.  Some corner cases:
-// - no matches => allowed (traffic must be explicitly denied)
-// - allows take precedence over denies (TODO maybe rules need precedence?)
-func (ps *Policies) Allows(t *Traffic) bool {
-	isDenied := false
+// Allows searches through policies for matches, and takes the directive of
+// the first match in precedence order.  Precedence:
+//   - higher priority first
+//   - on equal priority, allows before denies (so that policies built from
+//     v1 NetworkPolicies, which are all allows and denies of the same priority,
+//     keep their additive semantics)
+//   - on equal priority and directive, earlier policies first
+//
+// This is done for each direction, with the policies scoped to it or not
+// scoped at all, and traffic must be allowed in both.  The deciding policy is
+// that of a denying direction, if there is one.
+//
+// Some corner cases:
+//   - no matches => allowed (traffic must be explicitly denied), and the
+//     deciding policy is nil
+func (ps *Policies) Allows(t *Traffic) (bool, *Policy) {
+	isIngressAllowed, ingressDecider := ps.decide(t, networkingv1.PolicyTypeIngress)
+	isEgressAllowed, egressDecider := ps.decide(t, networkingv1.PolicyTypeEgress)
+	switch {
+	case !isIngressAllowed:
+		return false, ingressDecider
+	case !isEgressAllowed:
+		return false, egressDecider
+	case ingressDecider != nil:
+		return true, ingressDecider
+	default:
+		return true, egressDecider
+	}
+}
+
+// decide finds the policy deciding traffic in one direction
+func (ps *Policies) decide(t *Traffic, scope networkingv1.PolicyType) (bool, *Policy) {
+	var decider *Policy
 	for _, policy := range ps.Policies {
-		isMatch, directive := policy.Spec.Allows(t)
-		if isMatch {
-			if directive == DirectiveAllow {
-				return true
-			} else if directive == DirectiveDeny {
-				isDenied = true
-			}
+		if policy.Spec.Scope != "" && policy.Spec.Scope != scope {
+			continue
+		}
+		isMatch, _ := policy.Spec.Allows(t)
+		if isMatch && (decider == nil || policy.Spec.hasPrecedenceOver(&decider.Spec)) {
+			decider = policy
 		}
 	}
-	return !isDenied
+	if decider == nil {
+		return true, nil
+	}
+	return decider.Spec.Directive == DirectiveAllow, decider
+}
+
+// hasPrecedenceOver is strict, so that list order breaks ties
+func (ps *PolicySpec) hasPrecedenceOver(other *PolicySpec) bool {
+	if ps.Priority != other.Priority {
+		return ps.Priority > other.Priority
+	}
+	return ps.Directive == DirectiveAllow && other.Directive != DirectiveAllow
 }
@@ -18,7 +18,11 @@ type Policy struct {
 }
 
 type PolicySpec struct {
-	Compatibility  []networkingv1.PolicyType
+	Compatibility []networkingv1.PolicyType
+	// Scope limits the policy to deciding a single direction of traffic, which
+	// must then be allowed in both directions -- as for v1 policies.  Policies
+	// without a scope decide both directions at once.
+	Scope          networkingv1.PolicyType
 	Priority       int
 	TrafficMatcher *TrafficEdge
 	Directive      Directive
@@ -0,0 +1,104 @@
+package crd
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func namespacePolicy(name string, ns string, priority int, directive Directive) *Policy {
+	return &Policy{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: PolicySpec{
+			Priority: priority,
+			TrafficMatcher: &TrafficEdge{
+				Type: TrafficMatchTypeAll,
+				Source: &PeerMatcher{
+					Internal: &InternalPeerMatcher{Namespace: &StringMatcher{Value: ns}},
+				},
+			},
+			Directive: directive,
+		},
+	}
+}
+
+func trafficFromNamespace(ns string) *Traffic {
+	return &Traffic{
+		Source:      &Peer{Internal: &InternalPeer{Namespace: ns}},
+		Destination: &Peer{Internal: &InternalPeer{Namespace: "y"}},
+		Protocol:    v1.ProtocolTCP,
+		Port:        intstr.FromInt(80),
+	}
+}
+
+func RunPrecedenceTests() {
+	Describe("Policy precedence", func() {
+		It("allows unmatched traffic, with no deciding policy", func() {
+			policies := &Policies{Policies: []*Policy{namespacePolicy("deny-x", "x", 0, DirectiveDeny)}}
+
+			isAllowed, decider := policies.Allows(trafficFromNamespace("z"))
+			Expect(isAllowed).To(BeTrue())
+			Expect(decider).To(BeNil())
+		})
+
+		It("higher priority deny beats lower priority allow", func() {
+			allow := namespacePolicy("allow-x", "x", 0, DirectiveAllow)
+			deny := namespacePolicy("deny-x", "x", 10, DirectiveDeny)
+			policies := &Policies{Policies: []*Policy{allow, deny}}
+
+			isAllowed, decider := policies.Allows(trafficFromNamespace("x"))
+			Expect(isAllowed).To(BeFalse())
+			Expect(decider).To(Equal(deny))
+		})
+
+		It("higher priority allow beats lower priority deny", func() {
+			deny := namespacePolicy("deny-x", "x", 0, DirectiveDeny)
+			allow := namespacePolicy("allow-x", "x", 10, DirectiveAllow)
+			policies := &Policies{Policies: []*Policy{deny, allow}}
+
+			isAllowed, decider := policies.Allows(trafficFromNamespace("x"))
+			Expect(isAllowed).To(BeTrue())
+			Expect(decider).To(Equal(allow))
+		})
+
+		It("allow beats deny on equal priority", func() {
+			deny := namespacePolicy("deny-x", "x", 5, DirectiveDeny)
+			allow := namespacePolicy("allow-x", "x", 5, DirectiveAllow)
+			policies := &Policies{Policies: []*Policy{deny, allow}}
+
+			isAllowed, decider := policies.Allows(trafficFromNamespace("x"))
+			Expect(isAllowed).To(BeTrue())
+			Expect(decider).To(Equal(allow))
+		})
+
+		It("requires scoped policies to allow in both directions", func() {
+			allow := namespacePolicy("allow-x-ingress", "x", 0, DirectiveAllow)
+			allow.Spec.Scope = networkingv1.PolicyTypeIngress
+			deny := namespacePolicy("deny-x-egress", "x", 0, DirectiveDeny)
+			deny.Spec.Scope = networkingv1.PolicyTypeEgress
+			policies := &Policies{Policies: []*Policy{allow, deny}}
+
+			isAllowed, decider := policies.Allows(trafficFromNamespace("x"))
+			Expect(isAllowed).To(BeFalse())
+			Expect(decider).To(Equal(deny))
+
+			deny.Spec.Scope = ""
+			isAllowed, decider = policies.Allows(trafficFromNamespace("x"))
+			Expect(isAllowed).To(BeFalse())
+			Expect(decider).To(Equal(deny))
+		})
+
+		It("list order breaks remaining ties", func() {
+			first := namespacePolicy("deny-x-1", "x", 5, DirectiveDeny)
+			second := namespacePolicy("deny-x-2", "x", 5, DirectiveDeny)
+			policies := &Policies{Policies: []*Policy{first, second}}
+
+			isAllowed, decider := policies.Allows(trafficFromNamespace("x"))
+			Expect(isAllowed).To(BeFalse())
+			Expect(decider).To(BeIdenticalTo(first))
+		})
+	})
+}
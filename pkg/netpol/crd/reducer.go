@@ -2,6 +2,14 @@ package crd
 
 import (
 	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/mattfenwick/kube-prototypes/pkg/kube"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/inventory"
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -11,79 +19,354 @@ import (
 // TODO wow, this is really hard due to the target-biased nature of network
 //   policies
 
-func ReduceAll(np []*Policy) []*networkingv1.NetworkPolicy {
-	var netpols []*networkingv1.NetworkPolicy
+// ReductionStatus describes how faithfully part of a crd policy was reduced to v1
+type ReductionStatus string
+
+const (
+	// ReductionStatusLossless means the v1 policies match exactly the same traffic
+	ReductionStatusLossless ReductionStatus = "Lossless"
+	// ReductionStatusLossy means the v1 policies match approximately the same traffic
+	ReductionStatusLossy ReductionStatus = "Lossy"
+	// ReductionStatusUnsupported means v1 policies can't express it, so it was left out
+	ReductionStatusUnsupported ReductionStatus = "Unsupported"
+)
+
+// ReductionIssue explains how part of a crd policy was reduced to v1, when
+// that's anything other than a direct copy
+type ReductionIssue struct {
+	Policy string
+	Field  string
+	Reason string
+	Status ReductionStatus
+}
+
+func (ri *ReductionIssue) String() string {
+	return fmt.Sprintf("%s: policy %s, %s: %s", ri.Status, ri.Policy, ri.Field, ri.Reason)
+}
+
+func newIssue(field string, status ReductionStatus, format string, args ...interface{}) *ReductionIssue {
+	return &ReductionIssue{Field: field, Reason: fmt.Sprintf(format, args...), Status: status}
+}
+
+func hasUnsupported(issues []*ReductionIssue) bool {
+	for _, issue := range issues {
+		if issue.Status == ReductionStatusUnsupported {
+			return true
+		}
+	}
+	return false
+}
+
+// Reduction is the result of reducing crd policies to v1 NetworkPolicies
+type Reduction struct {
+	NetworkPolicies []*networkingv1.NetworkPolicy
+	// Namespaces maps each namespace to the names of the NetworkPolicies generated in it
+	Namespaces map[string][]string
+	Issues     []*ReductionIssue
+}
+
+func newReduction() *Reduction {
+	return &Reduction{Namespaces: map[string][]string{}}
+}
+
+func (r *Reduction) add(netpol *networkingv1.NetworkPolicy) {
+	r.NetworkPolicies = append(r.NetworkPolicies, netpol)
+	r.Namespaces[netpol.Namespace] = append(r.Namespaces[netpol.Namespace], netpol.Name)
+}
+
+func (r *Reduction) merge(other *Reduction) {
+	for _, netpol := range other.NetworkPolicies {
+		r.add(netpol)
+	}
+	r.Issues = append(r.Issues, other.Issues...)
+}
+
+// IsSupported is false if any part of the policies couldn't be reduced
+func (r *Reduction) IsSupported() bool {
+	return !hasUnsupported(r.Issues)
+}
+
+// IsLossless is true if the v1 policies match exactly the same traffic
+func (r *Reduction) IsLossless() bool {
+	for _, issue := range r.Issues {
+		if issue.Status != ReductionStatusLossless {
+			return false
+		}
+	}
+	return true
+}
+
+// SortedNamespaces returns the namespaces which received generated policies
+func (r *Reduction) SortedNamespaces() []string {
+	var namespaces []string
+	for ns := range r.Namespaces {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+func ReduceAll(np []*Policy, inv *inventory.Inventory) (*Reduction, error) {
+	reduction := newReduction()
 	for _, n := range np {
-		netpols = append(netpols, Reduce(n)...)
-	}
-	return append(netpols)
-}
-
-func Reduce(np *Policy) []*networkingv1.NetworkPolicy {
-	var pols []*networkingv1.NetworkPolicy
-	for i, policyType := range np.Spec.Compatibility {
-		switch policyType {
-		case networkingv1.PolicyTypeIngress:
-			ingress, _ := ReduceRules(true, np.Spec.TrafficMatcher)
-			namespace := "default"
-			edge := np.Spec.TrafficMatcher
-			podSelector := metav1.LabelSelector{}
-			if edge.Dest != nil {
-				if edge.Dest.Internal.Namespace != nil {
-					namespace = edge.Dest.Internal.Namespace.Value
-				}
-				if edge.Dest.Internal.PodLabels != nil {
-					podSelector = *edge.Dest.Internal.PodLabels
-				}
+		r, err := Reduce(n, inv)
+		if err != nil {
+			return nil, err
+		}
+		reduction.merge(r)
+	}
+	return reduction, nil
+}
+
+// Reduce builds v1 NetworkPolicies out of a crd policy.  Since v1 policies are
+// namespaced, a policy whose target doesn't pick out a single namespace is
+// fanned out to every namespace of the inventory that it matches:
+//   - a Namespace matcher: just that namespace
+//   - a NamespaceLabels matcher: every namespace with matching labels
+//   - a Service or Workload: its namespace
+//   - otherwise: every namespace
+//
+// Service and Workload peers are resolved from the inventory first.  An Any
+// edge is split into one All edge per disjunct, each reduced separately: v1
+// policies are additive, so their union matches the same traffic.
+//
+// Anything which v1 can't express is reported as an Unsupported issue instead
+// of a failure.  The v1 policies may then allow less than the crd policy, but
+// never more: an allow with unsupported rules still isolates its target, and
+// an unsupported target gets no policy.
+func Reduce(np *Policy, inv *inventory.Inventory) (*Reduction, error) {
+	resolved, err := ResolvePeers([]*Policy{np}, inv)
+	if err != nil {
+		return nil, err
+	}
+	np = resolved[0]
+	reduction := newReduction()
+	if np.Spec.Priority != 0 {
+		reduction.Issues = append(reduction.Issues, newIssue("spec.priority", ReductionStatusLossy,
+			"v1 policies are additive, so priority %d is ignored", np.Spec.Priority))
+	}
+	edges, err := splitEdge(np.Spec.TrafficMatcher)
+	if err != nil {
+		reduction.Issues = append(reduction.Issues, newIssue("spec.trafficMatcher.type", ReductionStatusUnsupported, "%s", err.Error()))
+	}
+	for j, edge := range edges {
+		var edgeIssues []*ReductionIssue
+		isDenyEnforced := false
+		for i, policyType := range np.Spec.Compatibility {
+			name := fmt.Sprintf("%s-%s-%d", np.Name, strings.ToLower(string(policyType)), i)
+			if len(edges) > 1 {
+				name = fmt.Sprintf("%s-%d", name, j)
 			}
-			pols = append(pols, &networkingv1.NetworkPolicy{
-				ObjectMeta: metav1.ObjectMeta{
-					Name:      fmt.Sprintf("%s-ingress-%d", np.Name, i),
-					Namespace: namespace,
-				},
-				Spec: networkingv1.NetworkPolicySpec{
-					PodSelector: podSelector,
-					Ingress:     ingress,
-					PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
-				},
-			})
-		case networkingv1.PolicyTypeEgress:
-			_, egress := ReduceRules(false, np.Spec.TrafficMatcher)
-			namespace := "default"
-			edge := np.Spec.TrafficMatcher
-			podSelector := metav1.LabelSelector{}
-			if edge.Source != nil {
-				if edge.Source.Internal.Namespace != nil {
-					namespace = edge.Source.Internal.Namespace.Value
-				}
-				if edge.Source.Internal.PodLabels != nil {
-					podSelector = *edge.Source.Internal.PodLabels
+			netpols, issues := reduceDirection(np, name, policyType == networkingv1.PolicyTypeIngress, edge, inv)
+			for _, netpol := range netpols {
+				reduction.add(netpol)
+			}
+			if len(netpols) > 0 && !hasUnsupported(issues) {
+				isDenyEnforced = true
+			}
+			edgeIssues = append(edgeIssues, issues...)
+		}
+		// traffic has to be allowed by both egress and ingress, so it's enough to
+		//   enforce a deny in one direction: the other direction's issues don't matter
+		if np.Spec.Directive == DirectiveDeny && isDenyEnforced {
+			var enforcedIssues []*ReductionIssue
+			for _, issue := range edgeIssues {
+				if issue.Status != ReductionStatusUnsupported {
+					enforcedIssues = append(enforcedIssues, issue)
 				}
 			}
-			pols = append(pols, &networkingv1.NetworkPolicy{
-				ObjectMeta: metav1.ObjectMeta{
-					Name:      fmt.Sprintf("%s-egress-%d", np.Name, i),
-					Namespace: namespace,
-				},
-				Spec: networkingv1.NetworkPolicySpec{
-					PodSelector: podSelector,
-					Egress:      egress,
-					PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
-				},
+			edgeIssues = enforcedIssues
+		}
+		reduction.Issues = append(reduction.Issues, edgeIssues...)
+	}
+	for _, issue := range reduction.Issues {
+		issue.Policy = np.Name
+	}
+	return reduction, nil
+}
+
+// splitEdge splits an Any edge into All edges, one for each of its matchers
+func splitEdge(edge *TrafficEdge) ([]*TrafficEdge, error) {
+	switch edge.Type {
+	case TrafficMatchTypeAll:
+		return []*TrafficEdge{edge}, nil
+	case TrafficMatchTypeAny:
+		var edges []*TrafficEdge
+		if edge.Source != nil {
+			edges = append(edges, &TrafficEdge{Type: TrafficMatchTypeAll, Source: edge.Source})
+		}
+		if edge.Dest != nil {
+			edges = append(edges, &TrafficEdge{Type: TrafficMatchTypeAll, Dest: edge.Dest})
+		}
+		if edge.Port != nil {
+			edges = append(edges, &TrafficEdge{Type: TrafficMatchTypeAll, Port: edge.Port})
+		}
+		if edge.Protocol != nil {
+			edges = append(edges, &TrafficEdge{Type: TrafficMatchTypeAll, Protocol: edge.Protocol})
+		}
+		return edges, nil
+	default:
+		return nil, errors.Errorf("invalid match type %s", edge.Type)
+	}
+}
+
+// reduceDirection builds the v1 policies enforcing an All edge in a single direction
+func reduceDirection(np *Policy, name string, isIngress bool, edge *TrafficEdge, inv *inventory.Inventory) ([]*networkingv1.NetworkPolicy, []*ReductionIssue) {
+	target, targetField := edge.Dest, "spec.trafficMatcher.dest"
+	policyType := networkingv1.PolicyTypeIngress
+	if !isIngress {
+		target, targetField = edge.Source, "spec.trafficMatcher.source"
+		policyType = networkingv1.PolicyTypeEgress
+	}
+	namespaces, podSelector, targetIssues := reduceTarget(target, targetField, inv)
+	ingress, egress, ruleIssues := reduceDirective(isIngress, np.Spec.Directive, edge, inv)
+	issues := append(targetIssues, ruleIssues...)
+	if hasUnsupported(targetIssues) || (hasUnsupported(ruleIssues) && np.Spec.Directive != DirectiveAllow) {
+		return nil, issues
+	}
+	var netpols []*networkingv1.NetworkPolicy
+	for _, namespace := range namespaces {
+		netpols = append(netpols, &networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+			},
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: podSelector,
+				Ingress:     ingress,
+				Egress:      egress,
+				PolicyTypes: []networkingv1.PolicyType{policyType},
+			},
+		})
+	}
+	return netpols, issues
+}
+
+// reduceDirective builds the rules of an allow.  A deny can't be expressed
+// with rules; the closest v1 gets is isolation -- a policy without rules -- which
+// only works for a deny of all traffic to or from its target.  Even then,
+// allows of any priority override it.
+func reduceDirective(isIngress bool, directive Directive, edge *TrafficEdge, inv *inventory.Inventory) ([]networkingv1.NetworkPolicyIngressRule, []networkingv1.NetworkPolicyEgressRule, []*ReductionIssue) {
+	switch directive {
+	case DirectiveAllow:
+		return ReduceRules(isIngress, edge, inv)
+	case DirectiveDeny:
+		peer, peerField := edge.Source, "spec.trafficMatcher.source"
+		if !isIngress {
+			peer, peerField = edge.Dest, "spec.trafficMatcher.dest"
+		}
+		if edge.Port != nil || edge.Protocol != nil || (peer != nil && (peer.IP != nil || peer.RelativeLocation != nil || peer.Internal != nil)) {
+			return nil, nil, []*ReductionIssue{newIssue(peerField, ReductionStatusUnsupported,
+				"v1 policies can't deny: a deny must isolate its target from all peers, ports and protocols")}
+		}
+		return nil, nil, []*ReductionIssue{newIssue("spec.directive", ReductionStatusLossy,
+			"deny reduced to isolation, which allows override regardless of priority")}
+	default:
+		return nil, nil, []*ReductionIssue{newIssue("spec.directive", ReductionStatusUnsupported, "invalid directive %s", directive)}
+	}
+}
+
+// reduceTarget finds the namespaces and pod selector of the pods a policy applies to
+func reduceTarget(target *PeerMatcher, field string, inv *inventory.Inventory) ([]string, metav1.LabelSelector, []*ReductionIssue) {
+	if target == nil {
+		return targetNamespaces(nil, inv), metav1.LabelSelector{}, nil
+	}
+	if target.IP != nil {
+		return nil, metav1.LabelSelector{}, []*ReductionIssue{newIssue(field+".ip", ReductionStatusUnsupported, "v1 policies can only apply to pods")}
+	}
+	if target.RelativeLocation != nil && (*target.RelativeLocation == PeerLocationExternal || *target.RelativeLocation == PeerLocationHost) {
+		return nil, metav1.LabelSelector{}, []*ReductionIssue{newIssue(field+".relativeLocation", ReductionStatusUnsupported, "v1 policies can only apply to pods")}
+	}
+	if target.Internal == nil {
+		return targetNamespaces(nil, inv), metav1.LabelSelector{}, nil
+	}
+	i := target.Internal
+	issues := reduceNodes(i, field+".internal")
+	selector := i.PodLabels
+	for _, ref := range i.podReferences() {
+		selector = intersectLabelSelectors(selector, ref.PodSelector())
+	}
+	if i.Pod != nil {
+		podSelector, issue := reducePodName(i, field+".internal.pod", inv)
+		issues = append(issues, issue)
+		selector = intersectLabelSelectors(selector, podSelector)
+	}
+	if selector == nil {
+		selector = &metav1.LabelSelector{}
+	}
+	return targetNamespaces(target, inv), *selector, issues
+}
+
+// targetNamespaces finds the namespaces of the inventory which a target peer matches
+func targetNamespaces(target *PeerMatcher, inv *inventory.Inventory) []string {
+	if target != nil && target.Internal != nil && target.Internal.Namespace != nil {
+		return []string{target.Internal.Namespace.Value}
+	}
+	if target != nil && target.Internal != nil && len(target.Internal.podReferences()) > 0 {
+		// pods can only be in one namespace, so referring to several matches nothing
+		refs := target.Internal.podReferences()
+		for _, ref := range refs[1:] {
+			if ref.namespace() != refs[0].namespace() {
+				return nil
+			}
+		}
+		return []string{refs[0].namespace()}
+	}
+	var namespaces []string
+	for _, ns := range inv.Namespaces {
+		if target != nil && target.Internal != nil && target.Internal.NamespaceLabels != nil &&
+			!kube.IsLabelsMatchLabelSelector(inv.NamespaceLabels(ns.Name), *target.Internal.NamespaceLabels) {
+			continue
+		}
+		namespaces = append(namespaces, ns.Name)
+	}
+	return namespaces
+}
+
+// intersectLabelSelectors builds a selector matching what both a and b match
+func intersectLabelSelectors(a *metav1.LabelSelector, b *metav1.LabelSelector) *metav1.LabelSelector {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{}}
+	for k, v := range a.MatchLabels {
+		selector.MatchLabels[k] = v
+	}
+	selector.MatchExpressions = append(selector.MatchExpressions, a.MatchExpressions...)
+	for k, v := range b.MatchLabels {
+		if existing, ok := selector.MatchLabels[k]; ok && existing != v {
+			// conflicting values: nothing can match, which an extra requirement preserves
+			selector.MatchExpressions = append(selector.MatchExpressions, metav1.LabelSelectorRequirement{
+				Key:      k,
+				Operator: metav1.LabelSelectorOpIn,
+				Values:   []string{v},
 			})
+		} else {
+			selector.MatchLabels[k] = v
 		}
 	}
-	return pols
+	selector.MatchExpressions = append(selector.MatchExpressions, b.MatchExpressions...)
+	return selector
 }
 
-func ReduceRules(isIngress bool, edge *TrafficEdge) ([]networkingv1.NetworkPolicyIngressRule, []networkingv1.NetworkPolicyEgressRule) {
+// ReduceRules builds the v1 rules of an allow.  If any part of the edge is
+// unsupported, no rules are built: allowing less is safer than allowing more.
+func ReduceRules(isIngress bool, edge *TrafficEdge, inv *inventory.Inventory) ([]networkingv1.NetworkPolicyIngressRule, []networkingv1.NetworkPolicyEgressRule, []*ReductionIssue) {
 	var peers []networkingv1.NetworkPolicyPeer
+	var issues []*ReductionIssue
 	if isIngress {
-		peers = ReducePeerMatcher(edge.Source)
+		peers, issues = ReducePeerMatcher(edge.Source, "spec.trafficMatcher.source", inv)
 	} else {
-		peers = ReducePeerMatcher(edge.Dest)
+		peers, issues = ReducePeerMatcher(edge.Dest, "spec.trafficMatcher.dest", inv)
+	}
+	ports, portIssues := ReducePortProtocol(edge.Port, edge.Protocol)
+	issues = append(issues, portIssues...)
+	if hasUnsupported(issues) {
+		return nil, nil, issues
 	}
-	ports := ReducePortProtocol(edge.Port, edge.Protocol)
 	var ingress []networkingv1.NetworkPolicyIngressRule
 	var egress []networkingv1.NetworkPolicyEgressRule
 	if isIngress {
@@ -97,20 +380,25 @@ func ReduceRules(isIngress bool, edge *TrafficEdge) ([]networkingv1.NetworkPolic
 			To:    peers,
 		})
 	}
-	return ingress, egress
+	return ingress, egress, issues
 }
 
-func ReducePortProtocol(portMatcher *PortMatcher, protocolMatcher *ProtocolMatcher) []networkingv1.NetworkPolicyPort {
+// ReducePortProtocol builds v1 ports.  Without port and protocol matchers, no
+// ports are built, which v1 treats as all ports on all protocols.
+func ReducePortProtocol(portMatcher *PortMatcher, protocolMatcher *ProtocolMatcher) ([]networkingv1.NetworkPolicyPort, []*ReductionIssue) {
+	if portMatcher == nil && protocolMatcher == nil {
+		return nil, nil
+	}
 	var protocols []v1.Protocol
 	if protocolMatcher != nil {
 		for _, p := range protocolMatcher.Values {
 			protocols = append(protocols, p)
 		}
-	}
-	if len(protocols) == 0 {
-		protocols = []v1.Protocol{v1.ProtocolTCP, v1.ProtocolUDP} // TODO I guess SCTP can't be used?, v1.ProtocolSCTP}
+	} else {
+		protocols = []v1.Protocol{v1.ProtocolTCP, v1.ProtocolUDP, v1.ProtocolSCTP}
 	}
 	var npPorts []networkingv1.NetworkPolicyPort
+	var issues []*ReductionIssue
 	if portMatcher == nil {
 		for _, protocol := range protocols {
 			protocolRef := protocol
@@ -118,6 +406,8 @@ func ReducePortProtocol(portMatcher *PortMatcher, protocolMatcher *ProtocolMatch
 				Protocol: &protocolRef,
 			})
 		}
+	} else if portMatcher.Value != nil && portMatcher.Range != nil {
+		return nil, []*ReductionIssue{newIssue("spec.trafficMatcher.port", ReductionStatusUnsupported, "either range or value must be specified, not both")}
 	} else if portMatcher.Value != nil {
 		for _, protocol := range protocols {
 			// so that we don't get a ref to the wrong variable
@@ -128,45 +418,158 @@ func ReducePortProtocol(portMatcher *PortMatcher, protocolMatcher *ProtocolMatch
 			})
 		}
 	} else if portMatcher.Range != nil {
+		// crd ranges exclude High, while v1 ranges include EndPort
+		low, high := portMatcher.Range.Low, portMatcher.Range.High-1
+		if high < low {
+			return nil, []*ReductionIssue{newIssue("spec.trafficMatcher.port.range", ReductionStatusUnsupported, "empty port range [%d, %d) matches nothing", low, portMatcher.Range.High)}
+		}
+		var endPort *int32
+		if high > low {
+			endPortValue := int32(high)
+			endPort = &endPortValue
+			issues = append(issues, newIssue("spec.trafficMatcher.port.range", ReductionStatusLossless, "port range reduced to endPort %d, which requires kubernetes 1.21+", high))
+		}
 		for _, protocol := range protocols {
 			// so that we don't get a ref to the wrong variable
 			protocolRef := protocol
-			for port := portMatcher.Range.Low; port < portMatcher.Range.High; port++ {
-				portRef := intstr.FromInt(port)
-				npPorts = append(npPorts, networkingv1.NetworkPolicyPort{
-					Protocol: &protocolRef,
-					Port:     &portRef,
-				})
-			}
+			portRef := intstr.FromInt(low)
+			npPorts = append(npPorts, networkingv1.NetworkPolicyPort{
+				Protocol: &protocolRef,
+				Port:     &portRef,
+				EndPort:  endPort,
+			})
 		}
+	} else {
+		return nil, []*ReductionIssue{newIssue("spec.trafficMatcher.port", ReductionStatusUnsupported, "either range or value must be specified")}
 	}
-	return npPorts
+	return npPorts, issues
 }
 
-func ReducePeerMatcher(peer *PeerMatcher) []networkingv1.NetworkPolicyPeer {
-	// TODO check any/all of policy spec?
+// ReducePeerMatcher builds the v1 peers matching the same pods or IPs as a crd peer
+func ReducePeerMatcher(peer *PeerMatcher, field string, inv *inventory.Inventory) ([]networkingv1.NetworkPolicyPeer, []*ReductionIssue) {
 	if peer == nil {
-		// TODO what should this return?  for now we'll just match everything
-		return []networkingv1.NetworkPolicyPeer{}
+		// no peers means all peers
+		return []networkingv1.NetworkPolicyPeer{}, nil
+	}
+	if peer.IP != nil && peer.Internal != nil {
+		return nil, []*ReductionIssue{newIssue(field, ReductionStatusUnsupported, "v1 peers can't combine IP blocks with selectors")}
+	}
+	var location PeerLocation
+	if peer.RelativeLocation != nil {
+		location = *peer.RelativeLocation
 	}
 	if peer.IP != nil {
-		if peer.IP.Value != nil {
-			panic("unable to translate IP value")
+		if location == PeerLocationInternal {
+			return nil, []*ReductionIssue{newIssue(field+".relativeLocation", ReductionStatusUnsupported, "v1 peers can't restrict IP blocks to cluster-internal IPs")}
+		}
+		if location == PeerLocationHost {
+			return nil, []*ReductionIssue{newIssue(field+".relativeLocation", ReductionStatusUnsupported, "v1 peers can't restrict IP blocks to node IPs")}
+		}
+		return reduceIPMatcher(peer.IP, field+".ip")
+	}
+	if peer.Internal != nil {
+		if location == PeerLocationExternal || location == PeerLocationHost {
+			return nil, []*ReductionIssue{newIssue(field+".relativeLocation", ReductionStatusUnsupported, "an external peer can't match pods, so matches nothing")}
 		}
-		return []networkingv1.NetworkPolicyPeer{{IPBlock: peer.IP.Block}}
-	} else if peer.Internal != nil {
-		i := peer.Internal
-		if i.Pod != nil || i.Namespace != nil || i.NodeLabels != nil || i.Node != nil {
-			panic("unimplemented feature: internal peer matcher (pod/namespace/node/nodelabels")
+		return reduceInternalPeerMatcher(peer.Internal, field+".internal", inv)
+	}
+	switch location {
+	case PeerLocationInternal:
+		return []networkingv1.NetworkPolicyPeer{{NamespaceSelector: &metav1.LabelSelector{}}}, nil
+	case PeerLocationExternal:
+		return []networkingv1.NetworkPolicyPeer{
+			{IPBlock: &networkingv1.IPBlock{CIDR: "0.0.0.0/0"}},
+			{IPBlock: &networkingv1.IPBlock{CIDR: "::/0"}},
+		}, []*ReductionIssue{newIssue(field+".relativeLocation", ReductionStatusLossy,
+			"external reduced to all IPs: excluding cluster-internal IPs requires knowing the pod and node CIDRs")}
+	case PeerLocationHost:
+		return nil, []*ReductionIssue{newIssue(field+".relativeLocation", ReductionStatusUnsupported, "v1 peers can only match hosts through IP blocks of their node IPs")}
+	}
+	// an empty peer matcher matches everything
+	return []networkingv1.NetworkPolicyPeer{}, nil
+}
+
+func reduceIPMatcher(ipm *IPMatcher, field string) ([]networkingv1.NetworkPolicyPeer, []*ReductionIssue) {
+	if ipm.Value == nil {
+		return []networkingv1.NetworkPolicyPeer{{IPBlock: ipm.Block}}, nil
+	}
+	ip := net.ParseIP(*ipm.Value)
+	if ip == nil {
+		return nil, []*ReductionIssue{newIssue(field+".value", ReductionStatusUnsupported, "invalid IP %s", *ipm.Value)}
+	}
+	cidr := fmt.Sprintf("%s/32", ip.String())
+	if ip.To4() == nil {
+		cidr = fmt.Sprintf("%s/128", ip.String())
+	}
+	return []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: cidr}}},
+		[]*ReductionIssue{newIssue(field+".value", ReductionStatusLossless, "IP %s reduced to IP block %s", *ipm.Value, cidr)}
+}
+
+func reduceInternalPeerMatcher(i *InternalPeerMatcher, field string, inv *inventory.Inventory) ([]networkingv1.NetworkPolicyPeer, []*ReductionIssue) {
+	issues := reduceNodes(i, field)
+	if hasUnsupported(issues) {
+		return nil, issues
+	}
+
+	peer := networkingv1.NetworkPolicyPeer{
+		PodSelector:       i.PodLabels,
+		NamespaceSelector: i.NamespaceLabels,
+	}
+	if i.Namespace != nil {
+		peer.NamespaceSelector = intersectLabelSelectors(peer.NamespaceSelector, namespaceNameSelector(i.Namespace.Value))
+		issues = append(issues, newIssue(field+".namespace", ReductionStatusLossless,
+			"namespace name reduced to the %s label", v1.LabelMetadataName))
+	}
+	if i.Pod != nil {
+		podSelector, issue := reducePodName(i, field+".pod", inv)
+		issues = append(issues, issue)
+		if issue.Status == ReductionStatusUnsupported {
+			return nil, issues
 		}
-		peer := networkingv1.NetworkPolicyPeer{}
-		if i.PodLabels != nil {
-			peer.PodSelector = i.PodLabels
+		peer.PodSelector = intersectLabelSelectors(peer.PodSelector, podSelector)
+	}
+	for _, ref := range i.podReferences() {
+		peer.PodSelector = intersectLabelSelectors(peer.PodSelector, ref.PodSelector())
+		peer.NamespaceSelector = intersectLabelSelectors(peer.NamespaceSelector, namespaceNameSelector(ref.namespace()))
+	}
+	if peer.NamespaceSelector == nil {
+		// crd peers aren't namespaced: without this, v1 would only select
+		//   pods in the policy's own namespace
+		peer.NamespaceSelector = &metav1.LabelSelector{}
+	}
+	return []networkingv1.NetworkPolicyPeer{peer}, issues
+}
+
+func reduceNodes(i *InternalPeerMatcher, field string) []*ReductionIssue {
+	var issues []*ReductionIssue
+	if i.Node != nil {
+		issues = append(issues, newIssue(field+".node", ReductionStatusUnsupported, "v1 policies can't select pods by node"))
+	}
+	if i.NodeLabels != nil {
+		issues = append(issues, newIssue(field+".nodeLabels", ReductionStatusUnsupported, "v1 policies can't select pods by node"))
+	}
+	return issues
+}
+
+// reducePodName selects a pod by name.  Pods don't have a well-known name
+// label -- except for StatefulSet pods -- so that only works if every pod of
+// that name in the inventory is a StatefulSet pod.
+func reducePodName(i *InternalPeerMatcher, field string, inv *inventory.Inventory) (*metav1.LabelSelector, *ReductionIssue) {
+	name := i.Pod.Value
+	found := false
+	for _, pod := range inv.Pods {
+		if pod.Name != name || (i.Namespace != nil && pod.Namespace != i.Namespace.Value) {
+			continue
 		}
-		if i.NamespaceLabels != nil {
-			peer.NamespaceSelector = i.NamespaceLabels
+		found = true
+		if pod.Labels[appsv1.StatefulSetPodNameLabel] != name {
+			return nil, newIssue(field, ReductionStatusUnsupported,
+				"pod names can only be selected through the %s label, which pod %s doesn't have", appsv1.StatefulSetPodNameLabel, pod.Key())
 		}
-		return []networkingv1.NetworkPolicyPeer{peer}
 	}
-	return []networkingv1.NetworkPolicyPeer{}
+	if !found {
+		return nil, newIssue(field, ReductionStatusUnsupported, "pod %s not found in inventory", name)
+	}
+	return &metav1.LabelSelector{MatchLabels: map[string]string{appsv1.StatefulSetPodNameLabel: name}},
+		newIssue(field, ReductionStatusLossless, "pod name reduced to the %s label", appsv1.StatefulSetPodNameLabel)
 }
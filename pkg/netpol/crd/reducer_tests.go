@@ -0,0 +1,261 @@
+package crd
+
+import (
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/inventory"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/matcher"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+var reducerInventory = &inventory.Inventory{
+	Namespaces: []*inventory.Namespace{
+		{Name: "x", Labels: map[string]string{"env": "prod"}},
+		{Name: "y", Labels: map[string]string{"env": "dev"}},
+		{Name: "z", Labels: map[string]string{"env": "prod"}},
+	},
+	Pods: []*inventory.Pod{
+		{Namespace: "x", Name: "db-0", Labels: map[string]string{"app": "db", appsv1.StatefulSetPodNameLabel: "db-0"}},
+		{Namespace: "x", Name: "web-abcde", Labels: map[string]string{"app": "web"}},
+	},
+}
+
+func allowIngressFrom(source *PeerMatcher) *Policy {
+	return &Policy{
+		ObjectMeta: metav1.ObjectMeta{Name: "allow-ingress"},
+		Spec: PolicySpec{
+			Compatibility: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			TrafficMatcher: &TrafficEdge{
+				Type:   TrafficMatchTypeAll,
+				Source: source,
+				Dest: &PeerMatcher{
+					Internal: &InternalPeerMatcher{Namespace: &StringMatcher{Value: "y"}},
+				},
+			},
+			Directive: DirectiveAllow,
+		},
+	}
+}
+
+// expectReducedMatches checks that the reduced v1 policies allow exactly the traffic
+// matched by an allow's edge, or not matched by a deny's, for every pair of pods
+// and every exposed port
+func expectReducedMatches(policy *Policy, inv *inventory.Inventory) {
+	reduction, err := Reduce(policy, inv)
+	Expect(err).To(Succeed())
+	Expect(reduction.IsSupported()).To(BeTrue())
+
+	reduced := matcher.BuildNetworkPolicies(reduction.NetworkPolicies)
+	for _, from := range inv.Pods {
+		for _, to := range inv.Pods {
+			for _, port := range podPorts(to) {
+				isMatch := policy.Spec.TrafficMatcher.Matches(&Traffic{
+					Source:      InventoryPeer(inv, from),
+					Destination: InventoryPeer(inv, to),
+					Protocol:    port.Protocol,
+					Port:        intstr.FromInt(port.Port),
+				})
+				actual := reduced.IsTrafficAllowed(&matcher.Traffic{
+					Source:       matcher.InventoryPeer(inv, from),
+					Destination:  matcher.InventoryPeer(inv, to),
+					PortProtocol: &matcher.PortProtocol{Protocol: port.Protocol, Port: intstr.FromInt(port.Port)},
+				})
+				Expect(actual.IsAllowed()).To(Equal(isMatch == (policy.Spec.Directive == DirectiveAllow)), "%s -> %s on %d/%s", from.Key(), to.Key(), port.Port, port.Protocol)
+			}
+		}
+	}
+}
+
+func RunReducerTests() {
+	Describe("Reduce: cluster-scoped fan out", func() {
+		It("fans a namespace-agnostic policy out to every namespace", func() {
+			reduction, err := Reduce(DenyAll, reducerInventory)
+			Expect(err).To(Succeed())
+
+			Expect(reduction.NetworkPolicies).To(HaveLen(6))
+			Expect(reduction.SortedNamespaces()).To(Equal([]string{"x", "y", "z"}))
+			Expect(reduction.Namespaces["y"]).To(Equal([]string{"deny-all-egress-0", "deny-all-ingress-1"}))
+		})
+
+		It("fans a policy out to namespaces matching its namespace labels", func() {
+			reduction, err := Reduce(AllowIngressToNamespace(map[string]string{"env": "prod"}), reducerInventory)
+			Expect(err).To(Succeed())
+
+			Expect(reduction.SortedNamespaces()).To(Equal([]string{"x", "z"}))
+		})
+
+		It("doesn't fan out a policy for a single namespace", func() {
+			reduction, err := Reduce(DenyEgressFromNamespace("q"), reducerInventory)
+			Expect(err).To(Succeed())
+
+			Expect(reduction.Namespaces).To(Equal(map[string][]string{"q": {"deny-egress-from-ns-q-egress-0"}}))
+		})
+
+		It("selects peers in every namespace when only pod labels are given", func() {
+			peers, issues := ReducePeerMatcher(&PeerMatcher{
+				Internal: &InternalPeerMatcher{PodLabels: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}},
+			}, "source", reducerInventory)
+			Expect(issues).To(BeEmpty())
+
+			Expect(peers).To(Equal([]networkingv1.NetworkPolicyPeer{{
+				PodSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+				NamespaceSelector: &metav1.LabelSelector{},
+			}}))
+		})
+	})
+
+	Describe("Reduce: issues", func() {
+		It("reduces exact IPs to /32 and /128 blocks", func() {
+			ipv4, ipv6 := "10.1.2.3", "fd00::1"
+			for ip, cidr := range map[string]string{ipv4: "10.1.2.3/32", ipv6: "fd00::1/128"} {
+				value := ip
+				peers, issues := ReducePeerMatcher(&PeerMatcher{IP: &IPMatcher{Value: &value}}, "source", reducerInventory)
+
+				Expect(peers).To(Equal([]networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: cidr}}}))
+				Expect(issues).To(HaveLen(1))
+				Expect(issues[0].Status).To(Equal(ReductionStatusLossless))
+			}
+		})
+
+		It("reduces namespace names through the metadata.name label", func() {
+			peers, issues := ReducePeerMatcher(&PeerMatcher{
+				Internal: &InternalPeerMatcher{Namespace: &StringMatcher{Value: "x"}},
+			}, "source", reducerInventory)
+
+			Expect(peers).To(Equal([]networkingv1.NetworkPolicyPeer{{NamespaceSelector: namespaceNameSelector("x")}}))
+			Expect(issues[0].Field).To(Equal("source.internal.namespace"))
+			Expect(issues[0].Status).To(Equal(ReductionStatusLossless))
+		})
+
+		It("reduces statefulset pod names through the pod-name label", func() {
+			peers, issues := ReducePeerMatcher(&PeerMatcher{
+				Internal: &InternalPeerMatcher{Pod: &StringMatcher{Value: "db-0"}},
+			}, "source", reducerInventory)
+
+			Expect(peers).To(Equal([]networkingv1.NetworkPolicyPeer{{
+				PodSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{appsv1.StatefulSetPodNameLabel: "db-0"}},
+				NamespaceSelector: &metav1.LabelSelector{},
+			}}))
+			Expect(issues[0].Status).To(Equal(ReductionStatusLossless))
+		})
+
+		It("reports other pod names as unsupported, and leaves out the rule", func() {
+			reduction, err := Reduce(allowIngressFrom(&PeerMatcher{
+				Internal: &InternalPeerMatcher{Pod: &StringMatcher{Value: "web-abcde"}},
+			}), reducerInventory)
+			Expect(err).To(Succeed())
+
+			Expect(reduction.IsSupported()).To(BeFalse())
+			Expect(reduction.NetworkPolicies).To(HaveLen(1))
+			Expect(reduction.NetworkPolicies[0].Spec.Ingress).To(BeEmpty())
+			Expect(reduction.Issues[len(reduction.Issues)-1].Field).To(Equal("spec.trafficMatcher.source.internal.pod"))
+		})
+
+		It("reports nodes as unsupported instead of panicking", func() {
+			reduction, err := Reduce(allowIngressFrom(&PeerMatcher{
+				Internal: &InternalPeerMatcher{NodeLabels: &metav1.LabelSelector{}},
+			}), reducerInventory)
+			Expect(err).To(Succeed())
+
+			Expect(reduction.IsSupported()).To(BeFalse())
+			Expect(reduction.Issues[0].Policy).To(Equal("allow-ingress"))
+			Expect(reduction.Issues[0].Status).To(Equal(ReductionStatusUnsupported))
+		})
+
+		It("reports host peers as unsupported, and matches them as external", func() {
+			peers, issues := ReducePeerMatcher(&PeerMatcher{RelativeLocation: &PeerLocationHost}, "source", reducerInventory)
+			Expect(peers).To(BeNil())
+			Expect(issues).To(HaveLen(1))
+			Expect(issues[0].Status).To(Equal(ReductionStatusUnsupported))
+
+			host := &Peer{Host: &HostPeer{Node: "node-1"}, IP: "192.168.0.1"}
+			Expect((&PeerMatcher{RelativeLocation: &PeerLocationHost}).Matches(host)).To(BeTrue())
+			Expect((&PeerMatcher{RelativeLocation: &PeerLocationExternal}).Matches(host)).To(BeTrue())
+			Expect((&PeerMatcher{RelativeLocation: &PeerLocationHost}).Matches(&Peer{IP: "192.168.0.1"})).To(BeFalse())
+		})
+
+		It("reduces port ranges to a single port with an endPort", func() {
+			ports, issues := ReducePortProtocol(&PortMatcher{Range: &struct {
+				Low  int
+				High int
+			}{Low: 8000, High: 8081}}, &ProtocolMatcher{Values: []v1.Protocol{v1.ProtocolTCP}})
+
+			tcp := v1.ProtocolTCP
+			port := intstr.FromInt(8000)
+			endPort := int32(8080)
+			Expect(ports).To(Equal([]networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &port, EndPort: &endPort}}))
+			Expect(issues[0].Status).To(Equal(ReductionStatusLossless))
+		})
+
+		It("reports denies which don't isolate as unsupported", func() {
+			reduction, err := Reduce(&Policy{
+				ObjectMeta: metav1.ObjectMeta{Name: "deny-port-80"},
+				Spec: PolicySpec{
+					Compatibility:  []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+					TrafficMatcher: &TrafficEdge{Type: TrafficMatchTypeAll, Port: NumberedPortMatcher(80)},
+					Directive:      DirectiveDeny,
+				},
+			}, reducerInventory)
+			Expect(err).To(Succeed())
+
+			Expect(reduction.IsSupported()).To(BeFalse())
+			Expect(reduction.NetworkPolicies).To(BeEmpty())
+		})
+	})
+	Describe("Reduce: Any edges", func() {
+		It("reduces an allow to one policy per disjunct", func() {
+			policy := &Policy{
+				ObjectMeta: metav1.ObjectMeta{Name: "allow-any"},
+				Spec: PolicySpec{
+					Compatibility: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+					TrafficMatcher: &TrafficEdge{
+						Type: TrafficMatchTypeAny,
+						Source: &PeerMatcher{
+							Internal: &InternalPeerMatcher{Namespace: &StringMatcher{Value: "blackduck"}},
+						},
+						Dest: &PeerMatcher{
+							Internal: &InternalPeerMatcher{PodLabels: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "dns"}}},
+						},
+						Port: NumberedPortMatcher(5432),
+					},
+					Directive: DirectiveAllow,
+				},
+			}
+			reduction, err := Reduce(policy, compilerInventory())
+			Expect(err).To(Succeed())
+			Expect(reduction.Namespaces["other"]).To(Equal([]string{"allow-any-ingress-0-0", "allow-any-ingress-0-1", "allow-any-ingress-0-2"}))
+
+			expectReducedMatches(policy, compilerInventory())
+		})
+
+		It("reduces the blackduck deny to isolation in both directions", func() {
+			bd := &Blackduck{Namespace: "blackduck"}
+			reduction, err := Reduce(bd.DenyAll(), compilerInventory())
+			Expect(err).To(Succeed())
+			Expect(reduction.Namespaces).To(Equal(map[string][]string{
+				"blackduck": {"deny-all-blackduck-traffic-egress-0-0", "deny-all-blackduck-traffic-ingress-1-1"},
+			}))
+
+			expectReducedMatches(bd.DenyAll(), compilerInventory())
+		})
+
+		It("reduces an Any edge without matchers to nothing", func() {
+			reduction, err := Reduce(&Policy{
+				ObjectMeta: metav1.ObjectMeta{Name: "allow-nothing"},
+				Spec: PolicySpec{
+					Compatibility:  []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+					TrafficMatcher: &TrafficEdge{Type: TrafficMatchTypeAny},
+					Directive:      DirectiveAllow,
+				},
+			}, compilerInventory())
+			Expect(err).To(Succeed())
+
+			Expect(reduction.NetworkPolicies).To(BeEmpty())
+			Expect(reduction.IsLossless()).To(BeTrue())
+		})
+	})
+}
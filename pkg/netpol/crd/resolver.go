@@ -0,0 +1,94 @@
+package crd
+
+import (
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/inventory"
+	"github.com/pkg/errors"
+)
+
+// ResolvePeers looks up the objects which peers refer to by name -- such as
+// Services and Deployments -- in an inventory, and returns copies of the policies
+// with the pod selectors they resolve to filled in; the policies passed in aren't
+// modified.  Until they're resolved, such peers match no pods.
+func ResolvePeers(policies []*Policy, inv *inventory.Inventory) ([]*Policy, error) {
+	var resolved []*Policy
+	for _, policy := range policies {
+		policy = copyPeers(policy)
+		edge := policy.Spec.TrafficMatcher
+		for _, peer := range []*PeerMatcher{edge.Source, edge.Dest} {
+			if peer == nil || peer.Internal == nil {
+				continue
+			}
+			if err := resolveService(peer.Internal.Service, inv); err != nil {
+				return nil, errors.WithMessagef(err, "unable to resolve peers of policy %s", policy.Name)
+			}
+			if err := resolveWorkload(peer.Internal.Workload, inv); err != nil {
+				return nil, errors.WithMessagef(err, "unable to resolve peers of policy %s", policy.Name)
+			}
+		}
+		resolved = append(resolved, policy)
+	}
+	return resolved, nil
+}
+
+// copyPeers copies a policy down to the references of its peers, which resolving fills in
+func copyPeers(policy *Policy) *Policy {
+	policyCopy := *policy
+	edge := *policy.Spec.TrafficMatcher
+	edge.Source = copyPeerMatcher(edge.Source)
+	edge.Dest = copyPeerMatcher(edge.Dest)
+	policyCopy.Spec.TrafficMatcher = &edge
+	return &policyCopy
+}
+
+func copyPeerMatcher(pm *PeerMatcher) *PeerMatcher {
+	if pm == nil || pm.Internal == nil {
+		return pm
+	}
+	peer := *pm
+	internal := *pm.Internal
+	if internal.Service != nil {
+		service := *internal.Service
+		internal.Service = &service
+	}
+	if internal.Workload != nil {
+		workload := *internal.Workload
+		internal.Workload = &workload
+	}
+	peer.Internal = &internal
+	return &peer
+}
+
+func resolveService(sm *ServiceMatcher, inv *inventory.Inventory) error {
+	if sm == nil {
+		return nil
+	}
+	svc := inv.Service(sm.Namespace, sm.Name)
+	if svc == nil {
+		return errors.Errorf("service %s/%s not found", sm.Namespace, sm.Name)
+	}
+	// a Service without a selector has its endpoints managed by hand, so
+	//   there's no way to tell which pods are behind it
+	if len(svc.Selector) == 0 {
+		return errors.Errorf("service %s/%s has no selector", sm.Namespace, sm.Name)
+	}
+	sm.Selector = map[string]string{}
+	for key, value := range svc.Selector {
+		sm.Selector[key] = value
+	}
+	return nil
+}
+
+func resolveWorkload(wm *WorkloadMatcher, inv *inventory.Inventory) error {
+	if wm == nil {
+		return nil
+	}
+	workload := inv.Workload(wm.Kind, wm.Namespace, wm.Name)
+	if workload == nil {
+		return errors.Errorf("%s %s/%s not found", wm.Kind, wm.Namespace, wm.Name)
+	}
+	if workload.Selector == nil {
+		return errors.Errorf("%s %s/%s has no selector", wm.Kind, wm.Namespace, wm.Name)
+	}
+	wm.Selector = workload.Selector.DeepCopy()
+	return nil
+}
@@ -0,0 +1,151 @@
+package crd
+
+import (
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/inventory"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+var resolverInventory = &inventory.Inventory{
+	Namespaces: []*inventory.Namespace{{Name: "x"}, {Name: "y"}},
+	Services: []*inventory.Service{
+		{Namespace: "x", Name: "web", Selector: map[string]string{"app": "web"}},
+		{Namespace: "y", Name: "db", Selector: map[string]string{"app": "db"}},
+		{Namespace: "y", Name: "external", Selector: nil},
+	},
+	Workloads: []*inventory.Workload{
+		{
+			Kind:      inventory.WorkloadKindDeployment,
+			Namespace: "x",
+			Name:      "frontend",
+			Selector: &metav1.LabelSelector{
+				MatchLabels:      map[string]string{"app": "web"},
+				MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "tier", Operator: metav1.LabelSelectorOpIn, Values: []string{"front"}}},
+			},
+		},
+	},
+}
+
+func allowIngressFromWorkload(kind inventory.WorkloadKind, ns string, name string) *Policy {
+	return &Policy{
+		ObjectMeta: metav1.ObjectMeta{Name: "allow-from-" + name},
+		Spec: PolicySpec{
+			Compatibility: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			TrafficMatcher: &TrafficEdge{
+				Type: TrafficMatchTypeAll,
+				Source: &PeerMatcher{
+					Internal: &InternalPeerMatcher{
+						Workload: &WorkloadMatcher{Kind: kind, Namespace: ns, Name: name},
+					},
+				},
+				Dest: &PeerMatcher{
+					Internal: &InternalPeerMatcher{Namespace: &StringMatcher{Value: "y"}},
+				},
+			},
+			Directive: DirectiveAllow,
+		},
+	}
+}
+
+func RunResolverTests() {
+	Describe("Service peers", func() {
+		It("resolves services and matches the pods behind them", func() {
+			policy := AllowIngressFromServiceToService("x", "web", "y", "db")
+			resolved, err := ResolvePeers([]*Policy{policy}, resolverInventory)
+			Expect(err).To(Succeed())
+
+			source := resolved[0].Spec.TrafficMatcher.Source.Internal
+			Expect(source.Matches(&InternalPeer{Namespace: "x", PodLabels: map[string]string{"app": "web"}})).To(BeTrue())
+			Expect(source.Matches(&InternalPeer{Namespace: "y", PodLabels: map[string]string{"app": "web"}})).To(BeFalse())
+			Expect(source.Matches(&InternalPeer{Namespace: "x", PodLabels: map[string]string{"app": "db"}})).To(BeFalse())
+		})
+
+		It("reduces services to pod and namespace selectors", func() {
+			policy := AllowIngressFromServiceToService("x", "web", "y", "db")
+			reduction, err := Reduce(policy, resolverInventory)
+			Expect(err).To(Succeed())
+			Expect(reduction.NetworkPolicies).To(HaveLen(1))
+			netpol := reduction.NetworkPolicies[0]
+			Expect(netpol.Namespace).To(Equal("y"))
+			Expect(netpol.Spec.PodSelector).To(Equal(metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}))
+			Expect(netpol.Spec.Ingress[0].From).To(Equal([]networkingv1.NetworkPolicyPeer{{
+				PodSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{v1.LabelMetadataName: "x"}},
+			}}))
+		})
+
+		It("matches no pods until resolved", func() {
+			policy := AllowIngressFromServiceToService("x", "web", "y", "db")
+			web := &Peer{Internal: &InternalPeer{Namespace: "x", PodLabels: map[string]string{"app": "web"}}}
+			db := &Peer{Internal: &InternalPeer{Namespace: "y", PodLabels: map[string]string{"app": "db"}}}
+			traffic := &Traffic{Source: web, Destination: db, Protocol: v1.ProtocolTCP, Port: intstr.FromInt(80)}
+
+			Expect(policy.Spec.TrafficMatcher.Source.Matches(web)).To(BeFalse())
+			Expect((&Policies{Policies: []*Policy{policy}}).Allows(traffic)).To(BeTrue())
+
+			resolved, err := ResolvePeers([]*Policy{policy}, resolverInventory)
+			Expect(err).To(Succeed())
+			Expect(resolved[0].Spec.TrafficMatcher.Source.Matches(web)).To(BeTrue())
+		})
+
+		It("doesn't modify the policies it resolves", func() {
+			policy := AllowIngressFromServiceToService("x", "web", "y", "db")
+			_, err := ResolvePeers([]*Policy{policy}, resolverInventory)
+			Expect(err).To(Succeed())
+			Expect(policy.Spec.TrafficMatcher.Source.Internal.Service.Selector).To(BeNil())
+
+			_, err = Reduce(policy, resolverInventory)
+			Expect(err).To(Succeed())
+			Expect(policy.Spec.TrafficMatcher.Dest.Internal.Service.Selector).To(BeNil())
+		})
+
+		It("fails to resolve missing services", func() {
+			policy := AllowIngressFromServiceToService("x", "missing", "y", "db")
+			_, err := ResolvePeers([]*Policy{policy}, resolverInventory)
+			Expect(err).NotTo(Succeed())
+		})
+
+		It("fails to resolve services without selectors", func() {
+			policy := AllowIngressFromServiceToService("x", "web", "y", "external")
+			_, err := ResolvePeers([]*Policy{policy}, resolverInventory)
+			Expect(err).NotTo(Succeed())
+		})
+	})
+
+	Describe("Workload peers", func() {
+		It("resolves workloads and matches the pods they own", func() {
+			policy := allowIngressFromWorkload(inventory.WorkloadKindDeployment, "x", "frontend")
+			resolved, err := ResolvePeers([]*Policy{policy}, resolverInventory)
+			Expect(err).To(Succeed())
+
+			source := resolved[0].Spec.TrafficMatcher.Source.Internal
+			Expect(source.Matches(&InternalPeer{Namespace: "x", PodLabels: map[string]string{"app": "web", "tier": "front"}})).To(BeTrue())
+			Expect(source.Matches(&InternalPeer{Namespace: "x", PodLabels: map[string]string{"app": "web"}})).To(BeFalse())
+			Expect(source.Matches(&InternalPeer{Namespace: "y", PodLabels: map[string]string{"app": "web", "tier": "front"}})).To(BeFalse())
+		})
+
+		It("reduces workloads to pod and namespace selectors", func() {
+			reduction, err := Reduce(allowIngressFromWorkload(inventory.WorkloadKindDeployment, "x", "frontend"), resolverInventory)
+			Expect(err).To(Succeed())
+
+			Expect(reduction.NetworkPolicies[0].Spec.Ingress[0].From).To(Equal([]networkingv1.NetworkPolicyPeer{{
+				PodSelector:       resolverInventory.Workloads[0].Selector,
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{v1.LabelMetadataName: "x"}},
+			}}))
+
+			// the emitted selector is a copy of the workload's
+			Expect(reduction.NetworkPolicies[0].Spec.Ingress[0].From[0].PodSelector).NotTo(BeIdenticalTo(resolverInventory.Workloads[0].Selector))
+			reduction.NetworkPolicies[0].Spec.Ingress[0].From[0].PodSelector.MatchLabels["app"] = "changed"
+			Expect(resolverInventory.Workloads[0].Selector.MatchLabels).To(Equal(map[string]string{"app": "web"}))
+		})
+
+		It("returns an error for unresolvable workloads", func() {
+			_, err := Reduce(allowIngressFromWorkload(inventory.WorkloadKindStatefulSet, "x", "frontend"), resolverInventory)
+			Expect(err).To(MatchError(ContainSubstring("StatefulSet x/frontend not found")))
+		})
+	})
+}
@@ -0,0 +1,18 @@
+package crd
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestModel(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunPrecedenceTests()
+	RunCompilerTests()
+	RunReducerTests()
+	RunResolverTests()
+	RunVerifierTests()
+	RunSpecs(t, "network policy crd suite")
+}
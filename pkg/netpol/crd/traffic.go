@@ -15,13 +15,19 @@ type Traffic struct {
 
 type Peer struct {
 	Internal *InternalPeer
-	IP       string
+	// Host is set for a node, or a hostNetwork pod on it, which are external to the pod network
+	Host *HostPeer
+	IP   string
 }
 
 func (tc *Peer) IsExternal() bool {
 	return tc.Internal == nil
 }
 
+func (tc *Peer) IsHost() bool {
+	return tc.Host != nil
+}
+
 type InternalPeer struct {
 	PodLabels       map[string]string
 	Pod             string
@@ -30,3 +36,8 @@ type InternalPeer struct {
 	NodeLabels      map[string]string
 	Node            string
 }
+
+type HostPeer struct {
+	NodeLabels map[string]string
+	Node       string
+}
@@ -2,6 +2,7 @@ package crd
 
 import (
 	"github.com/mattfenwick/kube-prototypes/pkg/kube"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/inventory"
 	"github.com/pkg/errors"
 	v1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
@@ -64,6 +65,8 @@ type PeerLocation string
 var (
 	PeerLocationInternal PeerLocation = "internal"
 	PeerLocationExternal PeerLocation = "external"
+	// PeerLocationHost is nodes and hostNetwork pods, which are also external
+	PeerLocationHost PeerLocation = "host"
 )
 
 type PeerMatcher struct {
@@ -83,6 +86,9 @@ func (pm *PeerMatcher) Matches(p *Peer) bool {
 		if *pm.RelativeLocation == PeerLocationExternal && !p.IsExternal() {
 			return false
 		}
+		if *pm.RelativeLocation == PeerLocationHost && !p.IsHost() {
+			return false
+		}
 	}
 	if pm.Internal != nil && !pm.Internal.Matches(p.Internal) {
 		return false
@@ -97,8 +103,8 @@ type InternalPeerMatcher struct {
 	NodeLabels      *metav1.LabelSelector
 	Pod             *StringMatcher
 	PodLabels       *metav1.LabelSelector
-	// TODO services, deployments?
-	//Service *StringMatcher
+	Service         *ServiceMatcher
+	Workload        *WorkloadMatcher
 }
 
 func (ipm *InternalPeerMatcher) Matches(i *InternalPeer) bool {
@@ -120,9 +126,77 @@ func (ipm *InternalPeerMatcher) Matches(i *InternalPeer) bool {
 	if ipm.PodLabels != nil && !kube.IsLabelsMatchLabelSelector(i.PodLabels, *ipm.PodLabels) {
 		return false
 	}
+	for _, ref := range ipm.podReferences() {
+		selector := ref.PodSelector()
+		if selector == nil || i.Namespace != ref.namespace() || !kube.IsLabelsMatchLabelSelector(i.PodLabels, *selector) {
+			return false
+		}
+	}
 	return true
 }
 
+// podReference is a reference to an object standing for a set of pods in a
+// namespace, such as a Service or a Deployment
+type podReference interface {
+	namespace() string
+	// PodSelector is the pod label selector which the reference resolves to, or
+	// nil if it hasn't been resolved -- in which case it matches no pods
+	PodSelector() *metav1.LabelSelector
+}
+
+func (ipm *InternalPeerMatcher) podReferences() []podReference {
+	var refs []podReference
+	if ipm.Service != nil {
+		refs = append(refs, ipm.Service)
+	}
+	if ipm.Workload != nil {
+		refs = append(refs, ipm.Workload)
+	}
+	return refs
+}
+
+// ServiceMatcher matches the pods behind a Service: the pods in the Service's
+// namespace which its selector selects
+type ServiceMatcher struct {
+	Namespace string
+	Name      string
+	// Selector is the Service's spec.selector, filled in by ResolvePeers
+	Selector map[string]string
+}
+
+func (sm *ServiceMatcher) namespace() string {
+	return sm.Namespace
+}
+
+func (sm *ServiceMatcher) PodSelector() *metav1.LabelSelector {
+	if sm.Selector == nil {
+		return nil
+	}
+	matchLabels := map[string]string{}
+	for key, value := range sm.Selector {
+		matchLabels[key] = value
+	}
+	return &metav1.LabelSelector{MatchLabels: matchLabels}
+}
+
+// WorkloadMatcher matches the pods owned by a pod controller: the pods in
+// the controller's namespace which its selector selects
+type WorkloadMatcher struct {
+	Kind      inventory.WorkloadKind
+	Namespace string
+	Name      string
+	// Selector is the controller's spec.selector, filled in by ResolvePeers
+	Selector *metav1.LabelSelector
+}
+
+func (wm *WorkloadMatcher) namespace() string {
+	return wm.Namespace
+}
+
+func (wm *WorkloadMatcher) PodSelector() *metav1.LabelSelector {
+	return wm.Selector.DeepCopy()
+}
+
 type StringMatcher struct {
 	Value string
 }
@@ -143,7 +217,11 @@ func (pm *PortMatcher) Matches(port intstr.IntOrString) bool {
 	if (pm.Range == nil && pm.Value == nil) || (pm.Range != nil && pm.Value != nil) {
 		panic("either Range or Value must be specified")
 	}
-	if pm.Range != nil && port.Type == intstr.Int {
+	if pm.Range != nil {
+		// named ports aren't resolved, so they can't be in a range
+		if port.Type != intstr.Int {
+			return false
+		}
 		portNumber := int(port.IntVal)
 		return portNumber >= pm.Range.Low && portNumber < pm.Range.High
 	}
@@ -0,0 +1,135 @@
+package crd
+
+import (
+	"fmt"
+
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/inventory"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/matcher"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// Universe is the traffic checked by Verify: between every pair of peers --
+// the pods of an inventory, plus some external IPs -- on every port.  Traffic
+// between two external IPs isn't checked, since no policy can apply to it.
+//
+// crd port matchers compare port names and numbers literally, while v1
+// policies resolve them through the destination's container ports: named
+// container ports will therefore show up as disagreements.
+type Universe struct {
+	Inventory   *inventory.Inventory
+	ExternalIPs []string
+	Ports       []*matcher.PortProtocol
+}
+
+type verifierPeer struct {
+	Name    string
+	Crd     *Peer
+	Matcher *matcher.TrafficPeer
+}
+
+func (u *Universe) peers() []*verifierPeer {
+	var peers []*verifierPeer
+	for _, pod := range u.Inventory.Pods {
+		peers = append(peers, &verifierPeer{
+			Name:    string(pod.Key()),
+			Crd:     InventoryPeer(u.Inventory, pod),
+			Matcher: matcher.InventoryPeer(u.Inventory, pod),
+		})
+	}
+	for _, ip := range u.ExternalIPs {
+		peers = append(peers, &verifierPeer{
+			Name:    ip,
+			Crd:     &Peer{IP: ip},
+			Matcher: &matcher.TrafficPeer{IP: ip},
+		})
+	}
+	return peers
+}
+
+// Disagreement is traffic which v1 and crd policies don't agree on
+type Disagreement struct {
+	Source      string
+	Destination string
+	Port        *matcher.PortProtocol
+	IsV1Allowed bool
+	// CrdPolicy is the crd policy deciding the traffic, or nil if no policy matched
+	CrdPolicy *Policy
+}
+
+func (d *Disagreement) IsCrdAllowed() bool {
+	return !d.IsV1Allowed
+}
+
+func (d *Disagreement) String() string {
+	decider := "no policy"
+	if d.CrdPolicy != nil {
+		decider = fmt.Sprintf("policy %s", d.CrdPolicy.Name)
+	}
+	return fmt.Sprintf("%s -> %s on %s/%s: v1 allowed %t, crd allowed %t by %s",
+		d.Source, d.Destination, d.Port.Port.String(), d.Port.Protocol, d.IsV1Allowed, d.IsCrdAllowed(), decider)
+}
+
+// Verify evaluates every traffic of a universe against v1 policies and crd
+// policies, and reports the traffic on which they disagree
+func Verify(netpols []*networkingv1.NetworkPolicy, policies *Policies, universe *Universe) []*Disagreement {
+	v1Policy := matcher.BuildNetworkPolicies(netpols)
+	peers := universe.peers()
+
+	var disagreements []*Disagreement
+	for _, source := range peers {
+		for _, dest := range peers {
+			if source.Crd.IsExternal() && dest.Crd.IsExternal() {
+				continue
+			}
+			for _, port := range universe.Ports {
+				isV1Allowed := v1Policy.IsTrafficAllowed(&matcher.Traffic{
+					Source:       source.Matcher,
+					Destination:  dest.Matcher,
+					PortProtocol: port,
+				}).IsAllowed()
+				isCrdAllowed, decider := policies.Allows(&Traffic{
+					Source:      source.Crd,
+					Destination: dest.Crd,
+					Protocol:    port.Protocol,
+					Port:        port.Port,
+				})
+				if isV1Allowed != isCrdAllowed {
+					disagreements = append(disagreements, &Disagreement{
+						Source:      source.Name,
+						Destination: dest.Name,
+						Port:        port,
+						IsV1Allowed: isV1Allowed,
+						CrdPolicy:   decider,
+					})
+				}
+			}
+		}
+	}
+	return disagreements
+}
+
+// RoundTrip is the result of converting v1 policies to crd policies and back
+type RoundTrip struct {
+	Policies  *Policies
+	Reduction *Reduction
+	// Built are the disagreements between the original v1 policies and the crd policies built from them
+	Built []*Disagreement
+	// Reduced are the disagreements between the crd policies and the v1 policies reduced from them
+	Reduced []*Disagreement
+}
+
+// VerifyRoundTrip builds crd policies from v1 policies, reduces them back to v1
+// policies, and verifies each conversion over a universe
+func VerifyRoundTrip(netpols []*networkingv1.NetworkPolicy, universe *Universe) (*RoundTrip, error) {
+	policies := BuildPolicies(netpols)
+	reduction, err := ReduceAll(policies.Policies, universe.Inventory)
+	if err != nil {
+		return nil, err
+	}
+	return &RoundTrip{
+		Policies:  policies,
+		Reduction: reduction,
+		Built:     Verify(netpols, policies, universe),
+		Reduced:   Verify(reduction.NetworkPolicies, policies, universe),
+	}, nil
+}
@@ -0,0 +1,107 @@
+package crd
+
+import (
+	"github.com/mattfenwick/kube-prototypes/pkg/kube/netpol/examples"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/inventory"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/matcher"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// verifierUniverse has pods and namespaces with the labels used by examples.AllExamples
+func verifierUniverse() *Universe {
+	pod := func(ns string, name string, ip string, labels map[string]string) *inventory.Pod {
+		return &inventory.Pod{Namespace: ns, Name: name, IP: ip, Labels: labels,
+			ContainerPorts: []v1.ContainerPort{
+				{ContainerPort: 53, Protocol: v1.ProtocolUDP},
+				{ContainerPort: 80, Protocol: v1.ProtocolTCP},
+				{ContainerPort: 5000, Protocol: v1.ProtocolTCP},
+			}}
+	}
+	return &Universe{
+		Inventory: &inventory.Inventory{
+			Namespaces: []*inventory.Namespace{
+				{Name: "default"},
+				{Name: "prod", Labels: map[string]string{"purpose": "production"}},
+				{Name: "monitoring", Labels: map[string]string{"type": "monitoring"}},
+			},
+			Pods: []*inventory.Pod{
+				pod("default", "web", "10.0.0.1", map[string]string{"app": "web", "all": "web"}),
+				pod("default", "api", "10.0.0.2", map[string]string{"app": "bookstore", "role": "api"}),
+				pod("default", "search", "10.0.0.3", map[string]string{"app": "bookstore", "role": "search"}),
+				pod("default", "db", "10.0.0.4", map[string]string{"app": "bookstore", "role": "db"}),
+				pod("default", "inventory", "10.0.0.5", map[string]string{"app": "inventory", "role": "web"}),
+				pod("default", "apiserver", "10.0.0.6", map[string]string{"app": "apiserver"}),
+				pod("default", "foo", "10.0.0.7", map[string]string{"app": "foo", "a": "b"}),
+				pod("default", "alice", "10.0.0.8", map[string]string{"user": "alice", "role": "client"}),
+				pod("prod", "web", "10.0.1.1", map[string]string{"app": "web"}),
+				pod("monitoring", "prometheus", "10.0.2.1", map[string]string{"role": "monitoring", "team": "operations"}),
+			},
+		},
+		ExternalIPs: []string{"8.8.8.8"},
+		Ports: []*matcher.PortProtocol{
+			{Protocol: v1.ProtocolTCP, Port: intstr.FromInt(80)},
+			{Protocol: v1.ProtocolTCP, Port: intstr.FromInt(5000)},
+			{Protocol: v1.ProtocolUDP, Port: intstr.FromInt(53)},
+			{Protocol: v1.ProtocolTCP, Port: intstr.FromInt(53)},
+		},
+	}
+}
+
+func RunVerifierTests() {
+	Describe("Verify", func() {
+		for _, netpol := range examples.AllExamples {
+			np := netpol
+			It("agrees on example "+np.Name+", before and after a round trip", func() {
+				roundTrip, err := VerifyRoundTrip([]*networkingv1.NetworkPolicy{np}, verifierUniverse())
+				Expect(err).To(Succeed())
+
+				Expect(roundTrip.Reduction.IsSupported()).To(BeTrue())
+				Expect(roundTrip.Built).To(BeEmpty())
+				Expect(roundTrip.Reduced).To(BeEmpty())
+			})
+		}
+
+		It("defaults policy types which are left out", func() {
+			for _, example := range []*networkingv1.NetworkPolicy{examples.AllowNoIngress_NoPolicyTypes, examples.AllowAllIngress_NoPolicyTypes} {
+				netpol := example.DeepCopy()
+				netpol.Namespace = "default"
+				roundTrip, err := VerifyRoundTrip([]*networkingv1.NetworkPolicy{netpol}, verifierUniverse())
+				Expect(err).To(Succeed())
+
+				Expect(roundTrip.Policies.Policies).ToNot(BeEmpty())
+				Expect(roundTrip.Built).To(BeEmpty())
+				Expect(roundTrip.Reduced).To(BeEmpty())
+			}
+
+			var names []string
+			for _, policy := range BuildTarget(examples.AllowAllEgress_NoPolicyTypes) {
+				names = append(names, policy.Name)
+			}
+			Expect(names).To(Equal([]string{
+				"pathological-namespace-allow-all-egress-no-policy-types-ingress",
+				"pathological-namespace-allow-all-egress-no-policy-types-egress",
+				"pathological-namespace-allow-all-egress-no-policy-types-egress-0",
+			}))
+		})
+
+		It("keeps ports together with their protocols", func() {
+			netpol := examples.AllowSpecificPortTo("default", map[string]string{"role": "monitoring"}, map[string]string{"app": "apiserver"}, 5000)
+			udp := v1.ProtocolUDP
+			port53 := intstr.FromInt(53)
+			netpol.Spec.Ingress[0].Ports = append(netpol.Spec.Ingress[0].Ports, networkingv1.NetworkPolicyPort{Protocol: &udp, Port: &port53})
+			roundTrip, err := VerifyRoundTrip([]*networkingv1.NetworkPolicy{netpol}, verifierUniverse())
+			Expect(err).To(Succeed())
+
+			Expect(roundTrip.Built).To(BeEmpty())
+			Expect(roundTrip.Reduced).To(BeEmpty())
+		})
+
+		It("agrees on every example together, including traffic isolated in only one direction", func() {
+			Expect(Verify(examples.AllExamples, BuildPolicies(examples.AllExamples), verifierUniverse())).To(BeEmpty())
+		})
+	})
+}
@@ -1,6 +1,7 @@
 package eav
 
 import (
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol"
 	v1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -19,13 +20,13 @@ func BuildPolicies(netpols []*networkingv1.NetworkPolicy) *Policies {
 	return &Policies{Policies: policies}
 }
 
-func BuildTarget(netpol *networkingv1.NetworkPolicy) []*Policy {
+func BuildTarget(policy *networkingv1.NetworkPolicy) []*Policy {
 	var policies []*Policy
-	for _, pType := range netpol.Spec.PolicyTypes {
+	for _, pType := range netpol.PolicyTypes(policy) {
 		switch pType {
 		case networkingv1.PolicyTypeIngress:
-			targetMatcher := KubeMatchLabelSelector(NewKeyPathSelector(DestSelector, InternalSelector, PodLabelsSelector), netpol.Spec.PodSelector)
-			peerMatcher, directive := BuildTrafficPeersFromIngress(netpol.Namespace, netpol.Spec.Ingress)
+			targetMatcher := KubeMatchLabelSelector(NewKeyPathSelector(DestSelector, InternalSelector, PodLabelsSelector), policy.Spec.PodSelector)
+			peerMatcher, directive := BuildTrafficPeersFromIngress(policy.Namespace, policy.Spec.Ingress)
 			policies = append(policies, &Policy{
 				ObjectMeta: metav1.ObjectMeta{},
 				Spec: PolicySpec{
@@ -35,8 +36,8 @@ func BuildTarget(netpol *networkingv1.NetworkPolicy) []*Policy {
 				},
 			})
 		case networkingv1.PolicyTypeEgress:
-			targetMatcher := KubeMatchLabelSelector(NewKeyPathSelector(SourceSelector, InternalSelector, PodLabelsSelector), netpol.Spec.PodSelector)
-			peerMatcher, directive := BuildTrafficPeersFromEgress(netpol.Namespace, netpol.Spec.Egress)
+			targetMatcher := KubeMatchLabelSelector(NewKeyPathSelector(SourceSelector, InternalSelector, PodLabelsSelector), policy.Spec.PodSelector)
+			peerMatcher, directive := BuildTrafficPeersFromEgress(policy.Namespace, policy.Spec.Egress)
 			policies = append(policies, &Policy{
 				ObjectMeta: metav1.ObjectMeta{},
 				Spec: PolicySpec{
@@ -45,7 +45,7 @@ func ReduceAll(np []*Policy) []*networkingv1.NetworkPolicy {
 	for _, n := range np {
 		netpols = append(netpols, Reduce(n)...)
 	}
-	return append(netpols)
+	return netpols
 }
 
 func Reduce(np *Policy) []*networkingv1.NetworkPolicy {
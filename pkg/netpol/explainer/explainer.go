@@ -2,10 +2,12 @@ package explainer
 
 import (
 	"fmt"
+	"strings"
+
 	"github.com/mattfenwick/kube-prototypes/pkg/kube/netpol/examples"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol"
 	log "github.com/sirupsen/logrus"
 	networkingv1 "k8s.io/api/networking/v1"
-	"strings"
 )
 
 type ExplanationTarget struct {
@@ -135,7 +137,7 @@ func ExplainPolicy(policy *networkingv1.NetworkPolicy) *Explanation {
 	}
 
 	isIngress, isEgress := false, false
-	for _, pType := range policy.Spec.PolicyTypes {
+	for _, pType := range netpol.PolicyTypes(policy) {
 		switch pType {
 		case networkingv1.PolicyTypeIngress:
 			isIngress = true
@@ -156,16 +158,24 @@ func ExplainPolicy(policy *networkingv1.NetworkPolicy) *Explanation {
 	}
 }
 
+// ExplainPort describes a port of a rule, including the end of its range if it has one
+func ExplainPort(p networkingv1.NetworkPolicyPort) string {
+	protocol := "TCP"
+	if p.Protocol != nil {
+		protocol = string(*p.Protocol)
+	}
+	if p.Port != nil && p.EndPort != nil {
+		return fmt.Sprintf("%s - %s-%d", protocol, p.Port.String(), *p.EndPort)
+	}
+	return fmt.Sprintf("%s - %+v", protocol, p.Port)
+}
+
 func ExplainEgress(policy *networkingv1.NetworkPolicy) []*ExplanationEgress {
 	var egresses []*ExplanationEgress
 	for _, rule := range policy.Spec.Egress {
 		var ports []string
 		for _, p := range rule.Ports {
-			protocol := "TCP"
-			if p.Protocol != nil {
-				protocol = string(*p.Protocol)
-			}
-			ports = append(ports, fmt.Sprintf("%s - %+v", protocol, p.Port))
+			ports = append(ports, ExplainPort(p))
 		}
 
 		var rules []*ExplanationRule
@@ -205,11 +215,7 @@ func ExplainIngress(policy *networkingv1.NetworkPolicy) []*ExplanationIngress {
 	for _, rule := range policy.Spec.Ingress {
 		var ports []string
 		for _, p := range rule.Ports {
-			protocol := "TCP"
-			if p.Protocol != nil {
-				protocol = string(*p.Protocol)
-			}
-			ports = append(ports, fmt.Sprintf("%s - %+v", protocol, p.Port))
+			ports = append(ports, ExplainPort(p))
 		}
 
 		var rules []*ExplanationRule
@@ -0,0 +1,37 @@
+package explainer
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func RunExplainerTests() {
+	Describe("ExplainPort", func() {
+		udp := v1.ProtocolUDP
+		port := intstr.FromInt(8000)
+		endPort := int32(9000)
+
+		It("explains a single port", func() {
+			Expect(ExplainPort(networkingv1.NetworkPolicyPort{Protocol: &udp, Port: &port})).To(Equal("UDP - 8000"))
+		})
+
+		It("explains a port range", func() {
+			Expect(ExplainPort(networkingv1.NetworkPolicyPort{Port: &port, EndPort: &endPort})).To(Equal("TCP - 8000-9000"))
+		})
+
+		It("explains the port ranges of egress rules", func() {
+			policy := &networkingv1.NetworkPolicy{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "ephemeral"},
+				Spec: networkingv1.NetworkPolicySpec{
+					Egress:      []networkingv1.NetworkPolicyEgressRule{{Ports: []networkingv1.NetworkPolicyPort{{Port: &port, EndPort: &endPort}}}},
+					PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+				},
+			}
+			Expect(ExplainEgress(policy)[0].Ports).To(Equal([]string{"TCP - 8000-9000"}))
+		})
+	})
+}
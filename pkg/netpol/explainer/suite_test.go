@@ -0,0 +1,14 @@
+package explainer
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestModel(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunExplainerTests()
+	RunSpecs(t, "network policy explainer suite")
+}
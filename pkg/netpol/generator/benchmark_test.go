@@ -0,0 +1,54 @@
+package generator
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/matcher"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+var benchmarkSizes = []struct {
+	Namespaces       int
+	PodsPerNamespace int
+}{
+	{10, 10},
+	{100, 10},
+	{1000, 5},
+}
+
+func BenchmarkBuildNetworkPolicies(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		g := NewGenerator(DefaultConfig(size.Namespaces, size.PodsPerNamespace))
+		netpols := g.NetworkPolicies(g.Inventory())
+		b.Run(fmt.Sprintf("%d policies", len(netpols)), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				matcher.BuildNetworkPolicies(netpols)
+			}
+		})
+	}
+}
+
+// BenchmarkIsTrafficAllowed evaluates traffic between every pair of pods
+func BenchmarkIsTrafficAllowed(b *testing.B) {
+	port := &matcher.PortProtocol{Protocol: v1.ProtocolTCP, Port: intstr.FromInt(80)}
+	for _, size := range benchmarkSizes[:2] {
+		g := NewGenerator(DefaultConfig(size.Namespaces, size.PodsPerNamespace))
+		inv := g.Inventory()
+		policy := matcher.BuildNetworkPolicies(g.NetworkPolicies(inv))
+		var peers []*matcher.TrafficPeer
+		for _, pod := range inv.Pods {
+			peers = append(peers, matcher.InventoryPeer(inv, pod))
+		}
+		b.Run(fmt.Sprintf("%dx%d pods", len(peers), len(peers)), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, source := range peers {
+					for _, dest := range peers {
+						policy.IsTrafficAllowed(&matcher.Traffic{Source: source, Destination: dest, PortProtocol: port})
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package generator
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/inventory"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// LabelDistribution is how a label key's values are spread over objects
+type LabelDistribution struct {
+	Key string
+	// Values is the number of distinct values, named value-0, value-1, ...
+	Values int
+	// Skew makes lower values more common, following a Zipf distribution with
+	// this exponent.  It has to be greater than 1 to take effect; otherwise
+	// values are uniformly distributed.
+	Skew float64
+}
+
+// Config describes a synthetic cluster and its policies
+type Config struct {
+	// Seed makes generation reproducible: the same config always generates the same cluster
+	Seed             int64
+	Namespaces       int
+	PodsPerNamespace int
+	Nodes            int
+	NamespaceLabels  []*LabelDistribution
+	PodLabels        []*LabelDistribution
+	// ContainerPorts are the TCP ports every pod listens on
+	ContainerPorts       []int
+	PoliciesPerNamespace int
+	RulesPerPolicy       int
+	PeersPerRule         int
+}
+
+// DefaultConfig is a cluster of the given size, with a few label keys which
+// selectors can pick from
+func DefaultConfig(namespaces int, podsPerNamespace int) *Config {
+	return &Config{
+		Seed:             1,
+		Namespaces:       namespaces,
+		PodsPerNamespace: podsPerNamespace,
+		Nodes:            10,
+		NamespaceLabels: []*LabelDistribution{
+			{Key: "team", Values: 10, Skew: 1.5},
+			{Key: "env", Values: 3},
+		},
+		PodLabels: []*LabelDistribution{
+			{Key: "app", Values: 20, Skew: 1.2},
+			{Key: "tier", Values: 3},
+			{Key: "version", Values: 5, Skew: 2},
+		},
+		ContainerPorts:       []int{80, 443, 8080},
+		PoliciesPerNamespace: 2,
+		RulesPerPolicy:       2,
+		PeersPerRule:         2,
+	}
+}
+
+// Generator builds synthetic inventories and policies from a Config
+type Generator struct {
+	Config *Config
+	rand   *rand.Rand
+	zipfs  map[*LabelDistribution]*rand.Zipf
+}
+
+func NewGenerator(config *Config) *Generator {
+	return &Generator{
+		Config: config,
+		rand:   rand.New(rand.NewSource(config.Seed)),
+		zipfs:  map[*LabelDistribution]*rand.Zipf{},
+	}
+}
+
+func labelValue(i int) string {
+	return fmt.Sprintf("value-%d", i)
+}
+
+func (g *Generator) pickValue(dist *LabelDistribution) string {
+	if dist.Skew > 1 && dist.Values > 1 {
+		zipf, ok := g.zipfs[dist]
+		if !ok {
+			zipf = rand.NewZipf(g.rand, dist.Skew, 1, uint64(dist.Values-1))
+			g.zipfs[dist] = zipf
+		}
+		return labelValue(int(zipf.Uint64()))
+	}
+	return labelValue(g.rand.Intn(dist.Values))
+}
+
+func (g *Generator) labels(dists []*LabelDistribution) map[string]string {
+	labels := map[string]string{}
+	for _, dist := range dists {
+		labels[dist.Key] = g.pickValue(dist)
+	}
+	return labels
+}
+
+// Inventory generates namespaces, nodes, and pods spread evenly over the nodes.
+// Pods get consecutive IPs starting from 10.0.0.1; nodes get IPs starting from 192.168.0.1.
+func (g *Generator) Inventory() *inventory.Inventory {
+	inv := &inventory.Inventory{}
+	for i := 0; i < g.Config.Nodes; i++ {
+		inv.Nodes = append(inv.Nodes, &inventory.Node{
+			Name: fmt.Sprintf("node-%d", i),
+			IPs:  []string{indexIP(192, 168, i+1)},
+		})
+	}
+	var ports []v1.ContainerPort
+	for _, port := range g.Config.ContainerPorts {
+		ports = append(ports, v1.ContainerPort{ContainerPort: int32(port), Protocol: v1.ProtocolTCP})
+	}
+	podIndex := 0
+	for i := 0; i < g.Config.Namespaces; i++ {
+		ns := fmt.Sprintf("ns-%d", i)
+		inv.Namespaces = append(inv.Namespaces, &inventory.Namespace{Name: ns, Labels: g.labels(g.Config.NamespaceLabels)})
+		for j := 0; j < g.Config.PodsPerNamespace; j++ {
+			pod := &inventory.Pod{
+				Namespace:      ns,
+				Name:           fmt.Sprintf("pod-%d", j),
+				Labels:         g.labels(g.Config.PodLabels),
+				IP:             indexIP(10, 0, podIndex+1),
+				ContainerPorts: ports,
+			}
+			if len(inv.Nodes) > 0 {
+				pod.Node = inv.Nodes[podIndex%len(inv.Nodes)].Name
+			}
+			inv.Pods = append(inv.Pods, pod)
+			podIndex++
+		}
+	}
+	return inv
+}
+
+// indexIP is the i'th IPv4 address after a.b.0.0
+func indexIP(a int, b int, i int) string {
+	return fmt.Sprintf("%d.%d.%d.%d", a, b+i/65536, (i/256)%256, i%256)
+}
+
+// NetworkPolicies generates random policies for the namespaces of an inventory.
+// Selectors use the label keys and values of the config, so that they match
+// some of the generated objects.
+func (g *Generator) NetworkPolicies(inv *inventory.Inventory) []*networkingv1.NetworkPolicy {
+	var netpols []*networkingv1.NetworkPolicy
+	for _, ns := range inv.Namespaces {
+		for i := 0; i < g.Config.PoliciesPerNamespace; i++ {
+			netpols = append(netpols, g.networkPolicy(ns.Name, fmt.Sprintf("policy-%d", i)))
+		}
+	}
+	return netpols
+}
+
+func (g *Generator) networkPolicy(namespace string, name string) *networkingv1.NetworkPolicy {
+	netpol := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       networkingv1.NetworkPolicySpec{PodSelector: g.selector(g.Config.PodLabels)},
+	}
+	isIngress, isEgress := true, true
+	switch g.rand.Intn(3) {
+	case 0:
+		isEgress = false
+	case 1:
+		isIngress = false
+	}
+	if isIngress {
+		netpol.Spec.PolicyTypes = append(netpol.Spec.PolicyTypes, networkingv1.PolicyTypeIngress)
+		for i := 0; i < g.Config.RulesPerPolicy; i++ {
+			netpol.Spec.Ingress = append(netpol.Spec.Ingress, networkingv1.NetworkPolicyIngressRule{Ports: g.ports(), From: g.peers()})
+		}
+	}
+	if isEgress {
+		netpol.Spec.PolicyTypes = append(netpol.Spec.PolicyTypes, networkingv1.PolicyTypeEgress)
+		for i := 0; i < g.Config.RulesPerPolicy; i++ {
+			netpol.Spec.Egress = append(netpol.Spec.Egress, networkingv1.NetworkPolicyEgressRule{Ports: g.ports(), To: g.peers()})
+		}
+	}
+	return netpol
+}
+
+// selector picks one of: an empty selector, a single label, or a set of values of a single label
+func (g *Generator) selector(dists []*LabelDistribution) metav1.LabelSelector {
+	if len(dists) == 0 {
+		return metav1.LabelSelector{}
+	}
+	dist := dists[g.rand.Intn(len(dists))]
+	switch g.rand.Intn(4) {
+	case 0:
+		return metav1.LabelSelector{}
+	case 1:
+		values := []string{g.pickValue(dist), g.pickValue(dist)}
+		if values[0] == values[1] {
+			values = values[:1]
+		}
+		return metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{{Key: dist.Key, Operator: metav1.LabelSelectorOpIn, Values: values}},
+		}
+	default:
+		return metav1.LabelSelector{MatchLabels: map[string]string{dist.Key: g.pickValue(dist)}}
+	}
+}
+
+func (g *Generator) peers() []networkingv1.NetworkPolicyPeer {
+	var peers []networkingv1.NetworkPolicyPeer
+	for i := 0; i < g.Config.PeersPerRule; i++ {
+		podSelector := g.selector(g.Config.PodLabels)
+		namespaceSelector := g.selector(g.Config.NamespaceLabels)
+		switch g.rand.Intn(4) {
+		case 0:
+			peers = append(peers, networkingv1.NetworkPolicyPeer{PodSelector: &podSelector})
+		case 1:
+			peers = append(peers, networkingv1.NetworkPolicyPeer{NamespaceSelector: &namespaceSelector})
+		case 2:
+			peers = append(peers, networkingv1.NetworkPolicyPeer{PodSelector: &podSelector, NamespaceSelector: &namespaceSelector})
+		default:
+			octet := g.rand.Intn(256)
+			peers = append(peers, networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{
+				CIDR:   "10.0.0.0/16",
+				Except: []string{fmt.Sprintf("10.0.%d.0/24", octet)},
+			}})
+		}
+	}
+	return peers
+}
+
+// ports picks either no ports, meaning all of them, or one of the config's container ports
+func (g *Generator) ports() []networkingv1.NetworkPolicyPort {
+	if len(g.Config.ContainerPorts) == 0 || g.rand.Intn(2) == 0 {
+		return nil
+	}
+	protocol := v1.ProtocolTCP
+	port := intstr.FromInt(g.Config.ContainerPorts[g.rand.Intn(len(g.Config.ContainerPorts))])
+	return []networkingv1.NetworkPolicyPort{{Protocol: &protocol, Port: &port}}
+}
@@ -0,0 +1,53 @@
+package generator
+
+import (
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/validation"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func RunGeneratorTests() {
+	Describe("Generator", func() {
+		It("generates a valid inventory of the configured size", func() {
+			inv := NewGenerator(DefaultConfig(20, 50)).Inventory()
+
+			Expect(inv.Validate()).To(Succeed())
+			Expect(inv.Namespaces).To(HaveLen(20))
+			Expect(inv.Pods).To(HaveLen(1000))
+			Expect(inv.Nodes).To(HaveLen(10))
+			Expect(inv.Pods[999].IP).To(Equal("10.0.3.232"))
+			for _, pod := range inv.Pods {
+				Expect(pod.Labels).To(HaveKey("app"))
+				Expect(pod.Labels).To(HaveKey("tier"))
+			}
+		})
+
+		It("generates valid policies", func() {
+			g := NewGenerator(DefaultConfig(20, 5))
+			netpols := g.NetworkPolicies(g.Inventory())
+
+			Expect(netpols).To(HaveLen(40))
+			Expect(validation.ValidateNetworkPolicies(netpols)).To(Succeed())
+		})
+
+		It("generates the same cluster from the same seed", func() {
+			a, b := NewGenerator(DefaultConfig(5, 5)), NewGenerator(DefaultConfig(5, 5))
+			invA, invB := a.Inventory(), b.Inventory()
+
+			Expect(invA).To(Equal(invB))
+			Expect(a.NetworkPolicies(invA)).To(Equal(b.NetworkPolicies(invB)))
+		})
+
+		It("skews label values towards the lower ones", func() {
+			config := DefaultConfig(1, 1000)
+			config.PodLabels = []*LabelDistribution{{Key: "app", Values: 10, Skew: 2}}
+			counts := map[string]int{}
+			for _, pod := range NewGenerator(config).Inventory().Pods {
+				counts[pod.Labels["app"]]++
+			}
+
+			Expect(counts["value-0"]).To(BeNumerically(">", counts["value-1"]))
+			Expect(counts["value-1"]).To(BeNumerically(">", counts["value-9"]))
+		})
+	})
+}
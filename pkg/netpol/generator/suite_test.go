@@ -0,0 +1,14 @@
+package generator
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestModel(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunGeneratorTests()
+	RunSpecs(t, "network policy generator suite")
+}
@@ -0,0 +1,293 @@
+package inventory
+
+import (
+	"io/ioutil"
+
+	"github.com/mattfenwick/kube-prototypes/pkg/kube"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/yaml"
+)
+
+// Inventory is an offline description of the namespaces and pods in a cluster:
+// just enough information to evaluate network policies without talking to
+// a live cluster.
+type Inventory struct {
+	Namespaces []*Namespace `json:"namespaces"`
+	Pods       []*Pod       `json:"pods"`
+	Nodes      []*Node      `json:"nodes,omitempty"`
+	Services   []*Service   `json:"services,omitempty"`
+	Workloads  []*Workload  `json:"workloads,omitempty"`
+}
+
+type Namespace struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type Pod struct {
+	Namespace string            `json:"namespace"`
+	Name      string            `json:"name"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	IP        string            `json:"ip,omitempty"`
+	// IPs are every IP of a dual-stack pod, one per family.  If they're
+	// missing, the pod's only IP is IP.
+	IPs []string `json:"ips,omitempty"`
+	// Node is the name of the node the pod is scheduled to, if known
+	Node string `json:"node,omitempty"`
+	// HostNetwork pods use the network of their node, and have its IPs
+	HostNetwork    bool               `json:"hostNetwork,omitempty"`
+	ContainerPorts []v1.ContainerPort `json:"containerPorts,omitempty"`
+	// LivenessProbePorts are the ports the kubelet checks the liveness of containers on
+	LivenessProbePorts []intstr.IntOrString `json:"livenessProbePorts,omitempty"`
+}
+
+func (p *Pod) Key() netpol.Pod {
+	return netpol.NewPod(p.Namespace, p.Name)
+}
+
+// AllIPs returns IPs, or if there aren't any, IP
+func (p *Pod) AllIPs() []string {
+	if len(p.IPs) > 0 {
+		return p.IPs
+	}
+	if p.IP != "" {
+		return []string{p.IP}
+	}
+	return nil
+}
+
+type Node struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// IPs are the internal IPs of the node, one per family
+	IPs []string `json:"ips,omitempty"`
+}
+
+// Service is just the part of a kube Service needed to find the pods behind it
+type Service struct {
+	Namespace string            `json:"namespace"`
+	Name      string            `json:"name"`
+	Selector  map[string]string `json:"selector,omitempty"`
+}
+
+type WorkloadKind string
+
+const (
+	WorkloadKindDeployment  WorkloadKind = "Deployment"
+	WorkloadKindStatefulSet WorkloadKind = "StatefulSet"
+	WorkloadKindDaemonSet   WorkloadKind = "DaemonSet"
+)
+
+// Workload is just the part of a pod controller needed to find the pods it owns
+type Workload struct {
+	Kind      WorkloadKind          `json:"kind"`
+	Namespace string                `json:"namespace"`
+	Name      string                `json:"name"`
+	Selector  *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
+// ReadInventoryFile reads an Inventory from a yaml or json file
+func ReadInventoryFile(path string) (*Inventory, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read inventory file %s", path)
+	}
+	inv := &Inventory{}
+	err = yaml.UnmarshalStrict(bytes, inv)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to unmarshal inventory file %s", path)
+	}
+	return inv, inv.Validate()
+}
+
+// Validate checks that names are unique, that the namespace of every pod, service
+// and workload is present, and that pods have valid IPs, with at most one per family.
+// If the inventory has nodes, the node of every pod has to be present too.
+func (inv *Inventory) Validate() error {
+	namespaces := map[string]bool{}
+	for _, ns := range inv.Namespaces {
+		if namespaces[ns.Name] {
+			return errors.Errorf("duplicate namespace %s", ns.Name)
+		}
+		namespaces[ns.Name] = true
+	}
+	nodes := map[string]bool{}
+	for _, node := range inv.Nodes {
+		if nodes[node.Name] {
+			return errors.Errorf("duplicate node %s", node.Name)
+		}
+		nodes[node.Name] = true
+		for _, ip := range node.IPs {
+			if kube.IPFamilyOf(ip) == "" {
+				return errors.Errorf("invalid ip %s of node %s", ip, node.Name)
+			}
+		}
+	}
+	pods := map[netpol.Pod]bool{}
+	for _, pod := range inv.Pods {
+		if !namespaces[pod.Namespace] {
+			return errors.Errorf("namespace %s of pod %s not found", pod.Namespace, pod.Name)
+		}
+		if len(inv.Nodes) > 0 && pod.Node != "" && !nodes[pod.Node] {
+			return errors.Errorf("node %s of pod %s not found", pod.Node, pod.Key())
+		}
+		if pods[pod.Key()] {
+			return errors.Errorf("duplicate pod %s", pod.Key())
+		}
+		pods[pod.Key()] = true
+		if err := validatePodIPs(pod); err != nil {
+			return err
+		}
+	}
+	services := map[string]bool{}
+	for _, svc := range inv.Services {
+		key := svc.Namespace + "/" + svc.Name
+		if !namespaces[svc.Namespace] {
+			return errors.Errorf("namespace %s of service %s not found", svc.Namespace, svc.Name)
+		}
+		if services[key] {
+			return errors.Errorf("duplicate service %s", key)
+		}
+		services[key] = true
+	}
+	workloads := map[string]bool{}
+	for _, workload := range inv.Workloads {
+		key := string(workload.Kind) + " " + workload.Namespace + "/" + workload.Name
+		switch workload.Kind {
+		case WorkloadKindDeployment, WorkloadKindStatefulSet, WorkloadKindDaemonSet:
+		default:
+			return errors.Errorf("invalid kind of workload %s", key)
+		}
+		if !namespaces[workload.Namespace] {
+			return errors.Errorf("namespace %s of workload %s not found", workload.Namespace, key)
+		}
+		if workloads[key] {
+			return errors.Errorf("duplicate workload %s", key)
+		}
+		workloads[key] = true
+	}
+	return nil
+}
+
+func validatePodIPs(pod *Pod) error {
+	families := map[v1.IPFamily]bool{}
+	for _, ip := range pod.AllIPs() {
+		family := kube.IPFamilyOf(ip)
+		if family == "" {
+			return errors.Errorf("invalid ip %s of pod %s", ip, pod.Key())
+		}
+		if families[family] {
+			return errors.Errorf("more than one %s ip of pod %s", family, pod.Key())
+		}
+		families[family] = true
+	}
+	if pod.IP != "" && len(pod.IPs) > 0 && pod.IP != pod.IPs[0] {
+		return errors.Errorf("ip %s of pod %s isn't the first of its ips", pod.IP, pod.Key())
+	}
+	return nil
+}
+
+func (inv *Inventory) Namespace(name string) *Namespace {
+	for _, ns := range inv.Namespaces {
+		if ns.Name == name {
+			return ns
+		}
+	}
+	return nil
+}
+
+func (inv *Inventory) Node(name string) *Node {
+	for _, node := range inv.Nodes {
+		if node.Name == name {
+			return node
+		}
+	}
+	return nil
+}
+
+func (inv *Inventory) Service(namespace string, name string) *Service {
+	for _, svc := range inv.Services {
+		if svc.Namespace == namespace && svc.Name == name {
+			return svc
+		}
+	}
+	return nil
+}
+
+func (inv *Inventory) Workload(kind WorkloadKind, namespace string, name string) *Workload {
+	for _, workload := range inv.Workloads {
+		if workload.Kind == kind && workload.Namespace == namespace && workload.Name == name {
+			return workload
+		}
+	}
+	return nil
+}
+
+// NamespaceLabels returns a namespace's labels, including the
+// kubernetes.io/metadata.name label which the apiserver adds automatically
+func (inv *Inventory) NamespaceLabels(name string) map[string]string {
+	labels := map[string]string{}
+	if ns := inv.Namespace(name); ns != nil {
+		for k, v := range ns.Labels {
+			labels[k] = v
+		}
+	}
+	labels[v1.LabelMetadataName] = name
+	return labels
+}
+
+// NodeLabels returns a node's labels, or nil if the node isn't known
+func (inv *Inventory) NodeLabels(name string) map[string]string {
+	if node := inv.Node(name); node != nil {
+		return node.Labels
+	}
+	return nil
+}
+
+// IPFamilies finds the families of pod IPs, in order of first appearance
+func (inv *Inventory) IPFamilies() []v1.IPFamily {
+	var ips []string
+	for _, pod := range inv.Pods {
+		for _, family := range kube.IPFamilies(pod.AllIPs()) {
+			ips = append(ips, kube.IPOfFamily(pod.AllIPs(), family))
+		}
+	}
+	return kube.IPFamilies(ips)
+}
+
+// ForIPFamily narrows an inventory down to the pods with an IP of a family, and
+// makes that their IP, for looking at traffic of a single family
+func (inv *Inventory) ForIPFamily(family v1.IPFamily) *Inventory {
+	narrowed := &Inventory{Namespaces: inv.Namespaces, Nodes: inv.Nodes, Services: inv.Services, Workloads: inv.Workloads}
+	for _, pod := range inv.Pods {
+		if ip := kube.IPOfFamily(pod.AllIPs(), family); ip != "" {
+			narrowedPod := *pod
+			narrowedPod.IP = ip
+			narrowed.Pods = append(narrowed.Pods, &narrowedPod)
+		}
+	}
+	return narrowed
+}
+
+// PodsWithoutIPFamily finds the pods which ForIPFamily leaves out, for not having an IP of a family
+func (inv *Inventory) PodsWithoutIPFamily(family v1.IPFamily) []netpol.Pod {
+	var pods []netpol.Pod
+	for _, pod := range inv.Pods {
+		if kube.IPOfFamily(pod.AllIPs(), family) == "" {
+			pods = append(pods, pod.Key())
+		}
+	}
+	return pods
+}
+
+func (inv *Inventory) PodKeys() []netpol.Pod {
+	var keys []netpol.Pod
+	for _, pod := range inv.Pods {
+		keys = append(keys, pod.Key())
+	}
+	return keys
+}
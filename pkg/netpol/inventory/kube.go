@@ -0,0 +1,71 @@
+package inventory
+
+import (
+	"github.com/mattfenwick/kube-prototypes/pkg/kube"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+// FromKube builds an Inventory out of kube Namespaces and Pods
+func FromKube(namespaces []v1.Namespace, pods []v1.Pod) *Inventory {
+	inv := &Inventory{}
+	for _, ns := range namespaces {
+		inv.Namespaces = append(inv.Namespaces, &Namespace{
+			Name:   ns.Name,
+			Labels: ns.Labels,
+		})
+	}
+	for _, pod := range pods {
+		var ports []v1.ContainerPort
+		for _, cont := range pod.Spec.Containers {
+			ports = append(ports, cont.Ports...)
+		}
+		inv.Pods = append(inv.Pods, &Pod{
+			Namespace:          pod.Namespace,
+			Name:               pod.Name,
+			Labels:             pod.Labels,
+			IP:                 pod.Status.PodIP,
+			IPs:                kube.PodIPs(&pod),
+			Node:               pod.Spec.NodeName,
+			HostNetwork:        pod.Spec.HostNetwork,
+			ContainerPorts:     ports,
+			LivenessProbePorts: kube.LivenessProbePorts(&pod),
+		})
+	}
+	return inv
+}
+
+// AddNodesFromKube adds kube Nodes to an Inventory
+func (inv *Inventory) AddNodesFromKube(nodes []v1.Node) {
+	for _, node := range nodes {
+		inv.Nodes = append(inv.Nodes, &Node{
+			Name:   node.Name,
+			Labels: node.Labels,
+			IPs:    kube.NodeIPs(&node),
+		})
+	}
+}
+
+// AddServicesFromKube adds kube Services to an Inventory
+func (inv *Inventory) AddServicesFromKube(services []v1.Service) {
+	for _, svc := range services {
+		inv.Services = append(inv.Services, &Service{
+			Namespace: svc.Namespace,
+			Name:      svc.Name,
+			Selector:  svc.Spec.Selector,
+		})
+	}
+}
+
+// AddWorkloadsFromKube adds kube pod controllers to an Inventory
+func (inv *Inventory) AddWorkloadsFromKube(deployments []appsv1.Deployment, statefulSets []appsv1.StatefulSet, daemonSets []appsv1.DaemonSet) {
+	for _, d := range deployments {
+		inv.Workloads = append(inv.Workloads, &Workload{Kind: WorkloadKindDeployment, Namespace: d.Namespace, Name: d.Name, Selector: d.Spec.Selector})
+	}
+	for _, s := range statefulSets {
+		inv.Workloads = append(inv.Workloads, &Workload{Kind: WorkloadKindStatefulSet, Namespace: s.Namespace, Name: s.Name, Selector: s.Spec.Selector})
+	}
+	for _, d := range daemonSets {
+		inv.Workloads = append(inv.Workloads, &Workload{Kind: WorkloadKindDaemonSet, Namespace: d.Namespace, Name: d.Name, Selector: d.Spec.Selector})
+	}
+}
@@ -0,0 +1,140 @@
+package linter
+
+import (
+	"fmt"
+
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/inventory"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+type Level string
+
+const (
+	LevelError   Level = "error"
+	LevelWarning Level = "warning"
+	LevelNote    Level = "note"
+)
+
+// Rule is a check for a common NetworkPolicy mistake
+type Rule struct {
+	ID          string `json:"id"`
+	Level       Level  `json:"level"`
+	Description string `json:"description"`
+	// check looks at one policy at a time
+	check func(policy *networkingv1.NetworkPolicy, config *Config) []*Finding
+	// checkPolicies is for rules which compare policies with each other, and
+	// fill in the policy of their findings themselves
+	checkPolicies func(policies []*networkingv1.NetworkPolicy, config *Config) []*Finding
+}
+
+// Finding is a place in a policy where a rule found a likely mistake
+type Finding struct {
+	RuleID string `json:"ruleId"`
+	Level  Level  `json:"level"`
+	// Policy is the namespace and name of the policy
+	Policy string `json:"policy"`
+	// Path is the field of the policy the finding is about, such as spec.ingress[0].from
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (f *Finding) String() string {
+	return fmt.Sprintf("%s %s %s %s: %s", f.Level, f.RuleID, f.Policy, f.Path, f.Message)
+}
+
+// Config is what the rules may look at besides the policies.  Rules which
+// need something that isn't there are skipped.
+type Config struct {
+	// Inventory has the namespaces and pods of the cluster, along with their labels, IPs and container ports
+	Inventory *inventory.Inventory
+	// PodCIDRs are the ranges pod IPs are allocated from
+	PodCIDRs []string
+}
+
+var Rules = []*Rule{
+	{
+		ID:          "peers-or-instead-of-and",
+		Level:       LevelWarning,
+		Description: "a rule has one peer with only a namespaceSelector and another with only a podSelector, which allows traffic matching either; to require both, they have to be in the same peer",
+		check:       checkPeersOrInsteadOfAnd,
+	},
+	{
+		ID:          "policy-types-missing-direction",
+		Level:       LevelError,
+		Description: "a policy has ingress or egress rules, but its policyTypes don't include that direction, so the rules are ignored",
+		check:       checkPolicyTypesMissingDirection,
+	},
+	{
+		ID:          "named-port-not-exposed",
+		Level:       LevelWarning,
+		Description: "a named port isn't the name of any container port of the pods it could apply to, so it never matches",
+		check:       checkNamedPortNotExposed,
+	},
+	{
+		ID:          "ip-block-overlaps-pods",
+		Level:       LevelWarning,
+		Description: "an ipBlock overlaps pod IPs; whether ipBlocks apply to pod traffic depends on the network plugin",
+		check:       checkIPBlockOverlapsPods,
+	},
+	{
+		ID:          "not-in-missing-key",
+		Level:       LevelNote,
+		Description: "a NotIn selector requirement also selects objects which don't have the label at all",
+		check:       checkNotInMissingKey,
+	},
+	{
+		ID:            "redundant-rule",
+		Level:         LevelWarning,
+		Description:   "a rule only allows traffic which another rule of the same policy already allows, for the same pods",
+		checkPolicies: checkRedundantRules(true),
+	},
+	{
+		ID:            "shadowed-rule",
+		Level:         LevelNote,
+		Description:   "a rule only allows traffic which a rule of another policy already allows, for the same pods",
+		checkPolicies: checkRedundantRules(false),
+	},
+	{
+		ID:          "dead-rule",
+		Level:       LevelWarning,
+		Description: "a peer can only match pods, but doesn't match any pod of the inventory",
+		check:       checkDeadRule,
+	},
+	{
+		ID:          "empty-target",
+		Level:       LevelWarning,
+		Description: "a policy's pod selector doesn't match any pod of the inventory, so the policy doesn't apply to anything",
+		check:       checkEmptyTarget,
+	},
+}
+
+// Lint checks policies against every rule
+func Lint(policies []*networkingv1.NetworkPolicy, config *Config) []*Finding {
+	var findings []*Finding
+	for _, policy := range policies {
+		for _, rule := range Rules {
+			if rule.check == nil {
+				continue
+			}
+			for _, finding := range rule.check(policy, config) {
+				finding.Policy = fmt.Sprintf("%s/%s", policy.Namespace, policy.Name)
+				findings = append(findings, rule.fillIn(finding))
+			}
+		}
+	}
+	for _, rule := range Rules {
+		if rule.checkPolicies == nil {
+			continue
+		}
+		for _, finding := range rule.checkPolicies(policies, config) {
+			findings = append(findings, rule.fillIn(finding))
+		}
+	}
+	return findings
+}
+
+func (r *Rule) fillIn(finding *Finding) *Finding {
+	finding.RuleID = r.ID
+	finding.Level = r.Level
+	return finding
+}
@@ -0,0 +1,156 @@
+package linter
+
+import (
+	"encoding/json"
+
+	"github.com/mattfenwick/kube-prototypes/pkg/kube/netpol/examples"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/inventory"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+var linterInventory = &inventory.Inventory{
+	Namespaces: []*inventory.Namespace{
+		{Name: "default", Labels: map[string]string{"team": "web"}},
+		{Name: "kube-system"},
+	},
+	Pods: []*inventory.Pod{
+		{Namespace: "default", Name: "web", Labels: map[string]string{"app": "web", "tier": "frontend"}, IP: "10.0.0.1",
+			ContainerPorts: []v1.ContainerPort{{Name: "http", ContainerPort: 80, Protocol: v1.ProtocolTCP}}},
+		{Namespace: "default", Name: "db", Labels: map[string]string{"app": "db"}, IP: "10.0.0.2"},
+	},
+}
+
+func linterPolicy(spec networkingv1.NetworkPolicySpec) *networkingv1.NetworkPolicy {
+	return &networkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "policy"}, Spec: spec}
+}
+
+func linterRuleIDs(findings []*Finding) []string {
+	var ids []string
+	for _, finding := range findings {
+		ids = append(ids, finding.RuleID)
+	}
+	return ids
+}
+
+func RunLinterTests() {
+	Describe("Lint", func() {
+		It("warns about peers allowing either a namespace or a pod", func() {
+			accidentalOr := examples.AccidentalOr("default", map[string]string{"app": "web"}, map[string]string{"user": "alice"}, map[string]string{"role": "client"})
+			findings := Lint([]*networkingv1.NetworkPolicy{accidentalOr}, &Config{})
+
+			Expect(linterRuleIDs(findings)).To(Equal([]string{"peers-or-instead-of-and"}))
+			Expect(findings[0].Policy).To(Equal("default/accidental-or"))
+			Expect(findings[0].Path).To(Equal("spec.ingress[0].from"))
+			Expect(findings[0].Level).To(Equal(LevelWarning))
+
+			accidentalAnd := examples.AccidentalAnd("default", map[string]string{"app": "web"}, map[string]string{"user": "alice"}, map[string]string{"role": "client"})
+			Expect(Lint([]*networkingv1.NetworkPolicy{accidentalAnd}, &Config{})).To(BeEmpty())
+		})
+
+		It("finds egress rules ignored because of policyTypes", func() {
+			policy := linterPolicy(networkingv1.NetworkPolicySpec{
+				Egress:      []networkingv1.NetworkPolicyEgressRule{{}},
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			})
+			findings := Lint([]*networkingv1.NetworkPolicy{policy}, &Config{})
+
+			Expect(linterRuleIDs(findings)).To(Equal([]string{"policy-types-missing-direction"}))
+			Expect(findings[0].Path).To(Equal("spec.policyTypes"))
+
+			policy.Spec.PolicyTypes = nil
+			Expect(Lint([]*networkingv1.NetworkPolicy{policy}, &Config{})).To(BeEmpty())
+		})
+
+		It("finds named ports no selected pod exposes", func() {
+			http, metrics := intstr.FromString("http"), intstr.FromString("metrics")
+			policy := linterPolicy(networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{
+					Ports: []networkingv1.NetworkPolicyPort{{Port: &http}, {Port: &metrics}},
+				}},
+			})
+
+			Expect(Lint([]*networkingv1.NetworkPolicy{policy}, &Config{})).To(BeEmpty())
+
+			findings := Lint([]*networkingv1.NetworkPolicy{policy}, &Config{Inventory: linterInventory})
+			Expect(linterRuleIDs(findings)).To(Equal([]string{"named-port-not-exposed"}))
+			Expect(findings[0].Path).To(Equal("spec.ingress[0].ports[1]"))
+		})
+
+		It("finds ipBlocks overlapping pod CIDRs and pod IPs", func() {
+			policy := linterPolicy(networkingv1.NetworkPolicySpec{
+				Egress: []networkingv1.NetworkPolicyEgressRule{{
+					To: []networkingv1.NetworkPolicyPeer{
+						{IPBlock: &networkingv1.IPBlock{CIDR: "0.0.0.0/0", Except: []string{"10.0.0.0/8", "192.168.0.0/16"}}},
+						{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/24"}},
+						{IPBlock: &networkingv1.IPBlock{CIDR: "192.168.0.0/16"}},
+					},
+				}},
+			})
+
+			findings := Lint([]*networkingv1.NetworkPolicy{policy}, &Config{PodCIDRs: []string{"10.0.0.0/16"}})
+			Expect(linterRuleIDs(findings)).To(Equal([]string{"ip-block-overlaps-pods"}))
+			Expect(findings[0].Path).To(Equal("spec.egress[0].to[1].ipBlock"))
+
+			findings = Lint([]*networkingv1.NetworkPolicy{policy}, &Config{Inventory: linterInventory})
+			Expect(linterRuleIDs(findings)).To(Equal([]string{"ip-block-overlaps-pods"}))
+			Expect(findings[0].Message).To(ContainSubstring("pod default/web (10.0.0.1), pod default/db (10.0.0.2)"))
+		})
+
+		It("finds NotIn requirements on keys that objects are missing", func() {
+			policy := linterPolicy(networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "tier", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"backend"}},
+				}},
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{
+					From: []networkingv1.NetworkPolicyPeer{{
+						NamespaceSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+							{Key: "team", Operator: metav1.LabelSelectorOpExists},
+							{Key: "team", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"ops"}},
+						}},
+						PodSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+							{Key: "app", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"db"}},
+						}},
+					}},
+				}},
+			})
+
+			findings := Lint([]*networkingv1.NetworkPolicy{policy}, &Config{})
+			Expect(linterRuleIDs(findings)).To(Equal([]string{"not-in-missing-key", "not-in-missing-key"}))
+			Expect(findings[0].Path).To(Equal("spec.podSelector.matchExpressions[0]"))
+			Expect(findings[1].Path).To(Equal("spec.ingress[0].from[0].podSelector.matchExpressions[0]"))
+
+			// every pod has an app label, but only one of two has a tier label
+			findings = Lint([]*networkingv1.NetworkPolicy{policy}, &Config{Inventory: linterInventory})
+			Expect(linterRuleIDs(findings)).To(Equal([]string{"not-in-missing-key"}))
+			Expect(findings[0].Message).To(ContainSubstring("1 of 2 pods in namespace default"))
+		})
+
+		It("doesn't find anything wrong with the built-in examples' policy types", func() {
+			for _, finding := range Lint(examples.AllExamples, &Config{}) {
+				Expect(finding.RuleID).ToNot(Equal("policy-types-missing-direction"))
+			}
+		})
+	})
+
+	Describe("ToSARIF", func() {
+		It("reports every rule and finding", func() {
+			accidentalOr := examples.AccidentalOr("default", map[string]string{"app": "web"}, map[string]string{"user": "alice"}, map[string]string{"role": "client"})
+			log := ToSARIF(Lint([]*networkingv1.NetworkPolicy{accidentalOr}, &Config{}))
+
+			Expect(log.Version).To(Equal("2.1.0"))
+			Expect(log.Runs[0].Tool.Driver.Rules).To(HaveLen(len(Rules)))
+			Expect(log.Runs[0].Results).To(HaveLen(1))
+			Expect(log.Runs[0].Results[0].Locations[0].LogicalLocations[0].FullyQualifiedName).To(Equal("default/accidental-or.spec.ingress[0].from"))
+
+			bytes, err := json.Marshal(log)
+			Expect(err).To(BeNil())
+			Expect(string(bytes)).To(ContainSubstring(`"ruleId":"peers-or-instead-of-and"`))
+		})
+	})
+}
@@ -0,0 +1,284 @@
+package linter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/inventory"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/matcher"
+	"github.com/pkg/errors"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// These rules look for rules and targets which don't do anything.  Rules are
+// compared conservatively: label selectors have to be identical for one rule
+// to make another redundant.
+
+// effectiveRules are the rules of a policy in the directions of its policy types;
+// rules in other directions are ignored, which policy-types-missing-direction reports
+func effectiveRules(policy *networkingv1.NetworkPolicy) []*policyRule {
+	isIngress, isEgress := false, false
+	for _, policyType := range netpol.PolicyTypes(policy) {
+		switch policyType {
+		case networkingv1.PolicyTypeIngress:
+			isIngress = true
+		case networkingv1.PolicyTypeEgress:
+			isEgress = true
+		}
+	}
+	var rules []*policyRule
+	for _, rule := range policyRules(policy) {
+		if (rule.IsIngress && isIngress) || (!rule.IsIngress && isEgress) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// sourcedPeerPortMatcher remembers which policy and rule a PeerPortMatcher came
+// from, which is lost once targets are combined
+type sourcedPeerPortMatcher struct {
+	Policy  string
+	Path    string
+	Matcher *matcher.PeerPortMatcher
+}
+
+// checkRedundantRules finds rules allowing a subset of another rule for the same
+// pods in the same direction.  Of identical rules, all but the first are reported.
+// The other rule is either in the same policy, for redundant-rule, or in another
+// policy, for shadowed-rule.
+func checkRedundantRules(isSamePolicy bool) func(policies []*networkingv1.NetworkPolicy, config *Config) []*Finding {
+	return func(policies []*networkingv1.NetworkPolicy, config *Config) []*Finding {
+		var keys []string
+		matchers := map[string][]*sourcedPeerPortMatcher{}
+		for _, policy := range policies {
+			name := fmt.Sprintf("%s/%s", policy.Namespace, policy.Name)
+			for _, rule := range effectiveRules(policy) {
+				key := fmt.Sprintf("%t %s %s", rule.IsIngress, policy.Namespace, matcher.SerializeLabelSelector(policy.Spec.PodSelector))
+				if _, ok := matchers[key]; !ok {
+					keys = append(keys, key)
+				}
+				for _, m := range matcher.BuildPeerPortMatchers(policy.Namespace, rule.Ports, rule.Peers) {
+					matchers[key] = append(matchers[key], &sourcedPeerPortMatcher{Policy: name, Path: rule.Path, Matcher: m})
+				}
+			}
+		}
+
+		var findings []*Finding
+		for _, key := range keys {
+			for i, m := range matchers[key] {
+				for j, other := range matchers[key] {
+					if i == j || !isPeerPortMatcherSubsumed(m.Matcher, other.Matcher) {
+						continue
+					}
+					if j > i && isPeerPortMatcherSubsumed(other.Matcher, m.Matcher) {
+						continue
+					}
+					if (m.Policy == other.Policy) == isSamePolicy {
+						findings = append(findings, &Finding{
+							Policy: m.Policy,
+							Path:   m.Path,
+							Message: fmt.Sprintf("rule %s is subsumed by rule %s of policy %s, at %s",
+								describeMatcher(m.Matcher), describeMatcher(other.Matcher), other.Policy, other.Path),
+						})
+					}
+					break
+				}
+			}
+		}
+		return findings
+	}
+}
+
+// checkDeadRule finds peers which can only match pods, but match none of the inventory's
+func checkDeadRule(policy *networkingv1.NetworkPolicy, config *Config) []*Finding {
+	if config.Inventory == nil {
+		return nil
+	}
+	var findings []*Finding
+	for _, rule := range effectiveRules(policy) {
+		for i, peer := range rule.Peers {
+			peerMatcher := matcher.BuildPeerMatcher(policy.Namespace, peer)
+			if isPeerDead(peerMatcher, config.Inventory) {
+				findings = append(findings, &Finding{
+					Path:    fmt.Sprintf("%s[%d]", rule.PeersPath, i),
+					Message: fmt.Sprintf("peer %s matches no pods", describeMatcher(peerMatcher)),
+				})
+			}
+		}
+	}
+	return findings
+}
+
+func checkEmptyTarget(policy *networkingv1.NetworkPolicy, config *Config) []*Finding {
+	if config.Inventory == nil || len(podsInNamespace(config.Inventory, policy.Namespace, policy.Spec.PodSelector)) > 0 {
+		return nil
+	}
+	return []*Finding{{
+		Path:    "spec.podSelector",
+		Message: fmt.Sprintf("pod selector %s matches no pods in namespace %s", matcher.SerializeLabelSelector(policy.Spec.PodSelector), policy.Namespace),
+	}}
+}
+
+// describeMatcher describes a PeerPortMatcher or PeerMatcher by its json
+func describeMatcher(m interface{}) string {
+	bytes, err := json.Marshal(m)
+	if err != nil {
+		panic(errors.Wrapf(err, "unable to marshal json"))
+	}
+	return string(bytes)
+}
+
+// isPeerDead checks whether a peer which can only match pods matches none of the inventory's
+func isPeerDead(peer matcher.PeerMatcher, inv *inventory.Inventory) bool {
+	switch peer.(type) {
+	case *matcher.IPBlockPeerMatcher, *matcher.AnywherePeerMatcher:
+		return false
+	}
+	for _, pod := range inv.Pods {
+		if peer.Allows(matcher.InventoryPeer(inv, pod)) {
+			return false
+		}
+	}
+	return true
+}
+
+// isPeerPortMatcherSubsumed checks whether everything allowed by a is also allowed by b
+func isPeerPortMatcherSubsumed(a *matcher.PeerPortMatcher, b *matcher.PeerPortMatcher) bool {
+	return isPeerSubsumed(a.Peer, b.Peer) && isPortSubsumed(a.Port, b.Port)
+}
+
+func isSelectorEqual(a metav1.LabelSelector, b metav1.LabelSelector) bool {
+	return matcher.SerializeLabelSelector(a) == matcher.SerializeLabelSelector(b)
+}
+
+// isPeerSubsumed checks whether every peer matched by a is also matched by b
+func isPeerSubsumed(a matcher.PeerMatcher, b matcher.PeerMatcher) bool {
+	switch b := b.(type) {
+	case *matcher.AnywherePeerMatcher:
+		return true
+	case *matcher.AllPodsAllNamespacesPeerMatcher:
+		switch a.(type) {
+		case *matcher.IPBlockPeerMatcher, *matcher.AnywherePeerMatcher:
+			return false
+		default:
+			return true
+		}
+	case *matcher.AllPodsInMatchingNamespacesPeerMatcher:
+		switch a := a.(type) {
+		case *matcher.AllPodsInMatchingNamespacesPeerMatcher:
+			return isSelectorEqual(a.NamespaceSelector, b.NamespaceSelector)
+		case *matcher.MatchingPodsInMatchingNamespacesPeerMatcher:
+			return isSelectorEqual(a.NamespaceSelector, b.NamespaceSelector)
+		}
+	case *matcher.AllPodsInPolicyNamespacePeerMatcher:
+		switch a := a.(type) {
+		case *matcher.AllPodsInPolicyNamespacePeerMatcher:
+			return a.Namespace == b.Namespace
+		case *matcher.MatchingPodsInPolicyNamespacePeerMatcher:
+			return a.Namespace == b.Namespace
+		}
+	case *matcher.MatchingPodsInAllNamespacesPeerMatcher:
+		switch a := a.(type) {
+		case *matcher.MatchingPodsInAllNamespacesPeerMatcher:
+			return isSelectorEqual(a.PodSelector, b.PodSelector)
+		case *matcher.MatchingPodsInPolicyNamespacePeerMatcher:
+			return isSelectorEqual(a.PodSelector, b.PodSelector)
+		case *matcher.MatchingPodsInMatchingNamespacesPeerMatcher:
+			return isSelectorEqual(a.PodSelector, b.PodSelector)
+		}
+	case *matcher.MatchingPodsInPolicyNamespacePeerMatcher:
+		if a, ok := a.(*matcher.MatchingPodsInPolicyNamespacePeerMatcher); ok {
+			return a.Namespace == b.Namespace && isSelectorEqual(a.PodSelector, b.PodSelector)
+		}
+	case *matcher.MatchingPodsInMatchingNamespacesPeerMatcher:
+		if a, ok := a.(*matcher.MatchingPodsInMatchingNamespacesPeerMatcher); ok {
+			return isSelectorEqual(a.NamespaceSelector, b.NamespaceSelector) && isSelectorEqual(a.PodSelector, b.PodSelector)
+		}
+	case *matcher.IPBlockPeerMatcher:
+		if a, ok := a.(*matcher.IPBlockPeerMatcher); ok {
+			return isIPBlockSubsumed(a.IPBlock, b.IPBlock)
+		}
+	default:
+		panic(errors.Errorf("invalid matcher.PeerMatcher type %T", b))
+	}
+	return false
+}
+
+// isIPBlockSubsumed checks whether every IP in a is also in b: a's CIDR has to be
+// within b's, and wherever one of b's exceptions overlaps a, a has to have an exception too
+func isIPBlockSubsumed(a *networkingv1.IPBlock, b *networkingv1.IPBlock) bool {
+	aNet, bNet := parseCIDR(a.CIDR), parseCIDR(b.CIDR)
+	if !isCIDRWithin(aNet, bNet) {
+		return false
+	}
+	for _, bExcept := range b.Except {
+		// CIDRs either nest or are disjoint
+		overlap := parseCIDR(bExcept)
+		if isCIDRWithin(aNet, overlap) {
+			overlap = aNet
+		} else if !isCIDRWithin(overlap, aNet) {
+			continue
+		}
+		isExcepted := false
+		for _, aExcept := range a.Except {
+			if isCIDRWithin(overlap, parseCIDR(aExcept)) {
+				isExcepted = true
+				break
+			}
+		}
+		if !isExcepted {
+			return false
+		}
+	}
+	return true
+}
+
+func parseCIDR(cidr string) *net.IPNet {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return ipNet
+}
+
+func isCIDRWithin(inner *net.IPNet, outer *net.IPNet) bool {
+	innerOnes, innerBits := inner.Mask.Size()
+	outerOnes, outerBits := outer.Mask.Size()
+	return innerBits == outerBits && outerOnes <= innerOnes && outer.Contains(inner.IP)
+}
+
+// isPortSubsumed checks whether every port matched by a is also matched by b
+func isPortSubsumed(a matcher.PortMatcher, b matcher.PortMatcher) bool {
+	switch b := b.(type) {
+	case *matcher.AllPortsAllProtocolsMatcher:
+		return true
+	case *matcher.AllPortsOnProtocolMatcher:
+		switch a := a.(type) {
+		case *matcher.AllPortsOnProtocolMatcher:
+			return a.Protocol == b.Protocol
+		case *matcher.ExactPortProtocolMatcher:
+			return a.Protocol == b.Protocol
+		case *matcher.PortRangeMatcher:
+			return a.Protocol == b.Protocol
+		}
+	case *matcher.ExactPortProtocolMatcher:
+		if a, ok := a.(*matcher.ExactPortProtocolMatcher); ok {
+			return a.Protocol == b.Protocol && a.Port == b.Port
+		}
+	case *matcher.PortRangeMatcher:
+		switch a := a.(type) {
+		case *matcher.ExactPortProtocolMatcher:
+			return a.Protocol == b.Protocol && a.Port.Type == intstr.Int && b.From <= int(a.Port.IntVal) && int(a.Port.IntVal) <= b.To
+		case *matcher.PortRangeMatcher:
+			return a.Protocol == b.Protocol && b.From <= a.From && a.To <= b.To
+		}
+	default:
+		panic(errors.Errorf("invalid matcher.PortMatcher type %T", b))
+	}
+	return false
+}
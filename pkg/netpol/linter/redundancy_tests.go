@@ -0,0 +1,158 @@
+package linter
+
+import (
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/inventory"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+var redundancyInventory = &inventory.Inventory{
+	Namespaces: []*inventory.Namespace{
+		{Name: "default", Labels: map[string]string{"ns": "default"}},
+		{Name: "prod", Labels: map[string]string{"ns": "prod"}},
+	},
+	Pods: []*inventory.Pod{
+		{Namespace: "default", Name: "web", Labels: map[string]string{"app": "web"}, IP: "10.0.0.1"},
+		{Namespace: "default", Name: "db", Labels: map[string]string{"app": "db"}, IP: "10.0.0.2"},
+		{Namespace: "prod", Name: "api", Labels: map[string]string{"app": "api"}, IP: "10.0.1.1"},
+		{Namespace: "default", Name: "agent", Labels: map[string]string{"app": "agent"}, IP: "192.168.0.1", HostNetwork: true},
+	},
+}
+
+func ingressPolicy(name string, app string, rules ...networkingv1.NetworkPolicyIngressRule) *networkingv1.NetworkPolicy {
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": app}},
+			Ingress:     rules,
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+		},
+	}
+}
+
+func fromPods(app string, ports ...networkingv1.NetworkPolicyPort) networkingv1.NetworkPolicyIngressRule {
+	return networkingv1.NetworkPolicyIngressRule{
+		From:  []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": app}}}},
+		Ports: ports,
+	}
+}
+
+func policyPort(protocol v1.Protocol, port int) networkingv1.NetworkPolicyPort {
+	p := intstr.FromInt(port)
+	return networkingv1.NetworkPolicyPort{Protocol: &protocol, Port: &p}
+}
+
+func RunRedundancyTests() {
+	Describe("Lint: rules which don't do anything", func() {
+		config := &Config{Inventory: redundancyInventory}
+
+		It("finds nothing wrong with a policy whose rules all matter", func() {
+			policy := ingressPolicy("web", "web",
+				fromPods("db", policyPort(v1.ProtocolTCP, 80)),
+				fromPods("web", policyPort(v1.ProtocolTCP, 80)))
+
+			Expect(Lint([]*networkingv1.NetworkPolicy{policy}, config)).To(BeEmpty())
+		})
+
+		It("finds a rule made redundant by an allow-all rule of the same policy", func() {
+			policy := ingressPolicy("web", "web",
+				networkingv1.NetworkPolicyIngressRule{},
+				fromPods("db", policyPort(v1.ProtocolTCP, 80)))
+
+			findings := Lint([]*networkingv1.NetworkPolicy{policy}, config)
+
+			Expect(linterRuleIDs(findings)).To(Equal([]string{"redundant-rule"}))
+			Expect(findings[0].Policy).To(Equal("default/web"))
+			Expect(findings[0].Path).To(Equal("spec.ingress[1]"))
+			Expect(findings[0].Message).To(HaveSuffix("of policy default/web, at spec.ingress[0]"))
+		})
+
+		It("reports only the later of two identical rules", func() {
+			policy := ingressPolicy("web", "web",
+				fromPods("db", policyPort(v1.ProtocolTCP, 80)),
+				fromPods("db", policyPort(v1.ProtocolTCP, 80)))
+
+			findings := Lint([]*networkingv1.NetworkPolicy{policy}, config)
+
+			Expect(linterRuleIDs(findings)).To(Equal([]string{"redundant-rule"}))
+			Expect(findings[0].Path).To(Equal("spec.ingress[1]"))
+		})
+
+		It("finds a rule shadowed by a rule of another policy on the same pods", func() {
+			broad := ingressPolicy("web-broad", "web", networkingv1.NetworkPolicyIngressRule{
+				From: []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}},
+			})
+			narrow := ingressPolicy("web-narrow", "web", fromPods("db", policyPort(v1.ProtocolTCP, 80)))
+
+			findings := Lint([]*networkingv1.NetworkPolicy{broad, narrow}, config)
+
+			Expect(linterRuleIDs(findings)).To(Equal([]string{"shadowed-rule"}))
+			Expect(findings[0].Policy).To(Equal("default/web-narrow"))
+			Expect(findings[0].Level).To(Equal(LevelNote))
+		})
+
+		It("finds port ranges covering exact ports", func() {
+			endPort := int32(90)
+			portRange := policyPort(v1.ProtocolTCP, 80)
+			portRange.EndPort = &endPort
+			policy := ingressPolicy("web", "web",
+				fromPods("db", portRange),
+				fromPods("db", policyPort(v1.ProtocolTCP, 85)),
+				fromPods("db", policyPort(v1.ProtocolUDP, 85)))
+
+			findings := Lint([]*networkingv1.NetworkPolicy{policy}, config)
+
+			Expect(linterRuleIDs(findings)).To(Equal([]string{"redundant-rule"}))
+			Expect(findings[0].Message).To(ContainSubstring(`"Port":85`))
+		})
+
+		It("finds IP blocks within other IP blocks", func() {
+			policy := ingressPolicy("web", "web",
+				networkingv1.NetworkPolicyIngressRule{From: []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/8", Except: []string{"10.1.0.0/16"}}}}},
+				networkingv1.NetworkPolicyIngressRule{From: []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: "10.2.0.0/16"}}}},
+				networkingv1.NetworkPolicyIngressRule{From: []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: "10.1.2.0/24"}}}})
+
+			findings := Lint([]*networkingv1.NetworkPolicy{policy}, &Config{})
+
+			Expect(linterRuleIDs(findings)).To(Equal([]string{"redundant-rule"}))
+			Expect(findings[0].Message).To(HavePrefix(`rule {"Peer":{"CIDR":"10.2.0.0/16"`))
+		})
+
+		It("ignores rules in directions the policy types leave out", func() {
+			policy := ingressPolicy("web", "web")
+			policy.Spec.Egress = []networkingv1.NetworkPolicyEgressRule{{}, {}}
+
+			Expect(linterRuleIDs(Lint([]*networkingv1.NetworkPolicy{policy}, config))).To(Equal([]string{"policy-types-missing-direction"}))
+		})
+
+		It("finds dead rules and empty targets", func() {
+			dead := ingressPolicy("web", "web", fromPods("cache"))
+			empty := ingressPolicy("queue", "queue", fromPods("web"))
+
+			findings := Lint([]*networkingv1.NetworkPolicy{dead, empty}, config)
+
+			Expect(linterRuleIDs(findings)).To(Equal([]string{"dead-rule", "empty-target"}))
+			Expect(findings[0].Policy).To(Equal("default/web"))
+			Expect(findings[0].Path).To(Equal("spec.ingress[0].from[0]"))
+			Expect(findings[1].Policy).To(Equal("default/queue"))
+			Expect(findings[1].Path).To(Equal("spec.podSelector"))
+		})
+
+		It("finds rules whose pod selectors only match hostNetwork pods dead", func() {
+			policy := ingressPolicy("web", "web", fromPods("agent"))
+
+			Expect(linterRuleIDs(Lint([]*networkingv1.NetworkPolicy{policy}, config))).To(Equal([]string{"dead-rule"}))
+		})
+
+		It("needs an inventory to find dead rules and empty targets", func() {
+			dead := ingressPolicy("web", "web", fromPods("cache"))
+			empty := ingressPolicy("queue", "queue", fromPods("web"))
+
+			Expect(Lint([]*networkingv1.NetworkPolicy{dead, empty}, &Config{})).To(BeEmpty())
+		})
+	})
+}
@@ -0,0 +1,289 @@
+package linter
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/mattfenwick/kube-prototypes/pkg/kube"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/inventory"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// policyRule is an ingress or egress rule of a policy, along with where it is in the policy
+type policyRule struct {
+	IsIngress bool
+	Path      string
+	PeersPath string
+	Peers     []networkingv1.NetworkPolicyPeer
+	Ports     []networkingv1.NetworkPolicyPort
+}
+
+func policyRules(policy *networkingv1.NetworkPolicy) []*policyRule {
+	var rules []*policyRule
+	for i, rule := range policy.Spec.Ingress {
+		path := fmt.Sprintf("spec.ingress[%d]", i)
+		rules = append(rules, &policyRule{IsIngress: true, Path: path, PeersPath: path + ".from", Peers: rule.From, Ports: rule.Ports})
+	}
+	for i, rule := range policy.Spec.Egress {
+		path := fmt.Sprintf("spec.egress[%d]", i)
+		rules = append(rules, &policyRule{IsIngress: false, Path: path, PeersPath: path + ".to", Peers: rule.To, Ports: rule.Ports})
+	}
+	return rules
+}
+
+func checkPeersOrInsteadOfAnd(policy *networkingv1.NetworkPolicy, config *Config) []*Finding {
+	var findings []*Finding
+	for _, rule := range policyRules(policy) {
+		namespacePeer, podPeer := -1, -1
+		for i, peer := range rule.Peers {
+			if peer.NamespaceSelector != nil && peer.PodSelector == nil && namespacePeer < 0 {
+				namespacePeer = i
+			}
+			if peer.PodSelector != nil && peer.NamespaceSelector == nil && podPeer < 0 {
+				podPeer = i
+			}
+		}
+		if namespacePeer >= 0 && podPeer >= 0 {
+			findings = append(findings, &Finding{
+				Path: rule.PeersPath,
+				Message: fmt.Sprintf("peer %d selects pods in matching namespaces and peer %d selects matching pods in namespace %s, so either is allowed -- not just matching pods in matching namespaces",
+					namespacePeer, podPeer, policy.Namespace),
+			})
+		}
+	}
+	return findings
+}
+
+func checkPolicyTypesMissingDirection(policy *networkingv1.NetworkPolicy, config *Config) []*Finding {
+	// without policyTypes, the directions are inferred from the rules
+	if len(policy.Spec.PolicyTypes) == 0 {
+		return nil
+	}
+	hasIngress, hasEgress := false, false
+	for _, policyType := range policy.Spec.PolicyTypes {
+		switch policyType {
+		case networkingv1.PolicyTypeIngress:
+			hasIngress = true
+		case networkingv1.PolicyTypeEgress:
+			hasEgress = true
+		}
+	}
+	var findings []*Finding
+	if len(policy.Spec.Ingress) > 0 && !hasIngress {
+		findings = append(findings, &Finding{
+			Path:    "spec.policyTypes",
+			Message: "policyTypes don't include Ingress, so the ingress rules are ignored",
+		})
+	}
+	if len(policy.Spec.Egress) > 0 && !hasEgress {
+		findings = append(findings, &Finding{
+			Path:    "spec.policyTypes",
+			Message: "policyTypes don't include Egress, so the egress rules are ignored",
+		})
+	}
+	return findings
+}
+
+// checkNamedPortNotExposed looks at the pods selected by the policy for ingress
+// rules, and at every pod for egress rules
+func checkNamedPortNotExposed(policy *networkingv1.NetworkPolicy, config *Config) []*Finding {
+	if config.Inventory == nil {
+		return nil
+	}
+	var findings []*Finding
+	for _, rule := range policyRules(policy) {
+		pods, description := config.Inventory.Pods, "any pod"
+		if rule.IsIngress {
+			pods = podsInNamespace(config.Inventory, policy.Namespace, policy.Spec.PodSelector)
+			description = fmt.Sprintf("the %d pods selected by the policy", len(pods))
+		}
+		if len(pods) == 0 {
+			continue
+		}
+		for i, port := range rule.Ports {
+			if port.Port == nil || port.Port.Type != intstr.String {
+				continue
+			}
+			protocol := v1.ProtocolTCP
+			if port.Protocol != nil {
+				protocol = *port.Protocol
+			}
+			if !isNamedPortExposed(pods, port.Port.StrVal, protocol) {
+				findings = append(findings, &Finding{
+					Path:    fmt.Sprintf("%s.ports[%d]", rule.Path, i),
+					Message: fmt.Sprintf("no container port named %s on protocol %s exists on %s", port.Port.StrVal, protocol, description),
+				})
+			}
+		}
+	}
+	return findings
+}
+
+func podsInNamespace(inv *inventory.Inventory, namespace string, selector metav1.LabelSelector) []*inventory.Pod {
+	var pods []*inventory.Pod
+	for _, pod := range inv.Pods {
+		if pod.Namespace == namespace && kube.IsLabelsMatchLabelSelector(pod.Labels, selector) {
+			pods = append(pods, pod)
+		}
+	}
+	return pods
+}
+
+func isNamedPortExposed(pods []*inventory.Pod, name string, protocol v1.Protocol) bool {
+	for _, pod := range pods {
+		for _, containerPort := range pod.ContainerPorts {
+			containerProtocol := containerPort.Protocol
+			if containerProtocol == "" {
+				containerProtocol = v1.ProtocolTCP
+			}
+			if containerPort.Name == name && containerProtocol == protocol {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func checkIPBlockOverlapsPods(policy *networkingv1.NetworkPolicy, config *Config) []*Finding {
+	var findings []*Finding
+	for _, rule := range policyRules(policy) {
+		for i, peer := range rule.Peers {
+			if peer.IPBlock == nil {
+				continue
+			}
+			_, blockNet, err := net.ParseCIDR(peer.IPBlock.CIDR)
+			if err != nil {
+				continue
+			}
+
+			var overlaps []string
+			for _, podCIDR := range config.PodCIDRs {
+				_, podNet, err := net.ParseCIDR(podCIDR)
+				if err == nil && isCIDROverlapping(blockNet, podNet) && !isCIDRExcepted(podNet, peer.IPBlock) {
+					overlaps = append(overlaps, fmt.Sprintf("pod CIDR %s", podCIDR))
+				}
+			}
+			if config.Inventory != nil {
+				for _, pod := range config.Inventory.Pods {
+					for _, ip := range pod.AllIPs() {
+						if kube.IsIPBlockMatchForIP(ip, peer.IPBlock) {
+							overlaps = append(overlaps, fmt.Sprintf("pod %s (%s)", pod.Key(), ip))
+						}
+					}
+				}
+			}
+
+			if len(overlaps) > 0 {
+				findings = append(findings, &Finding{
+					Path:    fmt.Sprintf("%s[%d].ipBlock", rule.PeersPath, i),
+					Message: fmt.Sprintf("ipBlock %s overlaps %s; use pod and namespace selectors for pod traffic", peer.IPBlock.CIDR, strings.Join(overlaps, ", ")),
+				})
+			}
+		}
+	}
+	return findings
+}
+
+// isCIDROverlapping relies on two CIDRs either nesting or being disjoint
+func isCIDROverlapping(a *net.IPNet, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+func isCIDRExcepted(cidr *net.IPNet, ipBlock *networkingv1.IPBlock) bool {
+	cidrOnes, _ := cidr.Mask.Size()
+	for _, except := range ipBlock.Except {
+		_, exceptNet, err := net.ParseCIDR(except)
+		if err != nil {
+			continue
+		}
+		exceptOnes, _ := exceptNet.Mask.Size()
+		if exceptOnes <= cidrOnes && exceptNet.Contains(cidr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// labeledObjects are the labels of the pods or namespaces a selector could select
+type labeledObjects struct {
+	Noun   string
+	Labels []map[string]string
+}
+
+// checkNotInMissingKey reports NotIn requirements on keys which some objects
+// don't have; without an inventory, it reports every one of them
+func checkNotInMissingKey(policy *networkingv1.NetworkPolicy, config *Config) []*Finding {
+	namespaces := &labeledObjects{Noun: "namespaces"}
+	allPods := &labeledObjects{Noun: "pods"}
+	namespacePods := &labeledObjects{Noun: fmt.Sprintf("pods in namespace %s", policy.Namespace)}
+	if config.Inventory != nil {
+		for _, ns := range config.Inventory.Namespaces {
+			namespaces.Labels = append(namespaces.Labels, ns.Labels)
+		}
+		for _, pod := range config.Inventory.Pods {
+			allPods.Labels = append(allPods.Labels, pod.Labels)
+			if pod.Namespace == policy.Namespace {
+				namespacePods.Labels = append(namespacePods.Labels, pod.Labels)
+			}
+		}
+	}
+
+	findings := notInMissingKey("spec.podSelector", policy.Spec.PodSelector, namespacePods, config)
+	for _, rule := range policyRules(policy) {
+		for i, peer := range rule.Peers {
+			path := fmt.Sprintf("%s[%d]", rule.PeersPath, i)
+			if peer.NamespaceSelector != nil {
+				findings = append(findings, notInMissingKey(path+".namespaceSelector", *peer.NamespaceSelector, namespaces, config)...)
+			}
+			if peer.PodSelector != nil {
+				pods := namespacePods
+				if peer.NamespaceSelector != nil {
+					pods = allPods
+				}
+				findings = append(findings, notInMissingKey(path+".podSelector", *peer.PodSelector, pods, config)...)
+			}
+		}
+	}
+	return findings
+}
+
+func notInMissingKey(path string, selector metav1.LabelSelector, objects *labeledObjects, config *Config) []*Finding {
+	var findings []*Finding
+	for i, exp := range selector.MatchExpressions {
+		if exp.Operator != metav1.LabelSelectorOpNotIn || isKeyRequired(selector, exp.Key) {
+			continue
+		}
+		message := fmt.Sprintf("NotIn on key %s also selects %s without the label; add an Exists requirement if that isn't intended", exp.Key, objects.Noun)
+		if config.Inventory != nil {
+			missing := 0
+			for _, labels := range objects.Labels {
+				if _, ok := labels[exp.Key]; !ok {
+					missing++
+				}
+			}
+			if missing == 0 {
+				continue
+			}
+			message = fmt.Sprintf("NotIn on key %s also selects the %d of %d %s without the label; add an Exists requirement if that isn't intended", exp.Key, missing, len(objects.Labels), objects.Noun)
+		}
+		findings = append(findings, &Finding{Path: fmt.Sprintf("%s.matchExpressions[%d]", path, i), Message: message})
+	}
+	return findings
+}
+
+// isKeyRequired checks whether a selector only selects objects which have a label
+func isKeyRequired(selector metav1.LabelSelector, key string) bool {
+	if _, ok := selector.MatchLabels[key]; ok {
+		return true
+	}
+	for _, exp := range selector.MatchExpressions {
+		if exp.Key == key && (exp.Operator == metav1.LabelSelectorOpIn || exp.Operator == metav1.LabelSelectorOpExists) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package linter
+
+// The subset of SARIF 2.1.0 needed to report findings:
+//   https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html
+// Policies don't remember which file they came from, so findings are
+// located by policy and field, rather than by file and line.
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+)
+
+type SARIFLog struct {
+	Version string      `json:"version"`
+	Schema  string      `json:"$schema"`
+	Runs    []*SARIFRun `json:"runs"`
+}
+
+type SARIFRun struct {
+	Tool    *SARIFTool     `json:"tool"`
+	Results []*SARIFResult `json:"results"`
+}
+
+type SARIFTool struct {
+	Driver *SARIFDriver `json:"driver"`
+}
+
+type SARIFDriver struct {
+	Name  string       `json:"name"`
+	Rules []*SARIFRule `json:"rules"`
+}
+
+type SARIFRule struct {
+	ID                   string                       `json:"id"`
+	ShortDescription     *SARIFMessage                `json:"shortDescription"`
+	DefaultConfiguration *SARIFReportingConfiguration `json:"defaultConfiguration"`
+}
+
+type SARIFReportingConfiguration struct {
+	Level Level `json:"level"`
+}
+
+type SARIFMessage struct {
+	Text string `json:"text"`
+}
+
+type SARIFResult struct {
+	RuleID    string           `json:"ruleId"`
+	Level     Level            `json:"level"`
+	Message   *SARIFMessage    `json:"message"`
+	Locations []*SARIFLocation `json:"locations"`
+}
+
+type SARIFLocation struct {
+	LogicalLocations []*SARIFLogicalLocation `json:"logicalLocations"`
+}
+
+type SARIFLogicalLocation struct {
+	Name               string `json:"name"`
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+// ToSARIF converts findings to a SARIF log, for code scanning tools
+func ToSARIF(findings []*Finding) *SARIFLog {
+	driver := &SARIFDriver{Name: "netpol-explainer lint"}
+	for _, rule := range Rules {
+		driver.Rules = append(driver.Rules, &SARIFRule{
+			ID:                   rule.ID,
+			ShortDescription:     &SARIFMessage{Text: rule.Description},
+			DefaultConfiguration: &SARIFReportingConfiguration{Level: rule.Level},
+		})
+	}
+	run := &SARIFRun{Tool: &SARIFTool{Driver: driver}, Results: []*SARIFResult{}}
+	for _, finding := range findings {
+		run.Results = append(run.Results, &SARIFResult{
+			RuleID:  finding.RuleID,
+			Level:   finding.Level,
+			Message: &SARIFMessage{Text: finding.Message},
+			Locations: []*SARIFLocation{{
+				LogicalLocations: []*SARIFLogicalLocation{{
+					Name:               finding.Path,
+					FullyQualifiedName: finding.Policy + "." + finding.Path,
+					Kind:               "member",
+				}},
+			}},
+		})
+	}
+	return &SARIFLog{Version: sarifVersion, Schema: sarifSchema, Runs: []*SARIFRun{run}}
+}
@@ -0,0 +1,15 @@
+package linter
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestModel(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunLinterTests()
+	RunRedundancyTests()
+	RunSpecs(t, "network policy linter suite")
+}
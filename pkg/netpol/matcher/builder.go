@@ -1,9 +1,12 @@
 package matcher
 
 import (
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol"
+	"github.com/pkg/errors"
 	v1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 func BuildNetworkPolicy(policy *networkingv1.NetworkPolicy) *Policy {
@@ -24,24 +27,24 @@ func BuildNetworkPolicies(netpols []*networkingv1.NetworkPolicy) *Policy {
 	return np
 }
 
-func BuildTarget(netpol *networkingv1.NetworkPolicy) (*Target, *Target) {
+func BuildTarget(policy *networkingv1.NetworkPolicy) (*Target, *Target) {
 	var ingress *Target
 	var egress *Target
-	for _, pType := range netpol.Spec.PolicyTypes {
+	for _, pType := range netpol.PolicyTypes(policy) {
 		switch pType {
 		case networkingv1.PolicyTypeIngress:
 			ingress = &Target{
-				Namespace:   netpol.Namespace,
-				PodSelector: netpol.Spec.PodSelector,
-				SourceRules: []string{netpol.Name},
-				Edge:        BuildIngressMatcher(netpol.Namespace, netpol.Spec.Ingress),
+				Namespace:   policy.Namespace,
+				PodSelector: policy.Spec.PodSelector,
+				SourceRules: []string{policy.Name},
+				Edge:        BuildIngressMatcher(policy.Namespace, policy.Spec.Ingress),
 			}
 		case networkingv1.PolicyTypeEgress:
 			egress = &Target{
-				Namespace:   netpol.Namespace,
-				PodSelector: netpol.Spec.PodSelector,
-				SourceRules: []string{netpol.Name},
-				Edge:        BuildEgressMatcher(netpol.Namespace, netpol.Spec.Egress),
+				Namespace:   policy.Namespace,
+				PodSelector: policy.Spec.PodSelector,
+				SourceRules: []string{policy.Name},
+				Edge:        BuildEgressMatcher(policy.Namespace, policy.Spec.Egress),
 			}
 		}
 	}
@@ -108,6 +111,12 @@ func BuildPortMatcher(p networkingv1.NetworkPolicyPort) PortMatcher {
 	if p.Port == nil {
 		return &AllPortsOnProtocolMatcher{Protocol: protocol}
 	}
+	if p.EndPort != nil {
+		if p.Port.Type != intstr.Int {
+			panic(errors.Errorf("invalid NetworkPolicyPort: endPort %d requires a numbered port, found '%s'", *p.EndPort, p.Port.String()))
+		}
+		return &PortRangeMatcher{Protocol: protocol, From: int(p.Port.IntVal), To: int(*p.EndPort)}
+	}
 	return &ExactPortProtocolMatcher{Port: *p.Port, Protocol: protocol}
 }
 
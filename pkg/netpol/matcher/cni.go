@@ -0,0 +1,29 @@
+package matcher
+
+// CNIProfile describes behavior which the NetworkPolicy spec leaves up to the network plugin
+type CNIProfile struct {
+	// IPBlocksMatchPods is whether ipBlock peers match pods by their IPs.  The spec
+	// intends ipBlocks for cluster-external IPs: some plugins match pod IPs against
+	// them anyway, while others never match pods with an ipBlock.
+	IPBlocksMatchPods bool
+	// AllowLoopback is whether a pod can always reach itself, regardless of policies
+	AllowLoopback bool
+	// PoliciesApplyToNodeTraffic is whether policies apply to traffic between a pod
+	// and its node, such as kubelet health checks.  The NetworkPolicy docs say that
+	// traffic is always allowed, but not every plugin sticks to that.
+	PoliciesApplyToNodeTraffic bool
+}
+
+// DefaultCNIProfile is used by policies which don't specify a CNIProfile.  It
+// follows the NetworkPolicy docs: traffic between a pod and its node is always
+// allowed, but traffic from a pod to itself isn't special.
+var DefaultCNIProfile = &CNIProfile{IPBlocksMatchPods: true, AllowLoopback: false, PoliciesApplyToNodeTraffic: false}
+
+// IPBlockPeer is the peer as ipBlocks see it: if ipBlocks don't match pods,
+// a pod is left without IPs
+func (cp *CNIProfile) IPBlockPeer(peer *TrafficPeer) *TrafficPeer {
+	if cp.IPBlocksMatchPods || peer.IsExternal() {
+		return peer
+	}
+	return &TrafficPeer{Internal: peer.Internal}
+}
@@ -2,10 +2,12 @@ package matcher
 
 import (
 	"github.com/mattfenwick/kube-prototypes/pkg/kube/netpol/examples"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/inventory"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	v1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
@@ -87,6 +89,29 @@ func RunCornerCaseTests() {
 		})
 	})
 
+	Describe("Default policy types", func() {
+		It("allow-no-ingress", func() {
+			ingress, egress := BuildTarget(examples.AllowNoIngress_NoPolicyTypes)
+
+			Expect(ingress.Edge).To(Equal(&NoneEdgeMatcher{}))
+			Expect(egress).To(BeNil())
+		})
+
+		It("allow-all-ingress", func() {
+			ingress, egress := BuildTarget(examples.AllowAllIngress_NoPolicyTypes)
+
+			Expect(ingress.Edge).To(Equal(anyTrafficPeer))
+			Expect(egress).To(BeNil())
+		})
+
+		It("allow-all-egress", func() {
+			ingress, egress := BuildTarget(examples.AllowAllEgress_NoPolicyTypes)
+
+			Expect(ingress.Edge).To(Equal(&NoneEdgeMatcher{}))
+			Expect(egress.Edge).To(Equal(anyTrafficPeer))
+		})
+	})
+
 	Describe("Source/destination from slice of NetworkPolicyPeer", func() {
 		It("allows all source/destination from an empty slice", func() {
 			sds := BuildPeerMatchers("abc", []networkingv1.NetworkPolicyPeer{})
@@ -183,5 +208,267 @@ func RunCornerCaseTests() {
 				Port:     intstr.FromString("hello"),
 			}))
 		})
+
+		It("allow port range on protocol", func() {
+			sd := BuildPortMatcher(examples.AllowPortRangeOnProtocol)
+			Expect(sd).To(Equal(&PortRangeMatcher{
+				Protocol: v1.ProtocolTCP,
+				From:     9001,
+				To:       9010,
+			}))
+		})
+	})
+
+	Describe("Port range", func() {
+		portRange := &PortRangeMatcher{Protocol: v1.ProtocolTCP, From: 9001, To: 9010}
+
+		It("includes both ends of the range", func() {
+			Expect(portRange.Allows(&ResolvedPort{Protocol: v1.ProtocolTCP, Number: 9000})).To(BeFalse())
+			Expect(portRange.Allows(&ResolvedPort{Protocol: v1.ProtocolTCP, Number: 9001})).To(BeTrue())
+			Expect(portRange.Allows(&ResolvedPort{Protocol: v1.ProtocolTCP, Number: 9010})).To(BeTrue())
+			Expect(portRange.Allows(&ResolvedPort{Protocol: v1.ProtocolTCP, Number: 9011})).To(BeFalse())
+		})
+
+		It("requires a matching protocol", func() {
+			Expect(portRange.Allows(&ResolvedPort{Protocol: v1.ProtocolUDP, Number: 9005})).To(BeFalse())
+		})
+
+		It("does not match a named port that wasn't resolved to a number", func() {
+			Expect(portRange.Allows(&ResolvedPort{Protocol: v1.ProtocolTCP, Name: "hello"})).To(BeFalse())
+		})
+
+		It("explains the range", func() {
+			lines := ExplainEdgePeerPortMatcher(&EdgePeerPortMatcher{Matchers: []*PeerPortMatcher{
+				{Peer: &AnywherePeerMatcher{}, Port: portRange},
+			}})
+			Expect(lines).To(ContainElement("    ports 9001-9010 on protocol TCP"))
+		})
+	})
+
+	Describe("Named port resolution", func() {
+		destination := &TrafficPeer{
+			Internal: &InternalPeer{
+				Namespace: "abc",
+				ContainerPorts: []v1.ContainerPort{
+					{Name: "http", ContainerPort: 80, Protocol: v1.ProtocolTCP},
+					{Name: "metrics", ContainerPort: 9090},
+				},
+			},
+		}
+		source := &TrafficPeer{IP: "1.2.3.4"}
+
+		It("resolves a numbered port to a name", func() {
+			resolved := (&Traffic{
+				Source:       source,
+				Destination:  destination,
+				PortProtocol: &PortProtocol{Protocol: v1.ProtocolTCP, Port: intstr.FromInt(80)},
+			}).ResolvePort()
+			Expect(resolved).To(Equal(&ResolvedPort{Protocol: v1.ProtocolTCP, Number: 80, Name: "http"}))
+		})
+
+		It("resolves a named port to a number, defaulting the container port protocol to TCP", func() {
+			resolved := (&Traffic{
+				Source:       source,
+				Destination:  destination,
+				PortProtocol: &PortProtocol{Protocol: v1.ProtocolTCP, Port: intstr.FromString("metrics")},
+			}).ResolvePort()
+			Expect(resolved).To(Equal(&ResolvedPort{Protocol: v1.ProtocolTCP, Number: 9090, Name: "metrics"}))
+		})
+
+		It("does not resolve across protocols", func() {
+			resolved := (&Traffic{
+				Source:       source,
+				Destination:  destination,
+				PortProtocol: &PortProtocol{Protocol: v1.ProtocolUDP, Port: intstr.FromInt(80)},
+			}).ResolvePort()
+			Expect(resolved).To(Equal(&ResolvedPort{Protocol: v1.ProtocolUDP, Number: 80}))
+		})
+
+		It("matches a named port matcher against numbered traffic, and vice versa", func() {
+			named := &ExactPortProtocolMatcher{Protocol: v1.ProtocolTCP, Port: intstr.FromString("http")}
+			numbered := &ExactPortProtocolMatcher{Protocol: v1.ProtocolTCP, Port: intstr.FromInt(80)}
+			resolved := &ResolvedPort{Protocol: v1.ProtocolTCP, Number: 80, Name: "http"}
+
+			Expect(named.Allows(resolved)).To(BeTrue())
+			Expect(numbered.Allows(resolved)).To(BeTrue())
+			Expect(named.Allows(&ResolvedPort{Protocol: v1.ProtocolTCP, Number: 80})).To(BeFalse())
+			Expect(numbered.Allows(&ResolvedPort{Protocol: v1.ProtocolTCP, Name: "http"})).To(BeFalse())
+		})
+	})
+
+	Describe("IPBlocks and IP families", func() {
+		ipv4 := &IPBlockPeerMatcher{IPBlock: &networkingv1.IPBlock{CIDR: "0.0.0.0/0", Except: []string{"10.0.0.0/8"}}}
+		ipv6 := &IPBlockPeerMatcher{IPBlock: &networkingv1.IPBlock{CIDR: "fd00::/8", Except: []string{"fd00::/64"}}}
+
+		It("only matches IPs of the IPBlock's family", func() {
+			Expect(ipv4.Allows(&TrafficPeer{IP: "1.2.3.4"})).To(BeTrue())
+			Expect(ipv4.Allows(&TrafficPeer{IP: "10.1.2.3"})).To(BeFalse())
+			Expect(ipv4.Allows(&TrafficPeer{IP: "fd00:1::1"})).To(BeFalse())
+			Expect(ipv4.Allows(&TrafficPeer{IP: "::ffff:1.2.3.4"})).To(BeFalse())
+
+			Expect(ipv6.Allows(&TrafficPeer{IP: "fd00:1::1"})).To(BeTrue())
+			Expect(ipv6.Allows(&TrafficPeer{IP: "fd00::1"})).To(BeFalse())
+			Expect(ipv6.Allows(&TrafficPeer{IP: "1.2.3.4"})).To(BeFalse())
+		})
+
+		It("doesn't match missing or malformed IPs", func() {
+			Expect(ipv4.Allows(&TrafficPeer{})).To(BeFalse())
+			Expect(ipv4.Allows(&TrafficPeer{IP: "not-an-ip"})).To(BeFalse())
+		})
+
+		It("splits traffic between dual-stack peers by the families they share", func() {
+			dualStack := &TrafficPeer{Internal: &InternalPeer{Namespace: "x"}, IP: "1.2.3.4", IPs: []string{"1.2.3.4", "fd00:1::1"}}
+			ipv4Only := &TrafficPeer{Internal: &InternalPeer{Namespace: "x"}, IP: "1.2.3.5", IPs: []string{"1.2.3.5"}}
+			traffic := &Traffic{Source: ipv4Only, Destination: dualStack}
+
+			Expect((&Traffic{Source: dualStack, Destination: dualStack}).IPFamilies()).To(Equal([]v1.IPFamily{v1.IPv4Protocol, v1.IPv6Protocol}))
+			Expect(traffic.IPFamilies()).To(Equal([]v1.IPFamily{v1.IPv4Protocol}))
+			Expect((&Traffic{Source: &TrafficPeer{}, Destination: dualStack}).IPFamilies()).To(BeEmpty())
+
+			ipv6Traffic := (&Traffic{Source: dualStack, Destination: dualStack}).ForIPFamily(v1.IPv6Protocol)
+			Expect(ipv6Traffic.Destination.IP).To(Equal("fd00:1::1"))
+			Expect(ipv6.Allows(ipv6Traffic.Destination)).To(BeTrue())
+			Expect(ipv4.Allows(ipv6Traffic.Destination)).To(BeFalse())
+			Expect(traffic.ForIPFamily(v1.IPv6Protocol).Source.IP).To(Equal(""))
+			Expect(dualStack.IP).To(Equal("1.2.3.4"))
+		})
+	})
+	Describe("IPBlocks and CNI profiles", func() {
+		policy := BuildNetworkPolicy(&networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "x", Name: "allow-egress-to-cidr"},
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+				Egress: []networkingv1.NetworkPolicyEgressRule{
+					{To: []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/8"}}}},
+				},
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+			},
+		})
+		web := &TrafficPeer{
+			Internal: &InternalPeer{PodLabels: map[string]string{"app": "web"}, Namespace: "x"},
+			IP:       "10.0.0.1",
+		}
+		port := &PortProtocol{Protocol: v1.ProtocolTCP, Port: intstr.FromInt(80)}
+		toPod := &Traffic{
+			Source:       web,
+			Destination:  &TrafficPeer{Internal: &InternalPeer{Namespace: "y"}, IP: "10.1.2.3"},
+			PortProtocol: port,
+		}
+		toExternal := &Traffic{Source: web, Destination: &TrafficPeer{IP: "10.1.2.3"}, PortProtocol: port}
+
+		It("matches pods by their IPs by default", func() {
+			Expect(policy.GetCNIProfile()).To(Equal(DefaultCNIProfile))
+			Expect(policy.IsTrafficAllowed(toPod).IsAllowed()).To(BeTrue())
+			Expect(policy.IsTrafficAllowed(toExternal).IsAllowed()).To(BeTrue())
+		})
+
+		It("only matches external IPs if ipBlocks don't match pods", func() {
+			restricted := &Policy{Ingress: policy.Ingress, Egress: policy.Egress, CNIProfile: &CNIProfile{IPBlocksMatchPods: false}}
+			Expect(restricted.IsTrafficAllowed(toPod).IsAllowed()).To(BeFalse())
+			Expect(restricted.IsTrafficAllowed(toExternal).IsAllowed()).To(BeTrue())
+		})
+	})
+	Describe("Loopback", func() {
+		web := &InternalPeer{Pod: "web", Namespace: examples.Namespace}
+		loopback := &Traffic{
+			Source:       &TrafficPeer{Internal: web},
+			Destination:  &TrafficPeer{Internal: web},
+			PortProtocol: &PortProtocol{Protocol: v1.ProtocolTCP, Port: intstr.FromInt(80)},
+		}
+
+		It("recognizes traffic from a pod to itself", func() {
+			Expect(loopback.IsLoopback()).To(BeTrue())
+			Expect((&Traffic{Source: &TrafficPeer{Internal: web}, Destination: &TrafficPeer{Internal: &InternalPeer{Pod: "web", Namespace: "y"}}}).IsLoopback()).To(BeFalse())
+			Expect((&Traffic{Source: &TrafficPeer{Internal: &InternalPeer{}}, Destination: &TrafficPeer{Internal: &InternalPeer{}}}).IsLoopback()).To(BeFalse())
+			Expect((&Traffic{Source: &TrafficPeer{IP: "1.2.3.4"}, Destination: &TrafficPeer{IP: "1.2.3.4"}}).IsLoopback()).To(BeFalse())
+		})
+
+		It("evaluates loopback traffic against policies unless the CNI profile allows it", func() {
+			policy := BuildNetworkPolicies([]*networkingv1.NetworkPolicy{examples.AllowNoIngressAllowNoEgress})
+			Expect(policy.IsTrafficAllowed(loopback).IsAllowed()).To(BeFalse())
+
+			policy.CNIProfile = &CNIProfile{IPBlocksMatchPods: true, AllowLoopback: true}
+			result := policy.IsTrafficAllowed(loopback)
+			Expect(result.IsAllowed()).To(BeTrue())
+			Expect(result.IsLoopback).To(BeTrue())
+		})
+	})
+	Describe("Node traffic", func() {
+		web := &TrafficPeer{Internal: &InternalPeer{Pod: "web", Namespace: examples.Namespace, Node: "node-1"}, IP: "10.0.0.1"}
+		port := &PortProtocol{Protocol: v1.ProtocolTCP, Port: intstr.FromInt(8080)}
+		fromLocalNode := &Traffic{Source: &TrafficPeer{Host: &HostPeer{Node: "node-1"}, IP: "192.168.0.1"}, Destination: web, PortProtocol: port}
+		fromOtherNode := &Traffic{Source: &TrafficPeer{Host: &HostPeer{Node: "node-2"}, IP: "192.168.0.2"}, Destination: web, PortProtocol: port}
+		toLocalNode := &Traffic{Source: web, Destination: fromLocalNode.Source, PortProtocol: port}
+
+		It("recognizes traffic between a pod and its node", func() {
+			Expect(fromLocalNode.IsNodeLocal()).To(BeTrue())
+			Expect(toLocalNode.IsNodeLocal()).To(BeTrue())
+			Expect(fromOtherNode.IsNodeLocal()).To(BeFalse())
+			Expect((&Traffic{Source: web, Destination: web}).IsNodeLocal()).To(BeFalse())
+		})
+
+		It("doesn't treat traffic as node local if the node isn't known", func() {
+			unscheduled := &TrafficPeer{Internal: &InternalPeer{Pod: "web", Namespace: examples.Namespace}, IP: "10.0.0.1"}
+			unknownHost := &TrafficPeer{Host: &HostPeer{}, IP: "192.168.0.1"}
+			Expect((&Traffic{Source: unknownHost, Destination: unscheduled, PortProtocol: port}).IsNodeLocal()).To(BeFalse())
+			Expect((&Traffic{Source: unscheduled, Destination: unknownHost, PortProtocol: port}).IsNodeLocal()).To(BeFalse())
+
+			policy := BuildNetworkPolicies([]*networkingv1.NetworkPolicy{examples.AllowNoIngressAllowNoEgress})
+			Expect(policy.IsTrafficAllowed(&Traffic{Source: unknownHost, Destination: unscheduled, PortProtocol: port}).IsAllowed()).To(BeFalse())
+		})
+
+		It("allows traffic between a pod and its node unless the CNI profile applies policies to it", func() {
+			policy := BuildNetworkPolicies([]*networkingv1.NetworkPolicy{examples.AllowNoIngressAllowNoEgress})
+			Expect(policy.IsTrafficAllowed(fromLocalNode).IsNodeLocal).To(BeTrue())
+			Expect(policy.IsTrafficAllowed(fromLocalNode).IsAllowed()).To(BeTrue())
+			Expect(policy.IsTrafficAllowed(toLocalNode).IsAllowed()).To(BeTrue())
+			Expect(policy.IsTrafficAllowed(fromOtherNode).IsAllowed()).To(BeFalse())
+
+			policy.CNIProfile = &CNIProfile{IPBlocksMatchPods: true, PoliciesApplyToNodeTraffic: true}
+			Expect(policy.IsTrafficAllowed(fromLocalNode).IsAllowed()).To(BeFalse())
+			Expect(policy.IsTrafficAllowed(toLocalNode).IsAllowed()).To(BeFalse())
+		})
+
+		It("matches hosts by IP only", func() {
+			host := fromLocalNode.Source
+			Expect((&IPBlockPeerMatcher{IPBlock: &networkingv1.IPBlock{CIDR: "192.168.0.0/24"}}).Allows(host)).To(BeTrue())
+			Expect((&AllPodsAllNamespacesPeerMatcher{}).Allows(host)).To(BeFalse())
+			Expect((&AnywherePeerMatcher{}).Allows(host)).To(BeTrue())
+		})
+	})
+	Describe("Inventory peers", func() {
+		inv := &inventory.Inventory{
+			Namespaces: []*inventory.Namespace{{Name: "default", Labels: map[string]string{"ns": "default"}}},
+			Nodes:      []*inventory.Node{{Name: "node-1", Labels: map[string]string{"zone": "a"}, IPs: []string{"192.168.0.1"}}},
+			Pods: []*inventory.Pod{
+				{Namespace: "default", Name: "web", Labels: map[string]string{"app": "web"}, IP: "10.0.0.1", Node: "node-1"},
+				{Namespace: "default", Name: "agent", Labels: map[string]string{"app": "agent"}, IP: "192.168.0.1", Node: "node-1", HostNetwork: true},
+			},
+		}
+
+		It("converts a pod into an internal peer", func() {
+			Expect(InventoryPeer(inv, inv.Pods[0])).To(Equal(&TrafficPeer{
+				Internal: &InternalPeer{
+					PodLabels:       map[string]string{"app": "web"},
+					Pod:             "web",
+					NamespaceLabels: map[string]string{"kubernetes.io/metadata.name": "default", "ns": "default"},
+					Namespace:       "default",
+					NodeLabels:      map[string]string{"zone": "a"},
+					Node:            "node-1",
+				},
+				IP:  "10.0.0.1",
+				IPs: []string{"10.0.0.1"},
+			}))
+		})
+
+		It("converts a hostNetwork pod into a peer for its node", func() {
+			peer := InventoryPeer(inv, inv.Pods[1])
+			Expect(peer).To(Equal(&TrafficPeer{
+				Host: &HostPeer{NodeLabels: map[string]string{"zone": "a"}, Node: "node-1"},
+				IP:   "192.168.0.1",
+				IPs:  []string{"192.168.0.1"},
+			}))
+			Expect((&AllPodsAllNamespacesPeerMatcher{}).Allows(peer)).To(BeFalse())
+		})
 	})
 }
@@ -6,7 +6,7 @@ import (
 )
 
 type EdgeMatcher interface {
-	Allows(peer *TrafficPeer, portProtocol *PortProtocol) bool
+	Allows(peer *TrafficPeer, port *ResolvedPort) bool
 }
 
 func Combine(a EdgeMatcher, b EdgeMatcher) EdgeMatcher {
@@ -40,12 +40,12 @@ type EdgePeerPortMatcher struct {
 	Matchers []*PeerPortMatcher
 }
 
-func (eppm *EdgePeerPortMatcher) Allows(peer *TrafficPeer, portProtocol *PortProtocol) bool {
+func (eppm *EdgePeerPortMatcher) Allows(peer *TrafficPeer, port *ResolvedPort) bool {
 	if len(eppm.Matchers) == 0 {
 		panic(errors.Errorf("cannot have 0 matchers -- use NoneEdgeMatcher instead"))
 	}
 	for _, sd := range eppm.Matchers {
-		if sd.Allows(peer, portProtocol) {
+		if sd.Allows(peer, port) {
 			return true
 		}
 	}
@@ -62,13 +62,13 @@ func (eppm *EdgePeerPortMatcher) MarshalJSON() (b []byte, e error) {
 // TODO is this necessary, or is it handled by AnywherePeerMatcher ?
 //type AllEdgeMatcher struct{}
 //
-//func (aem *AllEdgeMatcher) Allows(peer *TrafficPeer, portProtocol *PortProtocol) bool {
+//func (aem *AllEdgeMatcher) Allows(peer *TrafficPeer, port *ResolvedPort) bool {
 //	return true
 //}
 
 type NoneEdgeMatcher struct{}
 
-func (nem *NoneEdgeMatcher) Allows(peer *TrafficPeer, portProtocol *PortProtocol) bool {
+func (nem *NoneEdgeMatcher) Allows(peer *TrafficPeer, port *ResolvedPort) bool {
 	return false
 }
 
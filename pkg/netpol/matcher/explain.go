@@ -91,6 +91,8 @@ func ExplainEdgePeerPortMatcher(tp *EdgePeerPortMatcher) []string {
 			port = "all ports all protocols"
 		case *ExactPortProtocolMatcher:
 			port = fmt.Sprintf("port %s on protocol %s", p.Port.String(), p.Protocol)
+		case *PortRangeMatcher:
+			port = fmt.Sprintf("ports %d-%d on protocol %s", p.From, p.To, p.Protocol)
 		default:
 			panic(errors.Errorf("unexpected Port type %T", p))
 		}
@@ -12,8 +12,8 @@ type PeerPortMatcher struct {
 	Port PortMatcher
 }
 
-func (sdap *PeerPortMatcher) Allows(peer *TrafficPeer, portProtocol *PortProtocol) bool {
-	return sdap.Port.Allows(portProtocol) && sdap.Peer.Allows(peer)
+func (sdap *PeerPortMatcher) Allows(peer *TrafficPeer, port *ResolvedPort) bool {
+	return sdap.Port.Allows(port) && sdap.Peer.Allows(peer)
 }
 
 // PeerPortMatcher possibilities:
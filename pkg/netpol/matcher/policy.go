@@ -4,6 +4,8 @@ package matcher
 type Policy struct {
 	Ingress map[string]*Target
 	Egress  map[string]*Target
+	// CNIProfile is how the network plugin evaluates policies; if nil, DefaultCNIProfile is used
+	CNIProfile *CNIProfile `json:",omitempty"`
 }
 
 func NewPolicy() *Policy {
@@ -27,6 +29,13 @@ func (np *Policy) AddTarget(isIngress bool, target *Target) *Target {
 	return dict[pk]
 }
 
+func (np *Policy) GetCNIProfile() *CNIProfile {
+	if np.CNIProfile == nil {
+		return DefaultCNIProfile
+	}
+	return np.CNIProfile
+}
+
 func (np *Policy) TargetsApplyingToPod(isIngress bool, namespace string, podLabels map[string]string) []*Target {
 	var targets []*Target
 	var dict map[string]*Target
@@ -50,8 +59,13 @@ type DirectionResult struct {
 }
 
 type AllowedResult struct {
+	Traffic *Traffic
 	Ingress *DirectionResult
 	Egress  *DirectionResult
+	// IsLoopback is whether the traffic was allowed for going from a pod to itself
+	IsLoopback bool
+	// IsNodeLocal is whether the traffic was allowed for going between a pod and its node
+	IsNodeLocal bool
 }
 
 func (ar *AllowedResult) IsAllowed() bool {
@@ -62,8 +76,23 @@ func (ar *AllowedResult) IsAllowed() bool {
 // - whether the traffic is allowed
 // - which rules allowed the traffic
 // - which rules matched the traffic target
+// Traffic from a pod to itself, and between a pod and its node, may be allowed
+// without looking at policies, depending on the CNIProfile.
 func (np *Policy) IsTrafficAllowed(traffic *Traffic) *AllowedResult {
+	profile := np.GetCNIProfile()
+	isLoopback := profile.AllowLoopback && traffic.IsLoopback()
+	isNodeLocal := !profile.PoliciesApplyToNodeTraffic && traffic.IsNodeLocal()
+	if isLoopback || isNodeLocal {
+		return &AllowedResult{
+			Traffic:     traffic,
+			Ingress:     &DirectionResult{IsAllowed: true, AllowingTargets: nil, MatchingTargets: nil},
+			Egress:      &DirectionResult{IsAllowed: true, AllowingTargets: nil, MatchingTargets: nil},
+			IsLoopback:  isLoopback,
+			IsNodeLocal: isNodeLocal,
+		}
+	}
 	return &AllowedResult{
+		Traffic: traffic,
 		Ingress: np.IsIngressOrEgressAllowed(traffic, true),
 		Egress:  np.IsIngressOrEgressAllowed(traffic, false),
 	}
@@ -93,9 +122,11 @@ func (np *Policy) IsIngressOrEgressAllowed(traffic *Traffic, isIngress bool) *Di
 	}
 
 	// 3. Check if any matching targets allow this traffic
+	port := traffic.ResolvePort()
+	peer = np.GetCNIProfile().IPBlockPeer(peer)
 	var allowers []*Target
 	for _, target := range matchingTargets {
-		if target.Edge.Allows(peer, traffic.PortProtocol) {
+		if target.Edge.Allows(peer, port) {
 			allowers = append(allowers, target)
 		}
 	}
@@ -7,14 +7,14 @@ import (
 )
 
 type PortMatcher interface {
-	Allows(port *PortProtocol) bool
+	Allows(port *ResolvedPort) bool
 }
 
 // AllPortsAllProtocolsMatcher models the case where no ports/protocols are
 // specified, which is treated as "allow any" by NetworkPolicy
 type AllPortsAllProtocolsMatcher struct{}
 
-func (ap *AllPortsAllProtocolsMatcher) Allows(pp *PortProtocol) bool {
+func (ap *AllPortsAllProtocolsMatcher) Allows(port *ResolvedPort) bool {
 	return true
 }
 
@@ -31,8 +31,8 @@ type AllPortsOnProtocolMatcher struct {
 	Protocol v1.Protocol
 }
 
-func (apop *AllPortsOnProtocolMatcher) Allows(pp *PortProtocol) bool {
-	return apop.Protocol == pp.Protocol
+func (apop *AllPortsOnProtocolMatcher) Allows(port *ResolvedPort) bool {
+	return apop.Protocol == port.Protocol
 }
 
 func (apop *AllPortsOnProtocolMatcher) MarshalJSON() (b []byte, e error) {
@@ -49,8 +49,8 @@ type ExactPortProtocolMatcher struct {
 	Port     intstr.IntOrString
 }
 
-func (epp *ExactPortProtocolMatcher) Allows(other *PortProtocol) bool {
-	return other.Protocol == epp.Protocol && isPortMatch(other.Port, epp.Port)
+func (epp *ExactPortProtocolMatcher) Allows(port *ResolvedPort) bool {
+	return port.Protocol == epp.Protocol && isPortMatch(port, epp.Port)
 }
 
 func (epp *ExactPortProtocolMatcher) MarshalJSON() (b []byte, e error) {
@@ -61,28 +61,39 @@ func (epp *ExactPortProtocolMatcher) MarshalJSON() (b []byte, e error) {
 	})
 }
 
-func isPortMatch(a intstr.IntOrString, b intstr.IntOrString) bool {
-	switch a.Type {
+// PortRangeMatcher models the case where traffic must match a protocol and
+// a numbered port within a range -- i.e. NetworkPolicyPort.EndPort is set.
+// Both ends of the range are inclusive.  Since EndPort requires a numbered
+// Port, a named port in traffic only matches if it resolves to a number.
+type PortRangeMatcher struct {
+	Protocol v1.Protocol
+	From     int
+	To       int
+}
+
+func (prm *PortRangeMatcher) Allows(port *ResolvedPort) bool {
+	return port.Protocol == prm.Protocol && port.Number != 0 && prm.From <= port.Number && port.Number <= prm.To
+}
+
+func (prm *PortRangeMatcher) MarshalJSON() (b []byte, e error) {
+	return json.Marshal(map[string]interface{}{
+		"Type":     "port range on protocol",
+		"Protocol": prm.Protocol,
+		"From":     prm.From,
+		"To":       prm.To,
+	})
+}
+
+// isPortMatch compares against whichever of the number or name the policy uses.
+// Since the traffic's port has already been resolved against the destination's
+// container ports, a named port in a policy matches the numbered port it refers to,
+// and vice versa.
+func isPortMatch(port *ResolvedPort, policyPort intstr.IntOrString) bool {
+	switch policyPort.Type {
 	case intstr.Int:
-		switch b.Type {
-		case intstr.Int:
-			return a.IntVal == b.IntVal
-		case intstr.String:
-			// TODO what if this named port resolves to same int?
-			return false
-		default:
-			panic("invalid type")
-		}
+		return port.Number != 0 && port.Number == int(policyPort.IntVal)
 	case intstr.String:
-		switch b.Type {
-		case intstr.Int:
-			// TODO what if this named port resolves to same int?
-			return false
-		case intstr.String:
-			return a.StrVal == b.StrVal
-		default:
-			panic("invalid type")
-		}
+		return port.Name != "" && port.Name == policyPort.StrVal
 	default:
 		panic("invalid type")
 	}
@@ -1,6 +1,8 @@
 package matcher
 
 import (
+	"github.com/mattfenwick/kube-prototypes/pkg/kube"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/inventory"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 )
@@ -19,7 +21,34 @@ type PortProtocol struct {
 
 type TrafficPeer struct {
 	Internal *InternalPeer
-	IP       string
+	// Host is set for a node, or a hostNetwork pod on it.  Since hosts aren't
+	// part of the pod network, they're external to policies.
+	Host *HostPeer
+	// IP is the address the traffic uses, which is what IPBlocks are matched against.
+	// A dual-stack pod's IPv4 and IPv6 traffic are separate Traffic, one for each IP.
+	IP string
+	// IPs are every IP of the peer, of each family, including IP.  ForIPFamily
+	// picks the IP for traffic of a family from them.
+	IPs []string
+}
+
+// allIPs returns IPs, or if there aren't any, IP
+func (p *TrafficPeer) allIPs() []string {
+	if len(p.IPs) > 0 {
+		return p.IPs
+	}
+	if p.IP != "" {
+		return []string{p.IP}
+	}
+	return nil
+}
+
+// ForIPFamily is the peer as traffic of an IP family sees it: with its IP of
+// that family, or without an IP if it doesn't have one
+func (p *TrafficPeer) ForIPFamily(family v1.IPFamily) *TrafficPeer {
+	peer := *p
+	peer.IP = kube.IPOfFamily(p.allIPs(), family)
+	return &peer
 }
 
 func (p *TrafficPeer) Namespace() string {
@@ -29,15 +58,145 @@ func (p *TrafficPeer) Namespace() string {
 	return p.Internal.Namespace
 }
 
+func (p *TrafficPeer) IsHost() bool {
+	return p.Host != nil
+}
+
 func (p *TrafficPeer) IsExternal() bool {
 	return p.Internal == nil
 }
 
+// IPFamilies finds the IP families which both peers have an IP of, in the order of
+// the source's IPs.  Traffic can only go between peers over a family they share.
+func (t *Traffic) IPFamilies() []v1.IPFamily {
+	var families []v1.IPFamily
+	for _, family := range kube.IPFamilies(t.Source.allIPs()) {
+		if kube.IPOfFamily(t.Destination.allIPs(), family) != "" {
+			families = append(families, family)
+		}
+	}
+	return families
+}
+
+// ForIPFamily is the traffic between the peers' IPs of a family
+func (t *Traffic) ForIPFamily(family v1.IPFamily) *Traffic {
+	return &Traffic{
+		Source:       t.Source.ForIPFamily(family),
+		Destination:  t.Destination.ForIPFamily(family),
+		PortProtocol: t.PortProtocol,
+	}
+}
+
+// IsNodeLocal checks whether traffic goes between a pod and the node it's on,
+// such as kubelet health checks.  Nodes are compared by name; traffic is never
+// node local if the node isn't known.
+func (t *Traffic) IsNodeLocal() bool {
+	isNodeLocal := func(host *TrafficPeer, pod *TrafficPeer) bool {
+		return host.Host != nil && pod.Internal != nil && host.Host.Node != "" && host.Host.Node == pod.Internal.Node
+	}
+	return isNodeLocal(t.Source, t.Destination) || isNodeLocal(t.Destination, t.Source)
+}
+
+// IsLoopback checks whether traffic goes from a pod to itself
+func (t *Traffic) IsLoopback() bool {
+	source, dest := t.Source.Internal, t.Destination.Internal
+	return source != nil && dest != nil && source.Pod != "" && source.Pod == dest.Pod && source.Namespace == dest.Namespace
+}
+
 type InternalPeer struct {
 	PodLabels map[string]string
-	//Pod             string
+	// Pod is the name of the pod, or "" if the peer doesn't stand for a particular pod
+	Pod             string
 	NamespaceLabels map[string]string
 	Namespace       string
-	//NodeLabels      map[string]string
-	//Node            string
+	NodeLabels      map[string]string
+	// Node is the name of the node the pod is on, or "" if it isn't known
+	Node           string
+	ContainerPorts []v1.ContainerPort
+}
+
+// HostPeer is a node, or a hostNetwork pod using the node's network
+type HostPeer struct {
+	NodeLabels map[string]string
+	Node       string
+}
+
+// InventoryPeer converts an inventory pod into a peer.  A hostNetwork pod
+// becomes a host peer, since it uses the network of its node.
+func InventoryPeer(inv *inventory.Inventory, pod *inventory.Pod) *TrafficPeer {
+	if pod.HostNetwork {
+		return &TrafficPeer{
+			Host: &HostPeer{
+				NodeLabels: inv.NodeLabels(pod.Node),
+				Node:       pod.Node,
+			},
+			IP:  pod.IP,
+			IPs: pod.AllIPs(),
+		}
+	}
+	return &TrafficPeer{
+		Internal: &InternalPeer{
+			PodLabels:       pod.Labels,
+			Pod:             pod.Name,
+			NamespaceLabels: inv.NamespaceLabels(pod.Namespace),
+			Namespace:       pod.Namespace,
+			NodeLabels:      inv.NodeLabels(pod.Node),
+			Node:            pod.Node,
+			ContainerPorts:  pod.ContainerPorts,
+		},
+		IP:  pod.IP,
+		IPs: pod.AllIPs(),
+	}
+}
+
+// ResolvedPort is a PortProtocol in which both the number and the name of the
+// port have been looked up.  Either one may be missing:
+// - Number is 0 if a named port couldn't be resolved
+// - Name is "" if a numbered port doesn't correspond to a named container port
+type ResolvedPort struct {
+	Protocol v1.Protocol
+	Number   int
+	Name     string
+}
+
+// ResolvePort uses the destination's container ports to resolve named ports
+// to numbers, and numbered ports to names -- since a policy can refer to a
+// port either way, regardless of how the traffic refers to it.
+// External destinations have no container ports, so nothing gets resolved.
+func (t *Traffic) ResolvePort() *ResolvedPort {
+	pp := t.PortProtocol
+	resolved := &ResolvedPort{Protocol: pp.Protocol}
+	switch pp.Port.Type {
+	case intstr.Int:
+		resolved.Number = int(pp.Port.IntVal)
+	case intstr.String:
+		resolved.Name = pp.Port.StrVal
+	default:
+		panic("invalid type")
+	}
+
+	if t.Destination.IsExternal() {
+		return resolved
+	}
+	for _, cp := range t.Destination.Internal.ContainerPorts {
+		if !isProtocolMatch(cp.Protocol, pp.Protocol) {
+			continue
+		}
+		if resolved.Name == "" && int(cp.ContainerPort) == resolved.Number {
+			resolved.Name = cp.Name
+			break
+		} else if resolved.Number == 0 && cp.Name != "" && cp.Name == resolved.Name {
+			resolved.Number = int(cp.ContainerPort)
+			break
+		}
+	}
+	return resolved
+}
+
+// isProtocolMatch handles the kube defaulting of an unspecified container port protocol to TCP
+func isProtocolMatch(containerPortProtocol v1.Protocol, protocol v1.Protocol) bool {
+	if containerPortProtocol == "" {
+		containerPortProtocol = v1.ProtocolTCP
+	}
+	return containerPortProtocol == protocol
 }
@@ -0,0 +1,19 @@
+package netpol
+
+import (
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// PolicyTypes returns the policy types of a network policy.  If there aren't
+// any, it defaults them the way the API server does: Ingress, plus Egress if
+// the policy has egress rules.  Policies read from files often leave them out.
+func PolicyTypes(netpol *networkingv1.NetworkPolicy) []networkingv1.PolicyType {
+	if len(netpol.Spec.PolicyTypes) > 0 {
+		return netpol.Spec.PolicyTypes
+	}
+	policyTypes := []networkingv1.PolicyType{networkingv1.PolicyTypeIngress}
+	if len(netpol.Spec.Egress) > 0 {
+		policyTypes = append(policyTypes, networkingv1.PolicyTypeEgress)
+	}
+	return policyTypes
+}
@@ -122,7 +122,7 @@ func Reduce(policy *networkingv1.NetworkPolicy) Node {
 	nodes := []Node{targetSelector}
 
 	isIngress, isEgress := false, false
-	for _, pType := range policy.Spec.PolicyTypes {
+	for _, pType := range PolicyTypes(policy) {
 		switch pType {
 		case networkingv1.PolicyTypeIngress:
 			isIngress = true
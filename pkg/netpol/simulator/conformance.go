@@ -0,0 +1,223 @@
+package simulator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattfenwick/kube-prototypes/pkg/kube"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/inventory"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/matcher"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+)
+
+// Disagreement is a pair of pods for which simulated and probed reachability differ
+type Disagreement struct {
+	From     netpol.Pod
+	To       netpol.Pod
+	Expected bool
+	Observed bool
+	Result   *matcher.AllowedResult
+}
+
+// Conformance compares simulated with probed reachability for a single port and protocol,
+// and a single family of pod IPs
+type Conformance struct {
+	Port     *matcher.PortProtocol
+	IPFamily v1.IPFamily
+	// PodsWithoutIP are the pods which weren't probed for not having an IP of IPFamily
+	PodsWithoutIP []netpol.Pod
+	Reachability  *netpol.Reachability
+	Disagreements []*Disagreement
+}
+
+// RunConformance fills in Expected reachability from the simulator and Observed
+// reachability from probes, then compares them.  Some details:
+//   - pods which aren't running are skipped
+//   - traffic to a pod which doesn't expose the port can't succeed regardless of
+//     policy, so it's expected to fail and isn't probed
+//   - probes use curl, so non-TCP ports are skipped
+//   - if no ports are given, every container port found is used
+//   - dual-stack pods are probed on both IPs, and each family is compared separately
+func RunConformance(k8s *kube.Kubernetes, policy *matcher.Policy, namespaces []string, ports []*matcher.PortProtocol, timeoutSeconds int) ([]*Conformance, error) {
+	kubeNamespaces, err := k8s.GetNamespaces(namespaces)
+	if err != nil {
+		return nil, err
+	}
+	allPods, err := k8s.GetPodsInNamespaces(namespaces)
+	if err != nil {
+		return nil, err
+	}
+	var pods []v1.Pod
+	for _, pod := range allPods {
+		if pod.Status.Phase != v1.PodRunning {
+			log.Infof("skipping pod %s/%s, phase is %s", pod.Namespace, pod.Name, pod.Status.Phase)
+			continue
+		}
+		pods = append(pods, pod)
+	}
+	nodes, err := k8s.GetAllNodes()
+	if err != nil {
+		return nil, err
+	}
+	inv := inventory.FromKube(kubeNamespaces, pods)
+	inv.AddNodesFromKube(nodes)
+
+	if len(ports) == 0 {
+		ports = Ports(inv)
+	}
+
+	containers := map[netpol.Pod]string{}
+	for _, pod := range pods {
+		containers[netpol.NewPod(pod.Namespace, pod.Name)] = pod.Spec.Containers[0].Name
+	}
+
+	var results []*Conformance
+	for _, port := range ports {
+		if port.Protocol != v1.ProtocolTCP {
+			log.Warnf("skipping port %s: only TCP can be probed", PortProtocolString(port))
+			continue
+		}
+		for _, family := range inv.IPFamilies() {
+			conformance := runConformanceOnPort(k8s, policy, inv.ForIPFamily(family), containers, port, family, timeoutSeconds)
+			conformance.PodsWithoutIP = inv.PodsWithoutIPFamily(family)
+			results = append(results, conformance)
+		}
+	}
+	return results, nil
+}
+
+// runConformanceOnPort probes from the first container of each pod, to the IP of each pod in the inventory
+func runConformanceOnPort(k8s *kube.Kubernetes, policy *matcher.Policy, inv *inventory.Inventory, containers map[netpol.Pod]string, port *matcher.PortProtocol, family v1.IPFamily, timeoutSeconds int) *Conformance {
+	simulation := Simulate(policy, inv, port)
+	reachability := netpol.NewReachability(inv.PodKeys(), false)
+
+	var jobs []*kube.ProbeJob
+	for _, from := range inv.Pods {
+		fromKey := from.Key()
+		for _, to := range inv.Pods {
+			toKey := to.Key()
+			portNumber := exposedPortNumber(inv, to, port)
+			if portNumber == 0 {
+				log.Debugf("not probing %s -> %s: port %s not exposed", fromKey, toKey, PortProtocolString(port))
+				reachability.Expect(fromKey, toKey, false)
+				reachability.Observe(fromKey, toKey, false)
+				continue
+			}
+			reachability.Expect(fromKey, toKey, simulation.Table.Get(string(fromKey), string(toKey)))
+			jobs = append(jobs, &kube.ProbeJob{
+				FromNamespace:  from.Namespace,
+				FromPod:        from.Name,
+				FromContainer:  containers[fromKey],
+				ToAddress:      to.IP,
+				ToPort:         portNumber,
+				TimeoutSeconds: timeoutSeconds,
+				CommandType:    kube.ProbeCommandTypeCurl,
+				FromKey:        string(fromKey),
+				ToKey:          string(toKey),
+			})
+		}
+	}
+
+	if len(jobs) > 0 {
+		table := k8s.ProbeConnectivity(jobs)
+		for _, job := range jobs {
+			reachability.Observe(netpol.Pod(job.FromKey), netpol.Pod(job.ToKey), table.Get(job.FromKey, job.ToKey) == "0")
+		}
+	}
+
+	return &Conformance{
+		Port:          port,
+		IPFamily:      family,
+		Reachability:  reachability,
+		Disagreements: findDisagreements(inv.PodKeys(), reachability, simulation),
+	}
+}
+
+// findDisagreements finds the pairs of pods whose expected and observed reachability differ
+func findDisagreements(pods []netpol.Pod, reachability *netpol.Reachability, simulation *Simulation) []*Disagreement {
+	var disagreements []*Disagreement
+	for _, from := range pods {
+		for _, to := range pods {
+			expected := reachability.Expected.Get(string(from), string(to))
+			observed := reachability.Observed.Get(string(from), string(to))
+			if expected != observed {
+				disagreements = append(disagreements, &Disagreement{
+					From:     from,
+					To:       to,
+					Expected: expected,
+					Observed: observed,
+					Result:   simulation.Results[from][to],
+				})
+			}
+		}
+	}
+	return disagreements
+}
+
+// exposedPortNumber returns the number of the port if the pod has a container
+// listening on it, otherwise 0
+func exposedPortNumber(inv *inventory.Inventory, pod *inventory.Pod, port *matcher.PortProtocol) int {
+	resolved := (&matcher.Traffic{
+		Destination:  matcher.InventoryPeer(inv, pod),
+		PortProtocol: port,
+	}).ResolvePort()
+	for _, cp := range pod.ContainerPorts {
+		protocol := cp.Protocol
+		if protocol == "" {
+			protocol = v1.ProtocolTCP
+		}
+		if int(cp.ContainerPort) == resolved.Number && protocol == resolved.Protocol {
+			return resolved.Number
+		}
+	}
+	return 0
+}
+
+func (c *Conformance) PrintSummary() {
+	fmt.Printf("port %s, %s:\n", PortProtocolString(c.Port), c.IPFamily)
+	if missing := missingPodsDescription(c.IPFamily, c.PodsWithoutIP); missing != "" {
+		fmt.Println(missing)
+	}
+	c.Reachability.PrintSummary(true, true, true)
+	for _, d := range c.Disagreements {
+		fmt.Printf("%s -> %s: expected %s, observed %s\n", d.From, d.To, allowedString(d.Expected), allowedString(d.Observed))
+		fmt.Println(strings.Join(ExplainResult(d.Result), "\n"))
+	}
+}
+
+func allowedString(isAllowed bool) string {
+	if isAllowed {
+		return "allowed"
+	}
+	return "denied"
+}
+
+// ExplainResult describes which targets matched and allowed traffic, in each direction
+func ExplainResult(result *matcher.AllowedResult) []string {
+	if result.IsLoopback {
+		return []string{"  loopback: allowed, a pod can always reach itself"}
+	}
+	if result.IsNodeLocal {
+		return []string{"  node local: allowed, traffic between a pod and its node isn't subject to policies"}
+	}
+	lines := []string{}
+	for _, direction := range []struct {
+		Name   string
+		Result *matcher.DirectionResult
+	}{{"ingress", result.Ingress}, {"egress", result.Egress}} {
+		if len(direction.Result.MatchingTargets) == 0 {
+			lines = append(lines, fmt.Sprintf("  %s: %s, no targets match", direction.Name, allowedString(direction.Result.IsAllowed)))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("  %s: %s", direction.Name, allowedString(direction.Result.IsAllowed)))
+		for _, target := range direction.Result.MatchingTargets {
+			lines = append(lines, fmt.Sprintf("    matching target %s (source rules: %s)", target.GetPrimaryKey(), strings.Join(target.SourceRules, ", ")))
+		}
+		for _, target := range direction.Result.AllowingTargets {
+			lines = append(lines, fmt.Sprintf("    allowing target %s (source rules: %s)", target.GetPrimaryKey(), strings.Join(target.SourceRules, ", ")))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,72 @@
+package simulator
+
+import (
+	"fmt"
+
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/matcher"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func RunConformanceTests() {
+	Describe("findDisagreements", func() {
+		web, db := netpol.NewPod("default", "web"), netpol.NewPod("default", "db")
+
+		It("finds pairs of pods whose expected and observed reachability differ", func() {
+			simulation := Simulate(matcher.BuildNetworkPolicy(allowFromIPv4), diffInventory, diffPorts[0])
+			reachability := netpol.NewReachability(diffInventory.PodKeys(), false)
+			for _, from := range diffInventory.PodKeys() {
+				for _, to := range diffInventory.PodKeys() {
+					reachability.Expect(from, to, simulation.Table.Get(string(from), string(to)))
+					reachability.Observe(from, to, simulation.Table.Get(string(from), string(to)))
+				}
+			}
+			Expect(findDisagreements(diffInventory.PodKeys(), reachability, simulation)).To(BeEmpty())
+
+			reachability.Observe(db, web, true)
+			reachability.Observe(web, db, false)
+			Expect(findDisagreements(diffInventory.PodKeys(), reachability, simulation)).To(Equal([]*Disagreement{
+				{From: web, To: db, Expected: true, Observed: false, Result: simulation.Results[web][db]},
+				{From: db, To: web, Expected: false, Observed: true, Result: simulation.Results[db][web]},
+			}))
+		})
+	})
+
+	Describe("ExplainResult", func() {
+		policy := matcher.BuildNetworkPolicy(allowFromIPv4)
+		var target *matcher.Target
+		for _, t := range policy.Ingress {
+			target = t
+		}
+		simulation := Simulate(policy, diffInventory, diffPorts[0])
+
+		It("explains traffic which no targets match", func() {
+			Expect(ExplainResult(simulation.Results["default/web"]["default/db"])).To(Equal([]string{
+				"  ingress: allowed, no targets match",
+				"  egress: allowed, no targets match",
+			}))
+		})
+
+		It("explains matching and allowing targets", func() {
+			Expect(ExplainResult(simulation.Results["default/db"]["default/web"])).To(Equal([]string{
+				"  ingress: denied",
+				fmt.Sprintf("    matching target %s (source rules: allow-from-ipv4)", target.GetPrimaryKey()),
+				"  egress: allowed, no targets match",
+			}))
+
+			allowed := Simulate(policy, dualStackInventory, diffPorts[0]).Results["default/db"]["default/web"]
+			Expect(ExplainResult(allowed)).To(Equal([]string{
+				"  ingress: allowed",
+				fmt.Sprintf("    matching target %s (source rules: allow-from-ipv4)", target.GetPrimaryKey()),
+				fmt.Sprintf("    allowing target %s (source rules: allow-from-ipv4)", target.GetPrimaryKey()),
+				"  egress: allowed, no targets match",
+			}))
+		})
+
+		It("explains loopback and node local traffic without targets", func() {
+			Expect(ExplainResult(&matcher.AllowedResult{IsLoopback: true})).To(Equal([]string{"  loopback: allowed, a pod can always reach itself"}))
+			Expect(ExplainResult(&matcher.AllowedResult{IsNodeLocal: true})).To(Equal([]string{"  node local: allowed, traffic between a pod and its node isn't subject to policies"}))
+		})
+	})
+}
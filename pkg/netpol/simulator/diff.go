@@ -0,0 +1,127 @@
+package simulator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/inventory"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/matcher"
+	v1 "k8s.io/api/core/v1"
+)
+
+// ReachabilityChange is traffic which is allowed by one set of policies but not the other
+type ReachabilityChange struct {
+	From netpol.Pod
+	To   netpol.Pod
+	Port *matcher.PortProtocol
+	// IPFamily is the family of the pod IPs the traffic goes between, or "" if pods' IPs aren't known
+	IPFamily v1.IPFamily
+	// IngressRules and EgressRules are the source rules responsible for the
+	// change in each direction:
+	//   - newly allowed: the rules now allowing the traffic, or if none, the
+	//     rules which used to isolate the pod
+	//   - newly denied: the rules now isolating the pod
+	IngressRules []string
+	EgressRules  []string
+}
+
+func (rc *ReachabilityChange) String() string {
+	var rules []string
+	if rc.IngressRules != nil {
+		rules = append(rules, fmt.Sprintf("ingress rules [%s]", strings.Join(rc.IngressRules, ", ")))
+	}
+	if rc.EgressRules != nil {
+		rules = append(rules, fmt.Sprintf("egress rules [%s]", strings.Join(rc.EgressRules, ", ")))
+	}
+	port := PortProtocolString(rc.Port)
+	if rc.IPFamily != "" {
+		port = fmt.Sprintf("%s over %s", port, rc.IPFamily)
+	}
+	return fmt.Sprintf("%s -> %s on %s: %s", rc.From, rc.To, port, strings.Join(rules, ", "))
+}
+
+// PolicyDiff is the reachability impact of changing policies
+type PolicyDiff struct {
+	Allowed []*ReachabilityChange
+	Denied  []*ReachabilityChange
+}
+
+// Diff evaluates two sets of policies for every pair of pods in an inventory
+// and every port, and finds the traffic that the change allows or denies.
+// Traffic between dual-stack pods is evaluated for each IP family, since
+// ipBlocks only match IPs of their own family.
+func Diff(before *matcher.Policy, after *matcher.Policy, inv *inventory.Inventory, ports []*matcher.PortProtocol) *PolicyDiff {
+	diff := &PolicyDiff{}
+	for _, port := range ports {
+		for _, from := range inv.Pods {
+			for _, to := range inv.Pods {
+				traffic := &matcher.Traffic{
+					Source:       matcher.InventoryPeer(inv, from),
+					Destination:  matcher.InventoryPeer(inv, to),
+					PortProtocol: port,
+				}
+				families := traffic.IPFamilies()
+				if len(families) == 0 {
+					diff.add(before, after, traffic, from.Key(), to.Key(), "")
+				}
+				for _, family := range families {
+					diff.add(before, after, traffic.ForIPFamily(family), from.Key(), to.Key(), family)
+				}
+			}
+		}
+	}
+	return diff
+}
+
+func (pd *PolicyDiff) add(before *matcher.Policy, after *matcher.Policy, traffic *matcher.Traffic, from netpol.Pod, to netpol.Pod, family v1.IPFamily) {
+	beforeResult := before.IsTrafficAllowed(traffic)
+	afterResult := after.IsTrafficAllowed(traffic)
+	if beforeResult.IsAllowed() == afterResult.IsAllowed() {
+		return
+	}
+	change := &ReachabilityChange{
+		From:         from,
+		To:           to,
+		Port:         traffic.PortProtocol,
+		IPFamily:     family,
+		IngressRules: responsibleRules(beforeResult.Ingress, afterResult.Ingress),
+		EgressRules:  responsibleRules(beforeResult.Egress, afterResult.Egress),
+	}
+	if afterResult.IsAllowed() {
+		pd.Allowed = append(pd.Allowed, change)
+	} else {
+		pd.Denied = append(pd.Denied, change)
+	}
+}
+
+// responsibleRules finds the source rules responsible for a change in a single
+// direction, or nil if that direction didn't change
+func responsibleRules(before *matcher.DirectionResult, after *matcher.DirectionResult) []string {
+	switch {
+	case before.IsAllowed == after.IsAllowed:
+		return nil
+	case after.IsAllowed && len(after.AllowingTargets) > 0:
+		return sourceRules(after.AllowingTargets)
+	case after.IsAllowed:
+		return sourceRules(before.MatchingTargets)
+	default:
+		return sourceRules(after.MatchingTargets)
+	}
+}
+
+func sourceRules(targets []*matcher.Target) []string {
+	found := map[string]bool{}
+	rules := []string{}
+	for _, target := range targets {
+		for _, rule := range target.SourceRules {
+			if !found[rule] {
+				found[rule] = true
+				rules = append(rules, rule)
+			}
+		}
+	}
+	sort.Strings(rules)
+	return rules
+}
@@ -0,0 +1,94 @@
+package simulator
+
+import (
+	"github.com/mattfenwick/kube-prototypes/pkg/kube/netpol/examples"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/inventory"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/matcher"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+var diffInventory = &inventory.Inventory{
+	Namespaces: []*inventory.Namespace{{Name: "default"}},
+	Pods: []*inventory.Pod{
+		{Namespace: "default", Name: "web", Labels: map[string]string{"app": "web"}},
+		{Namespace: "default", Name: "db", Labels: map[string]string{"app": "db"}},
+	},
+}
+
+var diffPorts = []*matcher.PortProtocol{{Protocol: v1.ProtocolTCP, Port: intstr.FromInt(80)}}
+
+func RunDiffTests() {
+	Describe("Diff", func() {
+		web := map[string]string{"app": "web"}
+
+		It("names the policies newly denying traffic", func() {
+			diff := Diff(
+				matcher.BuildNetworkPolicies(nil),
+				matcher.BuildNetworkPolicies([]*networkingv1.NetworkPolicy{examples.AllowNothingTo("default", web)}),
+				diffInventory, diffPorts)
+
+			Expect(diff.Allowed).To(BeEmpty())
+			Expect(diff.Denied).To(Equal([]*ReachabilityChange{
+				{From: netpol.NewPod("default", "web"), To: netpol.NewPod("default", "web"), Port: diffPorts[0], IngressRules: []string{"allow-nothing-to-app-web"}},
+				{From: netpol.NewPod("default", "db"), To: netpol.NewPod("default", "web"), Port: diffPorts[0], IngressRules: []string{"allow-nothing-to-app-web"}},
+			}))
+		})
+
+		It("names the policies newly allowing traffic", func() {
+			before := []*networkingv1.NetworkPolicy{examples.AllowNothingTo("default", web)}
+			diff := Diff(
+				matcher.BuildNetworkPolicies(before),
+				matcher.BuildNetworkPolicies(append(before, examples.AllowFromTo("default", map[string]string{"app": "db"}, web))),
+				diffInventory, diffPorts)
+
+			Expect(diff.Denied).To(BeEmpty())
+			Expect(diff.Allowed).To(HaveLen(1))
+			Expect(diff.Allowed[0].From).To(Equal(netpol.NewPod("default", "db")))
+			// both policies select app=web, so they're combined into a single target
+			Expect(diff.Allowed[0].IngressRules).To(Equal([]string{"allow-from-app-db-to-app-web", "allow-nothing-to-app-web"}))
+			Expect(diff.Allowed[0].EgressRules).To(BeNil())
+		})
+
+		It("names the policies which used to isolate newly allowed traffic", func() {
+			diff := Diff(
+				matcher.BuildNetworkPolicies([]*networkingv1.NetworkPolicy{examples.AllowNothingTo("default", web)}),
+				matcher.BuildNetworkPolicies(nil),
+				diffInventory, diffPorts)
+
+			Expect(diff.Allowed).To(HaveLen(2))
+			Expect(diff.Allowed[0].IngressRules).To(Equal([]string{"allow-nothing-to-app-web"}))
+		})
+
+		It("evaluates traffic between dual-stack pods for each IP family", func() {
+			dualStack := &inventory.Inventory{
+				Namespaces: []*inventory.Namespace{{Name: "default"}},
+				Pods: []*inventory.Pod{
+					{Namespace: "default", Name: "web", Labels: web, IP: "10.0.0.1", IPs: []string{"10.0.0.1", "fd00::1"}},
+					{Namespace: "default", Name: "db", Labels: map[string]string{"app": "db"}, IP: "10.0.0.2", IPs: []string{"10.0.0.2", "fd00::2"}},
+				},
+			}
+			fromIPv6 := &networkingv1.NetworkPolicy{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "allow-ipv6-to-web"},
+				Spec: networkingv1.NetworkPolicySpec{
+					PodSelector: metav1.LabelSelector{MatchLabels: web},
+					Ingress:     []networkingv1.NetworkPolicyIngressRule{{From: []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: "fd00::/64"}}}}},
+				},
+			}
+			before := []*networkingv1.NetworkPolicy{examples.AllowNothingTo("default", web)}
+			diff := Diff(matcher.BuildNetworkPolicies(before), matcher.BuildNetworkPolicies(append(before, fromIPv6)), dualStack, diffPorts)
+
+			Expect(diff.Denied).To(BeEmpty())
+			Expect(diff.Allowed).To(HaveLen(2))
+			for _, change := range diff.Allowed {
+				Expect(change.IPFamily).To(Equal(v1.IPv6Protocol))
+			}
+			Expect(diff.Allowed[0].String()).To(Equal("default/web -> default/web on 80/TCP over IPv6: ingress rules [allow-ipv6-to-web, allow-nothing-to-app-web]"))
+		})
+	})
+}
@@ -0,0 +1,17 @@
+package simulator
+
+import (
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/matcher"
+	"github.com/spf13/cobra"
+)
+
+// AddCNIProfileFlags adds flags for each field of a CNIProfile to a command, and
+// returns the profile which they fill in.  Flags default to matcher.DefaultCNIProfile,
+// so commands simulate the same network plugin as the library does.
+func AddCNIProfileFlags(command *cobra.Command) *matcher.CNIProfile {
+	profile := *matcher.DefaultCNIProfile
+	command.Flags().BoolVar(&profile.IPBlocksMatchPods, "ipblocks-match-pods", profile.IPBlocksMatchPods, "whether ipBlocks match pods by their IPs; network plugins differ on this")
+	command.Flags().BoolVar(&profile.AllowLoopback, "allow-loopback", profile.AllowLoopback, "whether pods can always reach themselves, regardless of policies; many network plugins allow this, but the library's default profile doesn't")
+	command.Flags().BoolVar(&profile.PoliciesApplyToNodeTraffic, "policies-apply-to-node-traffic", profile.PoliciesApplyToNodeTraffic, "whether policies apply to traffic between pods and their nodes, such as kubelet health checks")
+	return &profile
+}
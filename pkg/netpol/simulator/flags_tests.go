@@ -0,0 +1,25 @@
+package simulator
+
+import (
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/matcher"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/cobra"
+)
+
+func RunFlagsTests() {
+	Describe("AddCNIProfileFlags", func() {
+		It("defaults to the library's CNI profile", func() {
+			profile := AddCNIProfileFlags(&cobra.Command{})
+			Expect(profile).To(Equal(matcher.DefaultCNIProfile))
+		})
+
+		It("fills in a copy of the default profile from flags", func() {
+			command := &cobra.Command{}
+			profile := AddCNIProfileFlags(command)
+			Expect(command.Flags().Parse([]string{"--allow-loopback", "--ipblocks-match-pods=false"})).To(Succeed())
+			Expect(profile).To(Equal(&matcher.CNIProfile{IPBlocksMatchPods: false, AllowLoopback: true, PoliciesApplyToNodeTraffic: false}))
+			Expect(matcher.DefaultCNIProfile.AllowLoopback).To(BeFalse())
+		})
+	})
+}
@@ -0,0 +1,63 @@
+package simulator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/inventory"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/matcher"
+	v1 "k8s.io/api/core/v1"
+)
+
+// BlockedLivenessProbe is a liveness probe which policies keep the kubelet from reaching
+type BlockedLivenessProbe struct {
+	Pod    netpol.Pod
+	Port   *matcher.PortProtocol
+	Result *matcher.AllowedResult
+}
+
+func (b *BlockedLivenessProbe) String() string {
+	return fmt.Sprintf("liveness probe of %s on port %s is blocked by %s",
+		b.Pod, PortProtocolString(b.Port), strings.Join(sourceRules(b.Result.Ingress.MatchingTargets), ", "))
+}
+
+// CheckLivenessProbes simulates the kubelet probing the liveness of each pod
+// from the pod's node, and finds the probes which policies would block -- which
+// can only happen if the CNIProfile applies policies to traffic from nodes.
+// Those pods would be restarted over and over.
+func CheckLivenessProbes(policy *matcher.Policy, inv *inventory.Inventory) []*BlockedLivenessProbe {
+	// the kubelet always probes from the pod's own node, so its traffic is node
+	// local even if the inventory doesn't know which node that is
+	if !policy.GetCNIProfile().PoliciesApplyToNodeTraffic {
+		return nil
+	}
+	var blocked []*BlockedLivenessProbe
+	for _, pod := range inv.Pods {
+		if pod.HostNetwork {
+			continue
+		}
+		kubelet := &matcher.TrafficPeer{
+			Host: &matcher.HostPeer{
+				NodeLabels: inv.NodeLabels(pod.Node),
+				Node:       pod.Node,
+			},
+		}
+		if node := inv.Node(pod.Node); node != nil && len(node.IPs) > 0 {
+			kubelet.IP = node.IPs[0]
+			kubelet.IPs = node.IPs
+		}
+		for _, probePort := range pod.LivenessProbePorts {
+			port := &matcher.PortProtocol{Protocol: v1.ProtocolTCP, Port: probePort}
+			result := policy.IsTrafficAllowed(&matcher.Traffic{
+				Source:       kubelet,
+				Destination:  matcher.InventoryPeer(inv, pod),
+				PortProtocol: port,
+			})
+			if !result.IsAllowed() {
+				blocked = append(blocked, &BlockedLivenessProbe{Pod: pod.Key(), Port: port, Result: result})
+			}
+		}
+	}
+	return blocked
+}
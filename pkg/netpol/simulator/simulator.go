@@ -0,0 +1,167 @@
+package simulator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/inventory"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/matcher"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Simulation is the expected reachability between every pair of pods in an
+// inventory, for traffic on a single port and protocol
+type Simulation struct {
+	Port *matcher.PortProtocol
+	// IPFamily is the family of the pod IPs used, or "" if pods' IPs aren't known
+	IPFamily v1.IPFamily
+	// PodsWithoutIP are the pods left out of Table for not having an IP of IPFamily
+	PodsWithoutIP []netpol.Pod
+	Table         *netpol.TruthTable
+	Results       map[netpol.Pod]map[netpol.Pod]*matcher.AllowedResult
+}
+
+// Simulate runs IsTrafficAllowed for every (source, destination) pair of pods
+func Simulate(policy *matcher.Policy, inv *inventory.Inventory, port *matcher.PortProtocol) *Simulation {
+	var items []string
+	for _, key := range inv.PodKeys() {
+		items = append(items, string(key))
+	}
+	simulation := &Simulation{
+		Port:    port,
+		Table:   netpol.NewTruthTable(items, nil),
+		Results: map[netpol.Pod]map[netpol.Pod]*matcher.AllowedResult{},
+	}
+	for _, from := range inv.Pods {
+		simulation.Results[from.Key()] = map[netpol.Pod]*matcher.AllowedResult{}
+		for _, to := range inv.Pods {
+			result := policy.IsTrafficAllowed(&matcher.Traffic{
+				Source:       matcher.InventoryPeer(inv, from),
+				Destination:  matcher.InventoryPeer(inv, to),
+				PortProtocol: port,
+			})
+			simulation.Results[from.Key()][to.Key()] = result
+			simulation.Table.Set(string(from.Key()), string(to.Key()), result.IsAllowed())
+		}
+	}
+	return simulation
+}
+
+// SimulateIPFamily runs a Simulation of traffic between the pods with an IP of a
+// family, using those IPs.  Policies may treat IPv4 and IPv6 traffic between the
+// same pods differently, since IPBlocks only match IPs of their own family.
+// Pods without an IP of the family are listed in PodsWithoutIP instead.
+func SimulateIPFamily(policy *matcher.Policy, inv *inventory.Inventory, port *matcher.PortProtocol, family v1.IPFamily) *Simulation {
+	simulation := Simulate(policy, inv.ForIPFamily(family), port)
+	simulation.IPFamily = family
+	simulation.PodsWithoutIP = inv.PodsWithoutIPFamily(family)
+	return simulation
+}
+
+// SimulatePorts runs a Simulation for each port, and each family of the pods'
+// IPs -- or if pods' IPs aren't known, just once for each port
+func SimulatePorts(policy *matcher.Policy, inv *inventory.Inventory, ports []*matcher.PortProtocol) []*Simulation {
+	if missing := podsWithoutIPs(inv); len(missing) > 0 {
+		log.Warnf("pods without IPs can't be matched by ipBlocks, or simulated for an IP family: %s", strings.Join(missing, ", "))
+	}
+	families := inv.IPFamilies()
+	var simulations []*Simulation
+	for _, port := range ports {
+		if len(families) == 0 {
+			simulations = append(simulations, Simulate(policy, inv, port))
+		}
+		for _, family := range families {
+			simulations = append(simulations, SimulateIPFamily(policy, inv, port, family))
+		}
+	}
+	return simulations
+}
+
+func podsWithoutIPs(inv *inventory.Inventory) []string {
+	var pods []string
+	for _, pod := range inv.Pods {
+		if len(pod.AllIPs()) == 0 {
+			pods = append(pods, string(pod.Key()))
+		}
+	}
+	return pods
+}
+
+// Description names the port and IP family of a Simulation
+func (s *Simulation) Description() string {
+	if s.IPFamily == "" {
+		return fmt.Sprintf("port %s", PortProtocolString(s.Port))
+	}
+	return fmt.Sprintf("port %s, %s", PortProtocolString(s.Port), s.IPFamily)
+}
+
+// MissingPodsDescription explains which pods were left out of the Simulation, or
+// is "" if none were
+func (s *Simulation) MissingPodsDescription() string {
+	return missingPodsDescription(s.IPFamily, s.PodsWithoutIP)
+}
+
+func missingPodsDescription(family v1.IPFamily, pods []netpol.Pod) string {
+	if len(pods) == 0 {
+		return ""
+	}
+	var keys []string
+	for _, pod := range pods {
+		keys = append(keys, string(pod))
+	}
+	return fmt.Sprintf("not simulated, no %s IP: %s", family, strings.Join(keys, ", "))
+}
+
+// Ports finds every distinct numbered port and protocol exposed by a container in the inventory
+func Ports(inv *inventory.Inventory) []*matcher.PortProtocol {
+	found := map[string]*matcher.PortProtocol{}
+	for _, pod := range inv.Pods {
+		for _, cp := range pod.ContainerPorts {
+			protocol := cp.Protocol
+			if protocol == "" {
+				protocol = v1.ProtocolTCP
+			}
+			port := &matcher.PortProtocol{Protocol: protocol, Port: intstr.FromInt(int(cp.ContainerPort))}
+			found[PortProtocolString(port)] = port
+		}
+	}
+	var ports []*matcher.PortProtocol
+	for _, port := range found {
+		ports = append(ports, port)
+	}
+	sort.Slice(ports, func(i, j int) bool {
+		if ports[i].Port.IntVal != ports[j].Port.IntVal {
+			return ports[i].Port.IntVal < ports[j].Port.IntVal
+		}
+		return ports[i].Protocol < ports[j].Protocol
+	})
+	return ports
+}
+
+func PortProtocolString(pp *matcher.PortProtocol) string {
+	return fmt.Sprintf("%s/%s", pp.Port.String(), pp.Protocol)
+}
+
+// ParsePortProtocol parses strings of the form '80', '53/UDP' or 'http/TCP'.
+// The protocol defaults to TCP.
+func ParsePortProtocol(s string) (*matcher.PortProtocol, error) {
+	pieces := strings.Split(s, "/")
+	if len(pieces) > 2 || pieces[0] == "" {
+		return nil, errors.Errorf("invalid port/protocol '%s'", s)
+	}
+	protocol := v1.ProtocolTCP
+	if len(pieces) == 2 {
+		protocol = v1.Protocol(strings.ToUpper(pieces[1]))
+		switch protocol {
+		case v1.ProtocolTCP, v1.ProtocolUDP, v1.ProtocolSCTP:
+		default:
+			return nil, errors.Errorf("invalid protocol '%s' in '%s'", pieces[1], s)
+		}
+	}
+	return &matcher.PortProtocol{Protocol: protocol, Port: intstr.Parse(pieces[0])}, nil
+}
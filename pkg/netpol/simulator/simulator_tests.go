@@ -0,0 +1,267 @@
+package simulator
+
+import (
+	"encoding/json"
+
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/inventory"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/matcher"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+var dualStackInventory = &inventory.Inventory{
+	Namespaces: []*inventory.Namespace{{Name: "default"}},
+	Pods: []*inventory.Pod{
+		{Namespace: "default", Name: "web", Labels: map[string]string{"app": "web"}, IP: "10.0.0.1", IPs: []string{"10.0.0.1", "fd00::1"}},
+		{Namespace: "default", Name: "db", Labels: map[string]string{"app": "db"}, IP: "10.0.0.2", IPs: []string{"10.0.0.2", "fd00::2"}},
+		{Namespace: "default", Name: "legacy", Labels: map[string]string{"app": "legacy"}, IP: "10.0.0.3"},
+	},
+}
+
+// allowFromIPv4 isolates app=web, allowing ingress only from 10.0.0.0/8
+var allowFromIPv4 = &networkingv1.NetworkPolicy{
+	ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "allow-from-ipv4"},
+	Spec: networkingv1.NetworkPolicySpec{
+		PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		Ingress: []networkingv1.NetworkPolicyIngressRule{{
+			From: []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/8"}}},
+		}},
+		PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+	},
+}
+
+func RunSimulatorTests() {
+	Describe("Simulate", func() {
+		It("computes reachability between every pair of pods", func() {
+			simulation := Simulate(matcher.BuildNetworkPolicy(allowFromIPv4), dualStackInventory, diffPorts[0])
+
+			Expect(simulation.IPFamily).To(BeEmpty())
+			Expect(simulation.Table.Items).To(Equal([]string{"default/web", "default/db", "default/legacy"}))
+			Expect(simulation.Results).To(HaveLen(3))
+			for _, from := range simulation.Table.Items {
+				Expect(simulation.Results[netpol.Pod(from)]).To(HaveLen(3))
+				Expect(simulation.Table.Get(from, "default/db")).To(BeTrue())
+				Expect(simulation.Table.Get(from, "default/legacy")).To(BeTrue())
+			}
+			Expect(simulation.Table.Get("default/db", "default/web")).To(BeTrue())
+			Expect(simulation.Results["default/db"]["default/web"].Ingress.AllowingTargets).ToNot(BeEmpty())
+		})
+	})
+
+	Describe("SimulatePorts", func() {
+		It("simulates each IP family of dual-stack pods separately", func() {
+			Expect(dualStackInventory.Validate()).To(Succeed())
+			policy := matcher.BuildNetworkPolicy(allowFromIPv4)
+			simulations := SimulatePorts(policy, dualStackInventory, diffPorts)
+
+			Expect(simulations).To(HaveLen(2))
+
+			ipv4 := simulations[0]
+			Expect(ipv4.IPFamily).To(Equal(v1.IPv4Protocol))
+			Expect(ipv4.Description()).To(Equal("port 80/TCP, IPv4"))
+			Expect(ipv4.Table.Items).To(Equal([]string{"default/web", "default/db", "default/legacy"}))
+			Expect(ipv4.Table.Get("default/db", "default/web")).To(BeTrue())
+			Expect(ipv4.Table.Get("default/legacy", "default/web")).To(BeTrue())
+
+			ipv6 := simulations[1]
+			Expect(ipv6.IPFamily).To(Equal(v1.IPv6Protocol))
+			Expect(ipv4.PodsWithoutIP).To(BeEmpty())
+			Expect(ipv4.MissingPodsDescription()).To(BeEmpty())
+			Expect(ipv6.Table.Items).To(Equal([]string{"default/web", "default/db"}))
+			Expect(ipv6.PodsWithoutIP).To(Equal([]netpol.Pod{"default/legacy"}))
+			Expect(ipv6.MissingPodsDescription()).To(Equal("not simulated, no IPv6 IP: default/legacy"))
+			Expect(ipv6.Table.Get("default/db", "default/web")).To(BeFalse())
+			Expect(ipv6.Table.Get("default/web", "default/db")).To(BeTrue())
+		})
+
+		It("reports pods without an IP of a family, whatever the CNI profile", func() {
+			policy := matcher.BuildNetworkPolicy(allowFromIPv4)
+			policy.CNIProfile = &matcher.CNIProfile{IPBlocksMatchPods: false}
+			ipv6 := SimulatePorts(policy, dualStackInventory, diffPorts)[1]
+
+			Expect(ipv6.IPFamily).To(Equal(v1.IPv6Protocol))
+			Expect(ipv6.PodsWithoutIP).To(Equal([]netpol.Pod{"default/legacy"}))
+		})
+
+		It("simulates once if pod IPs aren't known", func() {
+			simulations := SimulatePorts(matcher.BuildNetworkPolicy(allowFromIPv4), diffInventory, diffPorts)
+
+			Expect(simulations).To(HaveLen(1))
+			Expect(simulations[0].IPFamily).To(BeEmpty())
+			Expect(simulations[0].Description()).To(Equal("port 80/TCP"))
+			Expect(simulations[0].PodsWithoutIP).To(BeEmpty())
+			Expect(simulations[0].Table.Get("default/db", "default/web")).To(BeFalse())
+		})
+
+		It("lets pods reach themselves if the CNI profile allows loopback", func() {
+			policy := matcher.BuildNetworkPolicy(allowFromIPv4)
+			Expect(SimulatePorts(policy, diffInventory, diffPorts)[0].Table.Get("default/web", "default/web")).To(BeFalse())
+
+			policy.CNIProfile = &matcher.CNIProfile{IPBlocksMatchPods: true, AllowLoopback: true}
+			simulation := SimulatePorts(policy, diffInventory, diffPorts)[0]
+			Expect(simulation.Table.Get("default/web", "default/web")).To(BeTrue())
+			Expect(simulation.Results["default/web"]["default/web"].IsLoopback).To(BeTrue())
+			Expect(simulation.Table.Get("default/db", "default/web")).To(BeFalse())
+		})
+	})
+
+	Describe("CheckLivenessProbes", func() {
+		inv := &inventory.Inventory{
+			Namespaces: []*inventory.Namespace{{Name: "default"}},
+			Nodes:      []*inventory.Node{{Name: "node-1", IPs: []string{"192.168.0.1"}}},
+			Pods: []*inventory.Pod{
+				{Namespace: "default", Name: "web", Labels: map[string]string{"app": "web"}, Node: "node-1", IP: "10.0.0.1",
+					ContainerPorts:     []v1.ContainerPort{{Name: "health", ContainerPort: 8080}},
+					LivenessProbePorts: []intstr.IntOrString{intstr.FromString("health")}},
+				{Namespace: "default", Name: "db", Labels: map[string]string{"app": "db"}, Node: "node-1", IP: "10.0.0.2",
+					LivenessProbePorts: []intstr.IntOrString{intstr.FromInt(5432)}},
+				{Namespace: "default", Name: "agent", Node: "node-1", IP: "192.168.0.1", HostNetwork: true},
+			},
+		}
+
+		It("doesn't find blocked probes if policies don't apply to node traffic", func() {
+			Expect(inv.Validate()).To(Succeed())
+			Expect(CheckLivenessProbes(matcher.BuildNetworkPolicy(allowFromIPv4), inv)).To(BeEmpty())
+		})
+
+		It("finds probes to isolated pods if policies apply to node traffic", func() {
+			policy := matcher.BuildNetworkPolicy(allowFromIPv4)
+			policy.CNIProfile = &matcher.CNIProfile{IPBlocksMatchPods: true, PoliciesApplyToNodeTraffic: true}
+			blocked := CheckLivenessProbes(policy, inv)
+
+			Expect(blocked).To(HaveLen(1))
+			Expect(blocked[0].Pod).To(Equal(netpol.NewPod("default", "web")))
+			Expect(blocked[0].String()).To(Equal("liveness probe of default/web on port health/TCP is blocked by allow-from-ipv4"))
+		})
+
+		It("doesn't find blocked probes of pods whose node isn't known, unless policies apply to node traffic", func() {
+			withoutNodes := &inventory.Inventory{
+				Namespaces: []*inventory.Namespace{{Name: "default"}},
+				Pods: []*inventory.Pod{
+					{Namespace: "default", Name: "web", Labels: map[string]string{"app": "web"}, IP: "10.0.0.1",
+						ContainerPorts:     []v1.ContainerPort{{Name: "health", ContainerPort: 8080}},
+						LivenessProbePorts: []intstr.IntOrString{intstr.FromString("health")}},
+				},
+			}
+			Expect(withoutNodes.Validate()).To(Succeed())
+			policy := matcher.BuildNetworkPolicy(allowFromIPv4)
+			Expect(CheckLivenessProbes(policy, withoutNodes)).To(BeEmpty())
+
+			policy.CNIProfile = &matcher.CNIProfile{IPBlocksMatchPods: true, PoliciesApplyToNodeTraffic: true}
+			blocked := CheckLivenessProbes(policy, withoutNodes)
+			Expect(blocked).To(HaveLen(1))
+			Expect(blocked[0].Pod).To(Equal(netpol.NewPod("default", "web")))
+		})
+
+		It("treats hostNetwork pods as their node", func() {
+			policy := matcher.BuildNetworkPolicy(allowFromIPv4)
+			policy.CNIProfile = &matcher.CNIProfile{IPBlocksMatchPods: true, PoliciesApplyToNodeTraffic: true}
+			simulation := Simulate(policy, inv, diffPorts[0])
+
+			Expect(simulation.Results["default/agent"]["default/web"].Traffic.Source.IsHost()).To(BeTrue())
+			Expect(simulation.Table.Get("default/agent", "default/web")).To(BeFalse())
+			Expect(simulation.Table.Get("default/db", "default/web")).To(BeTrue())
+		})
+	})
+
+	Describe("Inventory", func() {
+		It("rejects more than one IP of a family", func() {
+			inv := &inventory.Inventory{
+				Namespaces: []*inventory.Namespace{{Name: "default"}},
+				Pods:       []*inventory.Pod{{Namespace: "default", Name: "web", IPs: []string{"10.0.0.1", "10.0.0.2"}}},
+			}
+			Expect(inv.Validate()).ToNot(Succeed())
+		})
+
+		It("rejects pods on unknown nodes", func() {
+			inv := &inventory.Inventory{
+				Namespaces: []*inventory.Namespace{{Name: "default"}},
+				Nodes:      []*inventory.Node{{Name: "node-1"}},
+				Pods:       []*inventory.Pod{{Namespace: "default", Name: "web", Node: "node-2"}},
+			}
+			Expect(inv.Validate()).ToNot(Succeed())
+		})
+	})
+
+	Describe("Results", func() {
+		It("include the pods and nodes of the traffic", func() {
+			inv := &inventory.Inventory{
+				Namespaces: []*inventory.Namespace{{Name: "default"}},
+				Nodes:      []*inventory.Node{{Name: "node-1", Labels: map[string]string{"zone": "a"}}},
+				Pods: []*inventory.Pod{
+					{Namespace: "default", Name: "web", Node: "node-1"},
+					{Namespace: "default", Name: "db"},
+				},
+			}
+			Expect(inv.Validate()).To(Succeed())
+			simulation := Simulate(matcher.BuildNetworkPolicy(allowFromIPv4), inv, diffPorts[0])
+
+			result := simulation.Results["default/web"]["default/db"]
+			Expect(result.Traffic.Source.Internal.Pod).To(Equal("web"))
+			Expect(result.Traffic.Source.Internal.Node).To(Equal("node-1"))
+			Expect(result.Traffic.Source.Internal.NodeLabels).To(Equal(map[string]string{"zone": "a"}))
+			Expect(result.Traffic.Destination.Internal.Pod).To(Equal("db"))
+			Expect(result.Traffic.Destination.Internal.Node).To(BeEmpty())
+
+			bytes, err := json.Marshal(result)
+			Expect(err).To(Succeed())
+			Expect(string(bytes)).To(ContainSubstring(`"Pod":"web","NamespaceLabels":{"kubernetes.io/metadata.name":"default"},"Namespace":"default","NodeLabels":{"zone":"a"},"Node":"node-1"`))
+		})
+	})
+}
+
+func RunPortTests() {
+	Describe("Ports", func() {
+		It("finds each distinct port and protocol, defaulting to TCP", func() {
+			inv := &inventory.Inventory{
+				Namespaces: []*inventory.Namespace{{Name: "default"}},
+				Pods: []*inventory.Pod{
+					{Namespace: "default", Name: "web", ContainerPorts: []v1.ContainerPort{
+						{Name: "http", ContainerPort: 80},
+						{ContainerPort: 53, Protocol: v1.ProtocolUDP},
+					}},
+					{Namespace: "default", Name: "db", ContainerPorts: []v1.ContainerPort{
+						{ContainerPort: 80, Protocol: v1.ProtocolTCP},
+						{ContainerPort: 53, Protocol: v1.ProtocolTCP},
+					}},
+				},
+			}
+			var ports []string
+			for _, port := range Ports(inv) {
+				ports = append(ports, PortProtocolString(port))
+			}
+			Expect(ports).To(Equal([]string{"53/TCP", "53/UDP", "80/TCP"}))
+		})
+
+		It("finds no ports in an inventory without container ports", func() {
+			Expect(Ports(diffInventory)).To(BeEmpty())
+		})
+	})
+
+	Describe("ParsePortProtocol", func() {
+		It("defaults to TCP", func() {
+			Expect(ParsePortProtocol("80")).To(Equal(&matcher.PortProtocol{Protocol: v1.ProtocolTCP, Port: intstr.FromInt(80)}))
+		})
+
+		It("parses protocols case-insensitively", func() {
+			Expect(ParsePortProtocol("53/udp")).To(Equal(&matcher.PortProtocol{Protocol: v1.ProtocolUDP, Port: intstr.FromInt(53)}))
+			Expect(ParsePortProtocol("9000/SCTP")).To(Equal(&matcher.PortProtocol{Protocol: v1.ProtocolSCTP, Port: intstr.FromInt(9000)}))
+		})
+
+		It("parses named ports", func() {
+			Expect(ParsePortProtocol("http/TCP")).To(Equal(&matcher.PortProtocol{Protocol: v1.ProtocolTCP, Port: intstr.FromString("http")}))
+		})
+
+		It("rejects invalid ports and protocols", func() {
+			for _, s := range []string{"", "/TCP", "80/TCP/UDP", "80/ICMP"} {
+				_, err := ParsePortProtocol(s)
+				Expect(err).ToNot(Succeed(), s)
+			}
+		})
+	})
+}
@@ -0,0 +1,18 @@
+package simulator
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestModel(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunDiffTests()
+	RunSimulatorTests()
+	RunPortTests()
+	RunConformanceTests()
+	RunFlagsTests()
+	RunSpecs(t, "network policy simulator suite")
+}
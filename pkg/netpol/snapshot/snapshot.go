@@ -0,0 +1,143 @@
+package snapshot
+
+import (
+	"io/ioutil"
+
+	"github.com/mattfenwick/kube-prototypes/pkg/kube"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/crd"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/inventory"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/matcher"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/validation"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Version is the snapshot format which this package reads and writes
+const Version = "v1"
+
+// Snapshot is everything needed to evaluate a cluster's network policies
+// offline: its namespaces, nodes, pods, services and workloads, plus its
+// NetworkPolicies.
+type Snapshot struct {
+	Version         string                        `json:"version"`
+	Inventory       *inventory.Inventory          `json:"inventory"`
+	NetworkPolicies []*networkingv1.NetworkPolicy `json:"networkPolicies,omitempty"`
+}
+
+// Capture takes a snapshot of some namespaces of a cluster, or of every
+// namespace if none are given.  Nodes are always captured.
+func Capture(k8s *kube.Kubernetes, namespaces []string) (*Snapshot, error) {
+	var kubeNamespaces []v1.Namespace
+	var err error
+	if len(namespaces) == 0 {
+		kubeNamespaces, err = k8s.GetAllNamespaces()
+		for _, ns := range kubeNamespaces {
+			namespaces = append(namespaces, ns.Name)
+		}
+	} else {
+		kubeNamespaces, err = k8s.GetNamespaces(namespaces)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pods, err := k8s.GetPodsInNamespaces(namespaces)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := k8s.GetAllNodes()
+	if err != nil {
+		return nil, err
+	}
+	services, err := k8s.GetServicesInNamespaces(namespaces)
+	if err != nil {
+		return nil, err
+	}
+	deployments, err := k8s.GetDeploymentsInNamespaces(namespaces)
+	if err != nil {
+		return nil, err
+	}
+	statefulSets, err := k8s.GetStatefulSetsInNamespaces(namespaces)
+	if err != nil {
+		return nil, err
+	}
+	daemonSets, err := k8s.GetDaemonSetsInNamespaces(namespaces)
+	if err != nil {
+		return nil, err
+	}
+	netpols, err := k8s.GetNetworkPoliciesInNamespaces(namespaces)
+	if err != nil {
+		return nil, err
+	}
+	for _, netpol := range netpols {
+		// bookkeeping which doesn't matter offline, and bloats snapshots
+		netpol.ManagedFields = nil
+	}
+
+	inv := inventory.FromKube(kubeNamespaces, pods)
+	inv.AddNodesFromKube(nodes)
+	inv.AddServicesFromKube(services)
+	inv.AddWorkloadsFromKube(deployments, statefulSets, daemonSets)
+	return &Snapshot{Version: Version, Inventory: inv, NetworkPolicies: netpols}, nil
+}
+
+// ReadFile reads a Snapshot from a yaml or json file, and validates it
+func ReadFile(path string) (*Snapshot, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read snapshot file %s", path)
+	}
+	snapshot := &Snapshot{}
+	err = yaml.UnmarshalStrict(bytes, snapshot)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to unmarshal snapshot file %s", path)
+	}
+	return snapshot, errors.Wrapf(snapshot.Validate(), "invalid snapshot file %s", path)
+}
+
+// Validate checks the version, the inventory, and that policies are valid and
+// in namespaces of the inventory
+func (s *Snapshot) Validate() error {
+	if s.Version != Version {
+		return errors.Errorf("unsupported snapshot version '%s', expected '%s'", s.Version, Version)
+	}
+	if s.Inventory == nil {
+		return errors.Errorf("missing inventory")
+	}
+	if err := s.Inventory.Validate(); err != nil {
+		return err
+	}
+	for _, netpol := range s.NetworkPolicies {
+		if s.Inventory.Namespace(netpol.Namespace) == nil {
+			return errors.Errorf("namespace %s of network policy %s not found", netpol.Namespace, netpol.Name)
+		}
+	}
+	return validation.ValidateNetworkPolicies(s.NetworkPolicies)
+}
+
+// WriteFile writes a Snapshot as yaml
+func (s *Snapshot) WriteFile(path string) error {
+	bytes, err := yaml.Marshal(s)
+	if err != nil {
+		return errors.Wrapf(err, "unable to marshal snapshot")
+	}
+	return errors.Wrapf(ioutil.WriteFile(path, bytes, 0644), "unable to write snapshot file %s", path)
+}
+
+// MatcherPolicy builds the snapshot's policies for the matcher
+func (s *Snapshot) MatcherPolicy() *matcher.Policy {
+	return matcher.BuildNetworkPolicies(s.NetworkPolicies)
+}
+
+// CrdPolicies builds the snapshot's policies as crd policies
+func (s *Snapshot) CrdPolicies() *crd.Policies {
+	return crd.BuildPolicies(s.NetworkPolicies)
+}
+
+// CrdUniverse is traffic between the snapshot's pods, and to and from external
+// IPs, on some ports -- for checking crd policies against the snapshot's policies
+func (s *Snapshot) CrdUniverse(externalIPs []string, ports []*matcher.PortProtocol) *crd.Universe {
+	return &crd.Universe{Inventory: s.Inventory, ExternalIPs: externalIPs, Ports: ports}
+}
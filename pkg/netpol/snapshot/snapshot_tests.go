@@ -0,0 +1,101 @@
+package snapshot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/crd"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/inventory"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/matcher"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func testSnapshot() *Snapshot {
+	return &Snapshot{
+		Version: Version,
+		Inventory: &inventory.Inventory{
+			Namespaces: []*inventory.Namespace{{Name: "default"}},
+			Nodes:      []*inventory.Node{{Name: "node-1", IPs: []string{"192.168.0.1"}}},
+			Pods: []*inventory.Pod{
+				{Namespace: "default", Name: "web", Labels: map[string]string{"app": "web"}, IP: "10.0.0.1", Node: "node-1",
+					ContainerPorts: []v1.ContainerPort{{ContainerPort: 80, Protocol: v1.ProtocolTCP}}},
+				{Namespace: "default", Name: "db", Labels: map[string]string{"app": "db"}, IP: "10.0.0.2", Node: "node-1",
+					ContainerPorts: []v1.ContainerPort{{ContainerPort: 80, Protocol: v1.ProtocolTCP}}},
+			},
+			Services: []*inventory.Service{{Namespace: "default", Name: "web", Selector: map[string]string{"app": "web"}}},
+		},
+		NetworkPolicies: []*networkingv1.NetworkPolicy{{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "allow-web-to-db"},
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{
+					From: []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}}},
+				}},
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			},
+		}},
+	}
+}
+
+func RunSnapshotTests() {
+	Describe("Snapshot files", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "snapshot")
+			Expect(err).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("round trips", func() {
+			path := filepath.Join(dir, "snapshot.yaml")
+			Expect(testSnapshot().WriteFile(path)).To(Succeed())
+
+			snap, err := ReadFile(path)
+			Expect(err).To(Succeed())
+			Expect(snap).To(Equal(testSnapshot()))
+		})
+
+		It("rejects other versions", func() {
+			path := filepath.Join(dir, "snapshot.yaml")
+			Expect(ioutil.WriteFile(path, []byte("version: v0\ninventory:\n  namespaces: []\n  pods: []\n"), 0644)).To(Succeed())
+
+			_, err := ReadFile(path)
+			Expect(err).To(MatchError(ContainSubstring("unsupported snapshot version 'v0'")))
+		})
+
+		It("rejects policies in unknown namespaces", func() {
+			snap := testSnapshot()
+			snap.NetworkPolicies[0].Namespace = "other"
+			Expect(snap.Validate()).To(MatchError("namespace other of network policy allow-web-to-db not found"))
+		})
+	})
+
+	Describe("Evaluating snapshots", func() {
+		It("feeds the matcher and the crd evaluator the same policies", func() {
+			snap := testSnapshot()
+			port := &matcher.PortProtocol{Protocol: v1.ProtocolTCP, Port: intstr.FromInt(80)}
+
+			policy := snap.MatcherPolicy()
+			web, db := snap.Inventory.Pods[0], snap.Inventory.Pods[1]
+			peer := func(pod *inventory.Pod) *matcher.TrafficPeer {
+				return matcher.InventoryPeer(snap.Inventory, pod)
+			}
+			Expect(policy.IsTrafficAllowed(&matcher.Traffic{Source: peer(web), Destination: peer(db), PortProtocol: port}).IsAllowed()).To(BeTrue())
+			Expect(policy.IsTrafficAllowed(&matcher.Traffic{Source: peer(db), Destination: peer(db), PortProtocol: port}).IsAllowed()).To(BeFalse())
+
+			universe := snap.CrdUniverse([]string{"1.2.3.4"}, []*matcher.PortProtocol{port})
+			Expect(crd.Verify(snap.NetworkPolicies, snap.CrdPolicies(), universe)).To(BeEmpty())
+		})
+	})
+}
@@ -0,0 +1,14 @@
+package snapshot
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestModel(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSnapshotTests()
+	RunSpecs(t, "network policy snapshot suite")
+}
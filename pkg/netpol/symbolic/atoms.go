@@ -0,0 +1,77 @@
+package symbolic
+
+import (
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/matcher"
+	"github.com/pkg/errors"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// atoms are everything that policies can tell traffic apart by: any two
+// traffics which agree on every atom get the same verdict
+type atoms struct {
+	Namespaces         []string
+	NamespaceSelectors []metav1.LabelSelector
+	PodSelectors       []metav1.LabelSelector
+	IPBlocks           []*networkingv1.IPBlock
+	Ports              []matcher.PortMatcher
+}
+
+func (a *atoms) addNamespace(ns string) {
+	for _, existing := range a.Namespaces {
+		if existing == ns {
+			return
+		}
+	}
+	a.Namespaces = append(a.Namespaces, ns)
+}
+
+func collectAtoms(policies ...*matcher.Policy) *atoms {
+	a := &atoms{}
+	for _, policy := range policies {
+		for _, targets := range []map[string]*matcher.Target{policy.Ingress, policy.Egress} {
+			for _, target := range targets {
+				a.addNamespace(target.Namespace)
+				a.PodSelectors = append(a.PodSelectors, target.PodSelector)
+				a.addEdge(target.Edge)
+			}
+		}
+	}
+	return a
+}
+
+func (a *atoms) addEdge(edge matcher.EdgeMatcher) {
+	switch e := edge.(type) {
+	case *matcher.EdgePeerPortMatcher:
+		for _, ppm := range e.Matchers {
+			a.addPeer(ppm.Peer)
+			a.Ports = append(a.Ports, ppm.Port)
+		}
+	case *matcher.NoneEdgeMatcher:
+	default:
+		panic(errors.Errorf("invalid EdgeMatcher type %T", edge))
+	}
+}
+
+func (a *atoms) addPeer(peer matcher.PeerMatcher) {
+	switch p := peer.(type) {
+	case *matcher.AllPodsInPolicyNamespacePeerMatcher:
+		a.addNamespace(p.Namespace)
+	case *matcher.AllPodsAllNamespacesPeerMatcher:
+	case *matcher.AllPodsInMatchingNamespacesPeerMatcher:
+		a.NamespaceSelectors = append(a.NamespaceSelectors, p.NamespaceSelector)
+	case *matcher.MatchingPodsInPolicyNamespacePeerMatcher:
+		a.addNamespace(p.Namespace)
+		a.PodSelectors = append(a.PodSelectors, p.PodSelector)
+	case *matcher.MatchingPodsInAllNamespacesPeerMatcher:
+		a.PodSelectors = append(a.PodSelectors, p.PodSelector)
+	case *matcher.MatchingPodsInMatchingNamespacesPeerMatcher:
+		a.NamespaceSelectors = append(a.NamespaceSelectors, p.NamespaceSelector)
+		a.PodSelectors = append(a.PodSelectors, p.PodSelector)
+	case *matcher.IPBlockPeerMatcher:
+		a.IPBlocks = append(a.IPBlocks, p.IPBlock)
+	case *matcher.AnywherePeerMatcher:
+	default:
+		panic(errors.Errorf("invalid PeerMatcher type %T", peer))
+	}
+}
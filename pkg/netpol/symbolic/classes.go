@@ -0,0 +1,316 @@
+package symbolic
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/mattfenwick/kube-prototypes/pkg/kube"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/matcher"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// labelClasses finds one set of labels for every combination of selector results
+// which some labels can produce.  Labels are built up one key at a time, trying
+// each value the selectors mention, a value they don't mention, and no value at
+// all.  Since selector requirements each look at a single key, label sets with
+// the same results so far are interchangeable, and only one of them is kept.
+//
+// The fixed labels are part of every label set.
+func labelClasses(selectors []metav1.LabelSelector, fixed map[string]string) []map[string]string {
+	type labelState struct {
+		Labels  map[string]string
+		Results []bool
+	}
+
+	keys, values := selectorKeysAndValues(selectors)
+	initial := &labelState{Labels: map[string]string{}, Results: make([]bool, len(selectors))}
+	for key, val := range fixed {
+		initial.Labels[key] = val
+	}
+	for i, selector := range selectors {
+		initial.Results[i] = true
+		for key := range fixed {
+			initial.Results[i] = initial.Results[i] && kube.IsLabelsMatchLabelSelector(initial.Labels, restrictSelector(selector, key))
+		}
+	}
+
+	states := []*labelState{initial}
+	for _, key := range keys {
+		if _, ok := fixed[key]; ok {
+			continue
+		}
+		choices := []*string{nil}
+		for _, val := range values[key] {
+			choice := val
+			choices = append(choices, &choice)
+		}
+		fresh := freshString("other", values[key])
+		choices = append(choices, &fresh)
+
+		var next []*labelState
+		seen := map[string]bool{}
+		for _, state := range states {
+			for _, choice := range choices {
+				labels := map[string]string{}
+				for k, v := range state.Labels {
+					labels[k] = v
+				}
+				if choice != nil {
+					labels[key] = *choice
+				}
+				results := make([]bool, len(selectors))
+				for i, selector := range selectors {
+					results[i] = state.Results[i] && kube.IsLabelsMatchLabelSelector(labels, restrictSelector(selector, key))
+				}
+				signature := fmt.Sprintf("%v", results)
+				if !seen[signature] {
+					seen[signature] = true
+					next = append(next, &labelState{Labels: labels, Results: results})
+				}
+			}
+		}
+		states = next
+	}
+
+	var classes []map[string]string
+	for _, state := range states {
+		classes = append(classes, state.Labels)
+	}
+	return classes
+}
+
+// selectorKeysAndValues finds the keys used by selectors, sorted, and the values used for each key
+func selectorKeysAndValues(selectors []metav1.LabelSelector) ([]string, map[string][]string) {
+	values := map[string][]string{}
+	add := func(key string, vals ...string) {
+		if _, ok := values[key]; !ok {
+			values[key] = []string{}
+		}
+		for _, val := range vals {
+			if !containsString(values[key], val) {
+				values[key] = append(values[key], val)
+			}
+		}
+	}
+	for _, selector := range selectors {
+		for key, val := range selector.MatchLabels {
+			add(key, val)
+		}
+		for _, exp := range selector.MatchExpressions {
+			add(exp.Key, exp.Values...)
+		}
+	}
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, values
+}
+
+// restrictSelector keeps only the requirements of a selector on a single key
+func restrictSelector(selector metav1.LabelSelector, key string) metav1.LabelSelector {
+	restricted := metav1.LabelSelector{}
+	if val, ok := selector.MatchLabels[key]; ok {
+		restricted.MatchLabels = map[string]string{key: val}
+	}
+	for _, exp := range selector.MatchExpressions {
+		if exp.Key == key {
+			restricted.MatchExpressions = append(restricted.MatchExpressions, exp)
+		}
+	}
+	return restricted
+}
+
+func containsString(strs []string, s string) bool {
+	for _, str := range strs {
+		if str == s {
+			return true
+		}
+	}
+	return false
+}
+
+// freshString finds a string starting with base which isn't taken
+func freshString(base string, taken []string) string {
+	fresh := base
+	for i := 1; containsString(taken, fresh); i++ {
+		fresh = fmt.Sprintf("%s-%d", base, i)
+	}
+	return fresh
+}
+
+type namespaceClass struct {
+	Name   string
+	Labels map[string]string
+}
+
+// namespaceClasses tries every namespace the policies mention, plus one they
+// don't.  Namespaces get a kubernetes.io/metadata.name label, as in a real
+// cluster, so that selectors on that label line up with namespace names.
+func namespaceClasses(a *atoms) []*namespaceClass {
+	names := append([]string{}, a.Namespaces...)
+	_, values := selectorKeysAndValues(a.NamespaceSelectors)
+	for _, name := range values[v1.LabelMetadataName] {
+		if !containsString(names, name) {
+			names = append(names, name)
+		}
+	}
+	names = append(names, freshString("other", names))
+
+	var classes []*namespaceClass
+	for _, name := range names {
+		for _, labels := range labelClasses(a.NamespaceSelectors, map[string]string{v1.LabelMetadataName: name}) {
+			classes = append(classes, &namespaceClass{Name: name, Labels: labels})
+		}
+	}
+	return classes
+}
+
+// ipClasses finds an IP in every region of the address spaces that the CIDRs of
+// IP blocks carve out.  Every region starts at the first address of a CIDR, right
+// after the last address of a CIDR, or at the start of an address space; of the
+// IPs at those points, one is kept for each combination of IP block results.
+func ipClasses(blocks []*networkingv1.IPBlock) []string {
+	candidates := []net.IP{net.IPv4zero.To4(), net.IPv6zero}
+	for _, block := range blocks {
+		for _, cidr := range append([]string{block.CIDR}, block.Except...) {
+			_, ipNet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				panic(err)
+			}
+			ones, bits := ipNet.Mask.Size()
+			start := new(big.Int).SetBytes(ipNet.IP)
+			end := new(big.Int).Add(start, new(big.Int).Lsh(big.NewInt(1), uint(bits-ones)))
+			candidates = append(candidates, ipNet.IP)
+			if end.BitLen() <= bits {
+				candidates = append(candidates, bigIntToIP(end, bits/8))
+			}
+		}
+	}
+
+	var ips []string
+	seen := map[string]bool{}
+	for _, candidate := range candidates {
+		ip := candidate.String()
+		var results []bool
+		for _, block := range blocks {
+			results = append(results, kube.IsIPBlockMatchForIP(ip, block))
+		}
+		signature := fmt.Sprintf("%v", results)
+		if !seen[signature] {
+			seen[signature] = true
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+func bigIntToIP(i *big.Int, length int) net.IP {
+	bytes := i.Bytes()
+	ip := make(net.IP, length)
+	copy(ip[length-len(bytes):], bytes)
+	return ip
+}
+
+// portClass is a port of traffic, along with the container port of the
+// destination that it resolves through, if any
+type portClass struct {
+	PortProtocol  *matcher.PortProtocol
+	ContainerPort *v1.ContainerPort
+}
+
+func (pc *portClass) String() string {
+	if pc.ContainerPort == nil {
+		return fmt.Sprintf("%s/%s", pc.PortProtocol.Port.String(), pc.PortProtocol.Protocol)
+	}
+	return fmt.Sprintf("%s/%s (container port %s)", pc.PortProtocol.Port.String(), pc.PortProtocol.Protocol, pc.ContainerPort.Name)
+}
+
+// portClasses tries every protocol, with every combination of: the port numbers
+// where a port matcher starts or stops matching, and the port names used by
+// port matchers.  A port with both a number and a name stands for traffic to a
+// numbered port which resolves to a named container port.  One port is kept for
+// each combination of port matcher results -- both when the destination's
+// container ports are used, and when they aren't, as for external destinations.
+func portClasses(portMatchers []matcher.PortMatcher) []*portClass {
+	numbers := []int{1}
+	var names []string
+	addNumber := func(n int) {
+		if n >= 1 && n <= 65535 && !containsInt(numbers, n) {
+			numbers = append(numbers, n)
+		}
+	}
+	for _, pm := range portMatchers {
+		switch p := pm.(type) {
+		case *matcher.ExactPortProtocolMatcher:
+			if p.Port.Type == intstr.Int {
+				addNumber(int(p.Port.IntVal))
+				addNumber(int(p.Port.IntVal) + 1)
+			} else if !containsString(names, p.Port.StrVal) {
+				names = append(names, p.Port.StrVal)
+			}
+		case *matcher.PortRangeMatcher:
+			addNumber(p.From)
+			addNumber(p.To + 1)
+		}
+	}
+	sort.Ints(numbers)
+	sort.Strings(names)
+	names = append([]string{""}, append(names, freshString("other", names))...)
+
+	var classes []*portClass
+	seen := map[string]bool{}
+	for _, protocol := range []v1.Protocol{v1.ProtocolTCP, v1.ProtocolUDP, v1.ProtocolSCTP} {
+		for _, number := range append([]int{0}, numbers...) {
+			for _, name := range names {
+				var class *portClass
+				var internal, external *matcher.ResolvedPort
+				switch {
+				case number == 0 && name == "":
+					continue
+				case number == 0:
+					class = &portClass{PortProtocol: &matcher.PortProtocol{Protocol: protocol, Port: intstr.FromString(name)}}
+					internal = &matcher.ResolvedPort{Protocol: protocol, Name: name}
+					external = internal
+				case name == "":
+					class = &portClass{PortProtocol: &matcher.PortProtocol{Protocol: protocol, Port: intstr.FromInt(number)}}
+					internal = &matcher.ResolvedPort{Protocol: protocol, Number: number}
+					external = internal
+				default:
+					class = &portClass{
+						PortProtocol:  &matcher.PortProtocol{Protocol: protocol, Port: intstr.FromInt(number)},
+						ContainerPort: &v1.ContainerPort{Name: name, ContainerPort: int32(number), Protocol: protocol},
+					}
+					internal = &matcher.ResolvedPort{Protocol: protocol, Number: number, Name: name}
+					external = &matcher.ResolvedPort{Protocol: protocol, Number: number}
+				}
+				var results []string
+				for _, pm := range portMatchers {
+					results = append(results, fmt.Sprintf("%t/%t", pm.Allows(internal), pm.Allows(external)))
+				}
+				signature := strings.Join(results, ",")
+				if !seen[signature] {
+					seen[signature] = true
+					classes = append(classes, class)
+				}
+			}
+		}
+	}
+	return classes
+}
+
+func containsInt(ints []int, i int) bool {
+	for _, n := range ints {
+		if n == i {
+			return true
+		}
+	}
+	return false
+}
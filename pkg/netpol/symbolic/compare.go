@@ -0,0 +1,202 @@
+package symbolic
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/matcher"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+type Relation string
+
+const (
+	RelationEqual        Relation = "Equal"
+	RelationSubset       Relation = "Subset"
+	RelationSuperset     Relation = "Superset"
+	RelationIncomparable Relation = "Incomparable"
+)
+
+// Comparison of the traffic allowed by two sets of policies, A and B
+type Comparison struct {
+	// Relation is how the traffic allowed by A relates to that allowed by B
+	Relation Relation
+	// OnlyA is traffic allowed by A but not by B, or nil if there's none
+	OnlyA *matcher.Traffic
+	// OnlyB is traffic allowed by B but not by A, or nil if there's none
+	OnlyB *matcher.Traffic
+}
+
+// CompareNetworkPolicies compares two sets of v1 NetworkPolicies, see Compare
+func CompareNetworkPolicies(a []*networkingv1.NetworkPolicy, b []*networkingv1.NetworkPolicy) *Comparison {
+	return Compare(matcher.BuildNetworkPolicies(a), matcher.BuildNetworkPolicies(b))
+}
+
+// Compare decides whether policies A allow the same traffic as policies B, or a
+// subset or superset of it -- for every possible cluster, not just for some
+// sample pods.
+//
+// Rather than enumerating pods, traffic is split into classes which no policy
+// of A or B can tell apart, by looking at what the policies can match on:
+// namespace names, namespace and pod label selectors, IP blocks, and ports and
+// protocols.  Checking a single traffic from each class then covers everything.
+// Some details:
+//   - namespaces are assumed to have the kubernetes.io/metadata.name label
+//   - named ports are resolved through a container port of the destination
+//   - traffic between two external IPs isn't checked, since policies never apply to it
+//   - each set is evaluated with its own CNIProfile.  Peers don't stand for
+//     particular pods or nodes, so only IPBlocksMatchPods makes a difference.
+func Compare(a *matcher.Policy, b *matcher.Policy) *Comparison {
+	universe := newUniverse(collectAtoms(a, b))
+	allowedByA, allowedByB := universe.evaluate(a), universe.evaluate(b)
+
+	comparison := &Comparison{}
+	for source := range universe.Peers {
+		for dest := range universe.Peers {
+			if universe.Peers[source].IsExternal() && universe.Peers[dest].IsExternal() {
+				continue
+			}
+			for port := range universe.Ports {
+				isAllowedByA := allowedByA.isAllowed(source, dest, port)
+				isAllowedByB := allowedByB.isAllowed(source, dest, port)
+				if isAllowedByA && !isAllowedByB && comparison.OnlyA == nil {
+					comparison.OnlyA = universe.traffic(source, dest, port)
+				} else if isAllowedByB && !isAllowedByA && comparison.OnlyB == nil {
+					comparison.OnlyB = universe.traffic(source, dest, port)
+				}
+			}
+		}
+		if comparison.OnlyA != nil && comparison.OnlyB != nil {
+			break
+		}
+	}
+
+	switch {
+	case comparison.OnlyA == nil && comparison.OnlyB == nil:
+		comparison.Relation = RelationEqual
+	case comparison.OnlyA == nil:
+		comparison.Relation = RelationSubset
+	case comparison.OnlyB == nil:
+		comparison.Relation = RelationSuperset
+	default:
+		comparison.Relation = RelationIncomparable
+	}
+	return comparison
+}
+
+// universe has a peer from every class of peers, and a port from every class of ports
+type universe struct {
+	Peers []*matcher.TrafficPeer
+	Ports []*portClass
+}
+
+func newUniverse(a *atoms) *universe {
+	ips := ipClasses(a.IPBlocks)
+	u := &universe{Ports: portClasses(a.Ports)}
+	for _, ns := range namespaceClasses(a) {
+		for _, podLabels := range labelClasses(a.PodSelectors, nil) {
+			for _, ip := range ips {
+				u.Peers = append(u.Peers, &matcher.TrafficPeer{
+					Internal: &matcher.InternalPeer{
+						PodLabels:       podLabels,
+						NamespaceLabels: ns.Labels,
+						Namespace:       ns.Name,
+					},
+					IP: ip,
+				})
+			}
+		}
+	}
+	for _, ip := range ips {
+		u.Peers = append(u.Peers, &matcher.TrafficPeer{IP: ip})
+	}
+	return u
+}
+
+func (u *universe) traffic(source int, dest int, port int) *matcher.Traffic {
+	destination := u.Peers[dest]
+	if !destination.IsExternal() && u.Ports[port].ContainerPort != nil {
+		internal := *destination.Internal
+		internal.ContainerPorts = []v1.ContainerPort{*u.Ports[port].ContainerPort}
+		destination = &matcher.TrafficPeer{Internal: &internal, IP: destination.IP}
+	}
+	return &matcher.Traffic{Source: u.Peers[source], Destination: destination, PortProtocol: u.Ports[port].PortProtocol}
+}
+
+// evaluation has the verdicts of a policy on every traffic of a universe.  The
+// verdict of a direction only depends on which targets apply to the pod at that
+// end, so it's worked out once for each set of targets rather than for each pod.
+type evaluation struct {
+	PortCount int
+	// EgressSets and IngressSets are the index of the set of targets applying to each peer
+	EgressSets  []int
+	IngressSets []int
+	// Egress and Ingress are the verdicts for each set of targets, by peer at the other end and port
+	Egress  [][]bool
+	Ingress [][]bool
+}
+
+func (e *evaluation) isAllowed(source int, dest int, port int) bool {
+	return e.Egress[e.EgressSets[source]][dest*e.PortCount+port] &&
+		e.Ingress[e.IngressSets[dest]][source*e.PortCount+port]
+}
+
+func (u *universe) evaluate(policy *matcher.Policy) *evaluation {
+	e := &evaluation{PortCount: len(u.Ports)}
+	e.EgressSets, e.Egress = u.evaluateDirection(policy, false)
+	e.IngressSets, e.Ingress = u.evaluateDirection(policy, true)
+	return e
+}
+
+func (u *universe) evaluateDirection(policy *matcher.Policy, isIngress bool) ([]int, [][]bool) {
+	profile := policy.GetCNIProfile()
+	var sets []int
+	var verdicts [][]bool
+	setIndexes := map[string]int{}
+	for target := range u.Peers {
+		var targets []*matcher.Target
+		if !u.Peers[target].IsExternal() {
+			internal := u.Peers[target].Internal
+			targets = policy.TargetsApplyingToPod(isIngress, internal.Namespace, internal.PodLabels)
+		}
+		key := targetsKey(targets)
+		if index, ok := setIndexes[key]; ok {
+			sets = append(sets, index)
+			continue
+		}
+		setIndexes[key] = len(verdicts)
+		sets = append(sets, len(verdicts))
+
+		setVerdicts := make([]bool, len(u.Peers)*len(u.Ports))
+		for peer := range u.Peers {
+			for port := range u.Ports {
+				// ports are resolved through the destination
+				source, dest := peer, target
+				if !isIngress {
+					source, dest = target, peer
+				}
+				resolved := u.traffic(source, dest, port).ResolvePort()
+				isAllowed := len(targets) == 0
+				for _, t := range targets {
+					if t.Edge.Allows(profile.IPBlockPeer(u.Peers[peer]), resolved) {
+						isAllowed = true
+						break
+					}
+				}
+				setVerdicts[peer*len(u.Ports)+port] = isAllowed
+			}
+		}
+		verdicts = append(verdicts, setVerdicts)
+	}
+	return sets, verdicts
+}
+
+func targetsKey(targets []*matcher.Target) string {
+	var keys []string
+	for _, target := range targets {
+		keys = append(keys, target.GetPrimaryKey())
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, "\n")
+}
@@ -0,0 +1,152 @@
+package symbolic
+
+import (
+	"github.com/mattfenwick/kube-prototypes/pkg/kube/netpol/examples"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol/matcher"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func isolateIngress(selector metav1.LabelSelector) *networkingv1.NetworkPolicy {
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "isolate", Namespace: "default"},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: selector,
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+		},
+	}
+}
+
+func allowIngressFromIPBlock(block *networkingv1.IPBlock) *networkingv1.NetworkPolicy {
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "allow-ip-block", Namespace: "default"},
+		Spec: networkingv1.NetworkPolicySpec{
+			Ingress:     []networkingv1.NetworkPolicyIngressRule{{From: []networkingv1.NetworkPolicyPeer{{IPBlock: block}}}},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+		},
+	}
+}
+
+func allowIngressOnPort(port intstr.IntOrString) *networkingv1.NetworkPolicy {
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "allow-port", Namespace: "default"},
+		Spec: networkingv1.NetworkPolicySpec{
+			Ingress:     []networkingv1.NetworkPolicyIngressRule{{Ports: []networkingv1.NetworkPolicyPort{{Port: &port}}}},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+		},
+	}
+}
+
+// compare compares policies, and checks that the counterexamples really are counterexamples
+func compare(a []*networkingv1.NetworkPolicy, b []*networkingv1.NetworkPolicy) *Comparison {
+	return comparePolicies(matcher.BuildNetworkPolicies(a), matcher.BuildNetworkPolicies(b))
+}
+
+func comparePolicies(policyA *matcher.Policy, policyB *matcher.Policy) *Comparison {
+	comparison := Compare(policyA, policyB)
+	if comparison.OnlyA != nil {
+		Expect(policyA.IsTrafficAllowed(comparison.OnlyA).IsAllowed()).To(BeTrue())
+		Expect(policyB.IsTrafficAllowed(comparison.OnlyA).IsAllowed()).To(BeFalse())
+	}
+	if comparison.OnlyB != nil {
+		Expect(policyA.IsTrafficAllowed(comparison.OnlyB).IsAllowed()).To(BeFalse())
+		Expect(policyB.IsTrafficAllowed(comparison.OnlyB).IsAllowed()).To(BeTrue())
+	}
+	return comparison
+}
+
+func RunCompareTests() {
+	Describe("Compare", func() {
+		web := map[string]string{"app": "web"}
+
+		It("finds equivalent ways of allowing all ingress", func() {
+			Expect(compare(
+				[]*networkingv1.NetworkPolicy{examples.AllowAllTo("default", web)},
+				[]*networkingv1.NetworkPolicy{examples.AllowAllTo_Version3("default", web)},
+			).Relation).To(Equal(RelationEqual))
+			Expect(compare(
+				[]*networkingv1.NetworkPolicy{examples.AllowAllTo_Version2("default", web)},
+				[]*networkingv1.NetworkPolicy{examples.AllowAllTo_Version4("default", web)},
+			).Relation).To(Equal(RelationEqual))
+		})
+
+		It("finds that an empty namespace selector leaves out external traffic", func() {
+			comparison := compare(
+				[]*networkingv1.NetworkPolicy{examples.AllowAllTo("default", web)},
+				[]*networkingv1.NetworkPolicy{examples.AllowAllTo_Version2("default", web)},
+			)
+			Expect(comparison.Relation).To(Equal(RelationSuperset))
+			Expect(comparison.OnlyA.Source.IsExternal()).To(BeTrue())
+		})
+
+		It("finds that restricting ports allows less", func() {
+			Expect(compare(
+				[]*networkingv1.NetworkPolicy{examples.AllowSpecificPortTo("default", map[string]string{"role": "monitoring"}, web, 5000)},
+				[]*networkingv1.NetworkPolicy{examples.AllowFromTo("default", map[string]string{"role": "monitoring"}, web)},
+			).Relation).To(Equal(RelationSubset))
+		})
+
+		It("finds pods which NotIn doesn't select, since they lack the key", func() {
+			Expect(compare(
+				[]*networkingv1.NetworkPolicy{isolateIngress(metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "app", Operator: metav1.LabelSelectorOpIn, Values: []string{"web"}},
+				}})},
+				[]*networkingv1.NetworkPolicy{isolateIngress(metav1.LabelSelector{MatchLabels: web})},
+			).Relation).To(Equal(RelationEqual))
+
+			comparison := compare(
+				[]*networkingv1.NetworkPolicy{isolateIngress(metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "app", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"db"}},
+				}})},
+				[]*networkingv1.NetworkPolicy{isolateIngress(metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "app", Operator: metav1.LabelSelectorOpExists},
+				}})},
+			)
+			Expect(comparison.Relation).To(Equal(RelationSuperset))
+			Expect(comparison.OnlyA.Destination.Internal.PodLabels).To(Equal(map[string]string{"app": "db"}))
+		})
+
+		It("finds IPs left out by an IP block's exceptions", func() {
+			comparison := compare(
+				[]*networkingv1.NetworkPolicy{allowIngressFromIPBlock(&networkingv1.IPBlock{CIDR: "10.0.0.0/8", Except: []string{"10.1.0.0/16"}})},
+				[]*networkingv1.NetworkPolicy{allowIngressFromIPBlock(&networkingv1.IPBlock{CIDR: "10.0.0.0/8"})},
+			)
+			Expect(comparison.Relation).To(Equal(RelationSubset))
+			Expect(comparison.OnlyB.Source.IP).To(Equal("10.1.0.0"))
+		})
+
+		It("finds that named and numbered ports differ, unless the name resolves to the number", func() {
+			comparison := compare(
+				[]*networkingv1.NetworkPolicy{allowIngressOnPort(intstr.FromString("http"))},
+				[]*networkingv1.NetworkPolicy{allowIngressOnPort(intstr.FromInt(80))},
+			)
+			Expect(comparison.Relation).To(Equal(RelationIncomparable))
+		})
+
+		It("takes each set's CNI profile into account", func() {
+			allowFromBlock := []*networkingv1.NetworkPolicy{allowIngressFromIPBlock(&networkingv1.IPBlock{CIDR: "10.0.0.0/8"})}
+			matchingPods := matcher.BuildNetworkPolicies(allowFromBlock)
+			notMatchingPods := matcher.BuildNetworkPolicies(allowFromBlock)
+			notMatchingPods.CNIProfile = &matcher.CNIProfile{IPBlocksMatchPods: false}
+
+			comparison := comparePolicies(matchingPods, notMatchingPods)
+			Expect(comparison.Relation).To(Equal(RelationSuperset))
+			Expect(comparison.OnlyA.Source.IsExternal()).To(BeFalse())
+			Expect(comparison.OnlyA.Source.IP).To(Equal("10.0.0.0"))
+
+			// if ipBlocks don't match pods, an ipBlock only lets external IPs through isolation
+			isolated := matcher.BuildNetworkPolicies([]*networkingv1.NetworkPolicy{isolateIngress(metav1.LabelSelector{})})
+			isolated.CNIProfile = notMatchingPods.CNIProfile
+			comparison = comparePolicies(notMatchingPods, isolated)
+			Expect(comparison.Relation).To(Equal(RelationSuperset))
+			Expect(comparison.OnlyA.Source.IsExternal()).To(BeTrue())
+		})
+
+		It("finds a policy set equal to itself", func() {
+			Expect(compare(examples.AllExamples, examples.AllExamples).Relation).To(Equal(RelationEqual))
+		})
+	})
+}
@@ -0,0 +1,14 @@
+package symbolic
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestModel(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunCompareTests()
+	RunSpecs(t, "network policy symbolic suite")
+}
@@ -1,9 +1,22 @@
 package utils
 
-import log "github.com/sirupsen/logrus"
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
 
 func DoOrDie(err error) {
 	if err != nil {
 		log.Fatalf("%+v", err)
 	}
 }
+
+func SetUpLogger(logLevelStr string) error {
+	logLevel, err := log.ParseLevel(logLevelStr)
+	if err != nil {
+		return errors.Wrapf(err, "unable to parse the specified log level: '%s'", logLevel)
+	}
+	log.SetLevel(logLevel)
+	log.Infof("log level set to '%s'", log.GetLevel())
+	return nil
+}
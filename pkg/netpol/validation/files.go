@@ -0,0 +1,45 @@
+package validation
+
+import (
+	"github.com/mattfenwick/kube-prototypes/pkg/kube"
+	"github.com/mattfenwick/kube-prototypes/pkg/netpol"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// ReadNetworkPolicyFiles reads policies from a file or directory, and treats them
+// the way the API server would treat applying them to a namespace.
+func ReadNetworkPolicyFiles(path string, namespace string) ([]*networkingv1.NetworkPolicy, error) {
+	policies, err := kube.ReadNetworkPoliciesFromPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return policies, AdmitNetworkPolicies(policies, namespace)
+}
+
+// ReadNetworkPolicyFilesFromGitRef is like ReadNetworkPolicyFiles, but reads the
+// files as they are at a git ref.
+func ReadNetworkPolicyFilesFromGitRef(ref string, path string, namespace string) ([]*networkingv1.NetworkPolicy, error) {
+	policies, err := kube.ReadNetworkPoliciesFromGitRef(ref, path)
+	if err != nil {
+		return nil, err
+	}
+	return policies, AdmitNetworkPolicies(policies, namespace)
+}
+
+// AdmitNetworkPolicies mimics 'kubectl apply -n <namespace>' for policies which
+// didn't come from a cluster: policies without a namespace are put in the given
+// one -- or the default namespace, if it's empty -- their policy types are
+// defaulted, and they're validated.
+func AdmitNetworkPolicies(policies []*networkingv1.NetworkPolicy, namespace string) error {
+	if namespace == v1.NamespaceAll {
+		namespace = v1.NamespaceDefault
+	}
+	for _, policy := range policies {
+		if policy.Namespace == "" {
+			policy.Namespace = namespace
+		}
+		policy.Spec.PolicyTypes = netpol.PolicyTypes(policy)
+	}
+	return ValidateNetworkPolicies(policies)
+}
@@ -0,0 +1,14 @@
+package validation
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestModel(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunValidationTests()
+	RunSpecs(t, "network policy validation suite")
+}
@@ -0,0 +1,158 @@
+package validation
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apimachineryvalidation "k8s.io/apimachinery/pkg/api/validation"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
+	utilvalidation "k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// This mirrors the API server's validation of network policies, in
+// k8s.io/kubernetes/pkg/apis/networking/validation, which can't be imported
+// from here.  The builders assume policies are valid, so policies which didn't
+// come from a cluster should be validated before building them.
+
+var supportedProtocols = sets.NewString(string(v1.ProtocolTCP), string(v1.ProtocolUDP), string(v1.ProtocolSCTP))
+
+var supportedPolicyTypes = sets.NewString(string(networkingv1.PolicyTypeIngress), string(networkingv1.PolicyTypeEgress))
+
+// ValidateNetworkPolicies validates each policy, and aggregates the errors of
+// every invalid policy
+func ValidateNetworkPolicies(netpols []*networkingv1.NetworkPolicy) error {
+	var errs []error
+	for _, netpol := range netpols {
+		if allErrs := ValidateNetworkPolicy(netpol); len(allErrs) > 0 {
+			errs = append(errs, errors.Wrapf(allErrs.ToAggregate(), "invalid network policy %s/%s", netpol.Namespace, netpol.Name))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func ValidateNetworkPolicy(netpol *networkingv1.NetworkPolicy) field.ErrorList {
+	allErrs := apimachineryvalidation.ValidateObjectMeta(&netpol.ObjectMeta, true, apimachineryvalidation.NameIsDNSSubdomain, field.NewPath("metadata"))
+	return append(allErrs, ValidateNetworkPolicySpec(&netpol.Spec, field.NewPath("spec"))...)
+}
+
+func ValidateNetworkPolicySpec(spec *networkingv1.NetworkPolicySpec, fldPath *field.Path) field.ErrorList {
+	allErrs := metav1validation.ValidateLabelSelector(&spec.PodSelector, fldPath.Child("podSelector"))
+
+	for i, ingress := range spec.Ingress {
+		ingressPath := fldPath.Child("ingress").Index(i)
+		for j, port := range ingress.Ports {
+			allErrs = append(allErrs, ValidateNetworkPolicyPort(&port, ingressPath.Child("ports").Index(j))...)
+		}
+		for j, peer := range ingress.From {
+			allErrs = append(allErrs, ValidateNetworkPolicyPeer(&peer, ingressPath.Child("from").Index(j))...)
+		}
+	}
+	for i, egress := range spec.Egress {
+		egressPath := fldPath.Child("egress").Index(i)
+		for j, port := range egress.Ports {
+			allErrs = append(allErrs, ValidateNetworkPolicyPort(&port, egressPath.Child("ports").Index(j))...)
+		}
+		for j, peer := range egress.To {
+			allErrs = append(allErrs, ValidateNetworkPolicyPeer(&peer, egressPath.Child("to").Index(j))...)
+		}
+	}
+
+	for i, policyType := range spec.PolicyTypes {
+		if !supportedPolicyTypes.Has(string(policyType)) {
+			allErrs = append(allErrs, field.NotSupported(fldPath.Child("policyTypes").Index(i), policyType, supportedPolicyTypes.List()))
+		}
+	}
+	if len(spec.PolicyTypes) > len(supportedPolicyTypes) {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("policyTypes"), spec.PolicyTypes, "may not specify more than two policyTypes"))
+	}
+	return allErrs
+}
+
+func ValidateNetworkPolicyPort(port *networkingv1.NetworkPolicyPort, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if port.Protocol != nil && !supportedProtocols.Has(string(*port.Protocol)) {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("protocol"), *port.Protocol, supportedProtocols.List()))
+	}
+	if port.Port != nil {
+		if port.Port.Type == intstr.Int {
+			for _, msg := range utilvalidation.IsValidPortNum(int(port.Port.IntVal)) {
+				allErrs = append(allErrs, field.Invalid(fldPath.Child("port"), port.Port.IntVal, msg))
+			}
+			if port.EndPort != nil {
+				if *port.EndPort < port.Port.IntVal {
+					allErrs = append(allErrs, field.Invalid(fldPath.Child("endPort"), *port.EndPort, "must be greater than or equal to `port`"))
+				}
+				for _, msg := range utilvalidation.IsValidPortNum(int(*port.EndPort)) {
+					allErrs = append(allErrs, field.Invalid(fldPath.Child("endPort"), *port.EndPort, msg))
+				}
+			}
+		} else {
+			if port.EndPort != nil {
+				allErrs = append(allErrs, field.Invalid(fldPath.Child("endPort"), *port.EndPort, "may not be specified when `port` is non-numeric"))
+			}
+			for _, msg := range utilvalidation.IsValidPortName(port.Port.StrVal) {
+				allErrs = append(allErrs, field.Invalid(fldPath.Child("port"), port.Port.StrVal, msg))
+			}
+		}
+	} else if port.EndPort != nil {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("endPort"), *port.EndPort, "may not be specified when `port` is not specified"))
+	}
+	return allErrs
+}
+
+func ValidateNetworkPolicyPeer(peer *networkingv1.NetworkPolicyPeer, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	numPeers := 0
+	if peer.PodSelector != nil {
+		numPeers++
+		allErrs = append(allErrs, metav1validation.ValidateLabelSelector(peer.PodSelector, fldPath.Child("podSelector"))...)
+	}
+	if peer.NamespaceSelector != nil {
+		numPeers++
+		allErrs = append(allErrs, metav1validation.ValidateLabelSelector(peer.NamespaceSelector, fldPath.Child("namespaceSelector"))...)
+	}
+	if peer.IPBlock != nil {
+		numPeers++
+		allErrs = append(allErrs, ValidateIPBlock(peer.IPBlock, fldPath.Child("ipBlock"))...)
+	}
+
+	switch {
+	case numPeers == 0:
+		allErrs = append(allErrs, field.Required(fldPath, "must specify a peer"))
+	case numPeers > 1 && peer.IPBlock != nil:
+		allErrs = append(allErrs, field.Forbidden(fldPath, "may not specify both ipBlock and another peer"))
+	}
+	return allErrs
+}
+
+func ValidateIPBlock(ipb *networkingv1.IPBlock, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if ipb.CIDR == "" {
+		return append(allErrs, field.Required(fldPath.Child("cidr"), ""))
+	}
+	_, cidrNet, err := net.ParseCIDR(ipb.CIDR)
+	if err != nil {
+		return append(allErrs, field.Invalid(fldPath.Child("cidr"), ipb.CIDR, fmt.Sprintf("must be a valid CIDR: %s", err)))
+	}
+	cidrMaskLen, _ := cidrNet.Mask.Size()
+	for i, except := range ipb.Except {
+		exceptPath := fldPath.Child("except").Index(i)
+		_, exceptNet, err := net.ParseCIDR(except)
+		if err != nil {
+			allErrs = append(allErrs, field.Invalid(exceptPath, except, fmt.Sprintf("must be a valid CIDR: %s", err)))
+			continue
+		}
+		exceptMaskLen, _ := exceptNet.Mask.Size()
+		if !cidrNet.Contains(exceptNet.IP) || cidrMaskLen >= exceptMaskLen {
+			allErrs = append(allErrs, field.Invalid(exceptPath, except, "must be a strict subset of `cidr`"))
+		}
+	}
+	return allErrs
+}
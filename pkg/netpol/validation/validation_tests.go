@@ -0,0 +1,152 @@
+package validation
+
+import (
+	"github.com/mattfenwick/kube-prototypes/pkg/kube/netpol/examples"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func validationPolicy(ingress ...networkingv1.NetworkPolicyIngressRule) *networkingv1.NetworkPolicy {
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "policy"},
+		Spec: networkingv1.NetworkPolicySpec{
+			Ingress:     ingress,
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+		},
+	}
+}
+
+func validationErrorFields(errs field.ErrorList) []string {
+	var fields []string
+	for _, err := range errs {
+		fields = append(fields, err.Field)
+	}
+	return fields
+}
+
+func RunValidationTests() {
+	Describe("ValidateNetworkPolicy", func() {
+		It("accepts every example", func() {
+			Expect(ValidateNetworkPolicies(examples.AllExamples)).To(Succeed())
+		})
+
+		It("rejects malformed label selectors", func() {
+			netpol := validationPolicy(networkingv1.NetworkPolicyIngressRule{
+				From: []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"app": "not a valid value"},
+					MatchExpressions: []metav1.LabelSelectorRequirement{
+						{Key: "tier", Operator: metav1.LabelSelectorOpIn},
+					},
+				}}},
+			})
+
+			Expect(validationErrorFields(ValidateNetworkPolicy(netpol))).To(Equal([]string{
+				"spec.ingress[0].from[0].podSelector.matchLabels",
+				"spec.ingress[0].from[0].podSelector.matchExpressions[0].values",
+			}))
+		})
+
+		It("rejects invalid CIDRs and excepts outside of the CIDR", func() {
+			netpol := validationPolicy(networkingv1.NetworkPolicyIngressRule{
+				From: []networkingv1.NetworkPolicyPeer{
+					{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/33"}},
+					{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/16", Except: []string{"10.1.0.0/24", "10.0.0.0/16", "10.0.1.0/24"}}},
+					{IPBlock: &networkingv1.IPBlock{}},
+				},
+			})
+
+			Expect(validationErrorFields(ValidateNetworkPolicy(netpol))).To(Equal([]string{
+				"spec.ingress[0].from[0].ipBlock.cidr",
+				"spec.ingress[0].from[1].ipBlock.except[0]",
+				"spec.ingress[0].from[1].ipBlock.except[1]",
+				"spec.ingress[0].from[2].ipBlock.cidr",
+			}))
+		})
+
+		It("rejects peers with an ipBlock and selectors, and empty peers", func() {
+			netpol := validationPolicy(networkingv1.NetworkPolicyIngressRule{
+				From: []networkingv1.NetworkPolicyPeer{
+					{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/16"}, PodSelector: &metav1.LabelSelector{}},
+					{},
+					{PodSelector: &metav1.LabelSelector{}, NamespaceSelector: &metav1.LabelSelector{}},
+				},
+			})
+
+			errs := ValidateNetworkPolicy(netpol)
+			Expect(validationErrorFields(errs)).To(Equal([]string{"spec.ingress[0].from[0]", "spec.ingress[0].from[1]"}))
+			Expect(errs[0].Type).To(Equal(field.ErrorTypeForbidden))
+			Expect(errs[1].Type).To(Equal(field.ErrorTypeRequired))
+		})
+
+		It("rejects invalid port and protocol combinations", func() {
+			icmp := v1.Protocol("ICMP")
+			tooBig, named, tooLong := intstr.FromInt(70000), intstr.FromString("http"), intstr.FromString("much-too-long-port-name")
+			low, high := intstr.FromInt(80), int32(79)
+			netpol := validationPolicy(networkingv1.NetworkPolicyIngressRule{
+				Ports: []networkingv1.NetworkPolicyPort{
+					{Protocol: &icmp},
+					{Port: &tooBig},
+					{Port: &named, EndPort: &high},
+					{Port: &low, EndPort: &high},
+					{EndPort: &high},
+					{Port: &tooLong},
+				},
+			})
+
+			Expect(validationErrorFields(ValidateNetworkPolicy(netpol))).To(Equal([]string{
+				"spec.ingress[0].ports[0].protocol",
+				"spec.ingress[0].ports[1].port",
+				"spec.ingress[0].ports[2].endPort",
+				"spec.ingress[0].ports[3].endPort",
+				"spec.ingress[0].ports[4].endPort",
+				"spec.ingress[0].ports[5].port",
+			}))
+		})
+
+		It("rejects unknown policy types and missing names", func() {
+			netpol := validationPolicy()
+			netpol.Name = ""
+			netpol.Spec.PolicyTypes = []networkingv1.PolicyType{"Sideways"}
+
+			Expect(validationErrorFields(ValidateNetworkPolicy(netpol))).To(Equal([]string{"metadata.name", "spec.policyTypes[0]"}))
+		})
+
+		It("aggregates errors by policy", func() {
+			invalid := validationPolicy(networkingv1.NetworkPolicyIngressRule{
+				From: []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: "nope"}}},
+			})
+			err := ValidateNetworkPolicies([]*networkingv1.NetworkPolicy{examples.AllowAllIngress, invalid})
+
+			Expect(err).ToNot(Succeed())
+			Expect(err.Error()).To(HavePrefix("invalid network policy default/policy: spec.ingress[0].from[0].ipBlock.cidr: Invalid value"))
+		})
+	})
+
+	Describe("AdmitNetworkPolicies", func() {
+		It("defaults namespaces and policy types before validating", func() {
+			noNamespace := &networkingv1.NetworkPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "no-namespace"},
+				Spec:       networkingv1.NetworkPolicySpec{Egress: []networkingv1.NetworkPolicyEgressRule{{}}},
+			}
+			otherNamespace := validationPolicy()
+			otherNamespace.Namespace = "other"
+
+			Expect(AdmitNetworkPolicies([]*networkingv1.NetworkPolicy{noNamespace, otherNamespace}, v1.NamespaceAll)).To(Succeed())
+			Expect(noNamespace.Namespace).To(Equal(v1.NamespaceDefault))
+			Expect(noNamespace.Spec.PolicyTypes).To(Equal([]networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress}))
+			Expect(otherNamespace.Namespace).To(Equal("other"))
+		})
+
+		It("rejects invalid policies", func() {
+			invalid := validationPolicy(networkingv1.NetworkPolicyIngressRule{
+				From: []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: "nope"}}},
+			})
+			Expect(AdmitNetworkPolicies([]*networkingv1.NetworkPolicy{invalid}, "prod")).ToNot(Succeed())
+		})
+	})
+}